@@ -1,6 +1,7 @@
 #!/usr/bin/env python3
 
 import copy
+import fcntl
 import os
 import re
 import sys
@@ -17,6 +18,7 @@ import string
 import tempfile
 import json
 from multiprocessing.pool import ThreadPool
+from contextlib import contextmanager
 
 secret_url = os.path.expandvars("$SECRET_URL").strip().rstrip("/")
 secret_key = os.path.expandvars("$SECRET_KEY")
@@ -181,6 +183,17 @@ def curl_with_auth(
     )
 
 
+@contextmanager
+def keychain_list_lock():
+    # the keychain search list is shared by all jobs, so parallel jobs must not update it at the same time
+    with open(Path(tempfile.gettempdir()) / "ios-signer-keychains.lock", "w") as lock_file:
+        fcntl.flock(lock_file, fcntl.LOCK_EX)
+        try:
+            yield
+        finally:
+            fcntl.flock(lock_file, fcntl.LOCK_UN)
+
+
 def security_get_keychain_list():
     return map(
         lambda x: x.strip('"'),
@@ -189,19 +202,21 @@ def security_get_keychain_list():
 
 
 def security_remove_keychain(keychain: str):
-    keychains = security_get_keychain_list()
-    keychains = filter(lambda x: keychain not in x, keychains)
-    run_process("security", "list-keychains", "-d", "user", "-s", *keychains)
+    with keychain_list_lock():
+        keychains = security_get_keychain_list()
+        keychains = filter(lambda x: keychain not in x, keychains)
+        run_process("security", "list-keychains", "-d", "user", "-s", *keychains)
     run_process("security", "delete-keychain", keychain)
 
 
 def security_import(cert: Path, cert_pass: str, keychain: str) -> List[str]:
     password = "1234"
-    keychains = [*security_get_keychain_list(), keychain]
     run_process("security", "create-keychain", "-p", password, keychain)
     run_process("security", "unlock-keychain", "-p", password, keychain)
     run_process("security", "set-keychain-settings", keychain)
-    run_process("security", "list-keychains", "-d", "user", "-s", *keychains)
+    with keychain_list_lock():
+        keychains = [*security_get_keychain_list(), keychain]
+        run_process("security", "list-keychains", "-d", "user", "-s", *keychains)
     run_process("security", "import", str(cert), "-P", cert_pass, "-A", "-k", keychain)
     run_process(
         "security",
@@ -1400,7 +1415,8 @@ if __name__ == "__main__":
     if user_bundle_id.strip() == "":
         user_bundle_id = None
     team_id = read_file("team_id.txt")
-    keychain_name = "ios-signer-" + rand_str(8)
+    # the integrated builder names the keychain, so that it can remove it if this script is killed
+    keychain_name = os.environ.get("KEYCHAIN_NAME") or "ios-signer-" + rand_str(8)
 
     # Check if unsigned.ipa already exists (integrated builder mode)
     unsigned_ipa = Path("unsigned.ipa")
@@ -1420,9 +1436,10 @@ if __name__ == "__main__":
         print("Cleaning up...")
         security_remove_keychain(keychain_name)
         if failed:
-            # Try to notify server about failure, but don't fail if server is not available (CLI mode)
-            try:
-                curl_with_auth(f"{secret_url}/jobs/{job_id}/fail", check=False)
-            except Exception as e:
-                print(f"Warning: Failed to notify server about failure (this is normal in CLI mode): {e}")
+            # In integrated builder mode, the Go process records the failure together with its reason
+            if not integrated_builder:
+                try:
+                    curl_with_auth(f"{secret_url}/jobs/{job_id}/fail", check=False)
+                except Exception as e:
+                    print(f"Warning: Failed to notify server about failure: {e}")
             sys.exit(1)
@@ -7,7 +7,7 @@ toolchain go1.24.1
 require (
 	github.com/ViRb3/koanf-extra v0.0.0-20241224160111-fad8e9827c5f
 	github.com/ViRb3/sling/v2 v2.0.2
-	github.com/elliotchance/orderedmap v1.8.0
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/galecore/xslog v0.0.0-20230717081035-da7669fe4648
 	github.com/google/uuid v1.6.0
 	github.com/knadh/koanf v1.5.0
@@ -32,7 +32,6 @@ replace (
 
 require (
 	github.com/fatih/structs v1.1.0 // indirect
-	github.com/fsnotify/fsnotify v1.4.9 // indirect
 	github.com/google/go-querystring v1.0.0 // indirect
 	github.com/jba/slog v0.0.0-20230403194657-e1c00ce43c8a // indirect
 	github.com/kr/text v0.2.0 // indirect
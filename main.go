@@ -30,13 +30,26 @@ import (
 	"LocalSignTools/src/assets"
 	"LocalSignTools/src/builders"
 	"LocalSignTools/src/config"
+	"LocalSignTools/src/events"
+	"LocalSignTools/src/expiry"
+	"LocalSignTools/src/health"
+	"LocalSignTools/src/metrics"
+	"LocalSignTools/src/migration"
+	"LocalSignTools/src/provisioning"
+	"LocalSignTools/src/resign"
 	"LocalSignTools/src/server"
 	"LocalSignTools/src/signing"
 	"LocalSignTools/src/storage"
 	"LocalSignTools/src/tunnel"
 	"LocalSignTools/src/util"
+	"LocalSignTools/src/webhooks"
 	"archive/tar"
 	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
 	"encoding/xml"
 	"flag"
 	"fmt"
@@ -58,11 +71,15 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	textTemplate "text/template"
 	"time"
 )
@@ -108,6 +125,7 @@ func main() {
 	signArgs := flag.String("args", "", "Signing arguments (optional, e.g., '-a -d')")
 	userBundleID := flag.String("bundle-id", "", "Custom bundle ID (optional)")
 	builderID := flag.String("builder", "", "Builder ID (optional, defaults to 'Integrated')")
+	cancelAppId := flag.String("cancel", "", "Cancel the queued or running sign job of the app with this ID on the running server")
 	
 	flag.Parse()
 
@@ -124,9 +142,16 @@ func main() {
 	
 	switch {
 	case *ngrokHost != "":
-		config.Current.ServerUrl = getPublicUrlFatal(&tunnel.Ngrok{Host: *ngrokHost, Proto: "https"})
+		config.OverrideServerUrl(getPublicUrlFatal(&tunnel.Ngrok{Host: *ngrokHost, Proto: "https"}))
 	case *cloudflaredHost != "":
-		config.Current.ServerUrl = getPublicUrlFatal(&tunnel.Cloudflare{Host: *cloudflaredHost})
+		config.OverrideServerUrl(getPublicUrlFatal(&tunnel.Cloudflare{Host: *cloudflaredHost}))
+	}
+
+	if *cancelAppId != "" {
+		if err := signing.CancelRemoteJob(*cancelAppId); err != nil {
+			log.Fatal().Err(err).Msg("cancel job")
+		}
+		os.Exit(0)
 	}
 
 	if *headless {
@@ -152,7 +177,7 @@ func main() {
 		os.Exit(0)
 	}
 
-	log.Info().Str("url", config.Current.ServerUrl).Msg("using server url")
+	log.Info().Str("url", config.Current.File().ServerUrl).Msg("using server url")
 	serve(*host, *port)
 }
 
@@ -160,37 +185,16 @@ func main() {
 func checkDependencies() {
 	var missing []string
 	var warnings []string
-
-	// Check fastlane
-	if _, err := exec.LookPath("fastlane"); err != nil {
-		missing = append(missing, "fastlane")
-		log.Warn().Msg("fastlane not found. Install with: brew install fastlane or gem install fastlane")
-	}
-
-	// Check Python3
-	if _, err := exec.LookPath("python3"); err != nil {
-		missing = append(missing, "python3")
-		log.Warn().Msg("python3 not found. Python 3 is typically included with macOS. Please install manually if needed.")
-	}
-
-	// Check Node.js
-	if _, err := exec.LookPath("node"); err != nil {
-		missing = append(missing, "node")
-		log.Warn().Msg("Node.js not found. Install with: brew install node")
-	} else {
-		// Check npm (usually comes with Node.js)
-		if _, err := exec.LookPath("npm"); err != nil {
-			warnings = append(warnings, "npm")
-			log.Warn().Msg("npm not found. npm usually comes with Node.js. Please ensure Node.js is properly installed.")
+	for _, check := range health.CheckDependencies() {
+		switch check.Status {
+		case health.StatusFail:
+			missing = append(missing, strings.TrimPrefix(check.Name, "command:"))
+		case health.StatusWarn:
+			warnings = append(warnings, strings.TrimPrefix(check.Name, "command:"))
+		default:
+			continue
 		}
-	}
-
-	// Check npm dependencies
-	nodeUtilsDir := "builder/node-utils"
-	nodeModulesDir := filepath.Join(nodeUtilsDir, "node_modules")
-	if _, err := os.Stat(nodeModulesDir); os.IsNotExist(err) {
-		warnings = append(warnings, "npm dependencies")
-		log.Warn().Str("dir", nodeUtilsDir).Msg("npm dependencies not installed. They will be installed automatically when signing, or run: npm install --prefix builder/node-utils")
+		log.Warn().Msg(check.Message)
 	}
 
 	// Log summary
@@ -216,24 +220,41 @@ func getPublicUrlFatal(provider tunnel.Provider) string {
 }
 
 func serve(host string, port uint64) {
-	if err := os.MkdirAll(config.Current.SaveDir, 0700); err != nil {
+	if err := os.MkdirAll(config.Current.File().SaveDir, 0700); err != nil {
 		log.Fatal().Err(err).Send()
 	}
 
-	interval := time.Duration(config.Current.CleanupIntervalMins) * time.Minute
-	timeout := time.Duration(config.Current.SignTimeoutMins) * time.Minute
+	if err := storage.Jobs.Restore(); err != nil {
+		log.Fatal().Err(err).Msg("restore jobs")
+	}
 	
 	// Run initial cleanup on startup
-	storage.Jobs.Cleanup(timeout)
-	storage.Uploads.Cleanup(timeout)
+	cleanup()
 	
-	// Then run periodic cleanup
+	// Closed on shutdown to stop the background tasks
+	stopTasks := make(chan bool)
+
+	// Then run periodic cleanup, reading the interval every time as it may be reloaded
 	go func() {
-		for range time.Tick(interval) {
-			storage.Jobs.Cleanup(timeout)
-			storage.Uploads.Cleanup(timeout)
+		for {
+			select {
+			case <-time.After(time.Duration(config.Current.File().CleanupIntervalMins) * time.Minute):
+				cleanup()
+			case <-stopTasks:
+				return
+			}
 		}
 	}()
+	go reloadOnSignal(stopTasks)
+	if config.Current.File().WatchProfiles {
+		if err := storage.WatchProfiles(stopTasks); err != nil {
+			log.Fatal().Err(err).Msg("watch profiles")
+		}
+	}
+
+	stopWebhooks := webhooks.Start()
+	defer stopWebhooks()
+	expiry.Start(stopTasks)
 
 	log.Info().Msg("setting builder secrets")
 	for _, builder := range config.Current.Builder {
@@ -245,62 +266,116 @@ func serve(host string, port uint64) {
 			// Create adapters to avoid circular imports
 			jobAdapter := &storage.JobStorageAdapter{}
 			appAdapter := &storage.AppStorageAdapter{}
-			integrated.SetProcessJobFn(func() error {
+			integrated.SetProcessJobFn(func() (bool, error) {
 				return builders.ProcessIntegratedJob(integrated, jobAdapter, appAdapter, storage.ErrNotFound)
 			})
 		}
 	}
+	resumePendingJobs()
+	// Re-signs are queued with the bulk priority, so they don't hold up apps that someone is waiting for
+	resign.Start(stopTasks, func(app storage.App) error {
+		if draining.Load() {
+			return errors.New("server is shutting down")
+		}
+		return queueReplacingResign(app, storage.JobPriorityBulk)
+	})
 
 	e := echo.New()
 	e.HideBanner = true
 	logger := lecho.From(log.Logger, lecho.WithLevel(log2.INFO))
 	e.Logger = logger
 	e.Use(lecho.Middleware(lecho.Config{Logger: logger}))
+	e.Use(metrics.Middleware)
 
 	forcedBasicAuth := middleware.BasicAuth(func(username string, password string, c echo.Context) (bool, error) {
-		return username == config.Current.BasicAuth.Username && password == config.Current.BasicAuth.Password, nil
+		basicAuth := config.Current.File().BasicAuth
+		return username == basicAuth.Username && password == basicAuth.Password, nil
 	})
+	// Checked on every request, so that reloading the config can turn it on or off
 	basicAuth := func(f echo.HandlerFunc) echo.HandlerFunc {
-		if config.Current.BasicAuth.Enable {
-			return forcedBasicAuth(f)
-		} else {
-			return f
+		forced := forcedBasicAuth(f)
+		return func(c echo.Context) error {
+			if config.Current.File().BasicAuth.Enable {
+				return forced(c)
+			}
+			return f(c)
 		}
 	}
 	workflowKeyAuth := middleware.KeyAuth(func(s string, c echo.Context) (bool, error) {
 		return s == config.Current.BuilderKey, nil
 	})
 
-	if config.Current.RedirectHttps {
+	if config.Current.File().RedirectHttps {
 		e.Pre(middleware.HTTPSRedirectWithConfig(middleware.RedirectConfig{
 			Code: 302,
 		}))
 	}
 
+	e.GET("/healthz", getHealth)
+	e.GET("/readyz", getReadiness)
 	e.GET("/", renderIndex, basicAuth)
 	e.GET("/favicon.png", getFavIcon, basicAuth)
-	e.POST("/apps", uploadUnsignedApp, basicAuth)
+	e.POST("/apps", uploadUnsignedApp, basicAuth, rejectWhileDraining)
 	getAndHead(e, "/apps/:id/signed", appResolver(getSignedApp), appResolver(getSignedApp))
 	getAndHead(e, "/apps/:id/tweaks", appResolver(getTweaks), appResolver(getEmpty200App))
 	getAndHead(e, "/apps/:id/unsigned", appResolver(getUnsignedApp), appResolver(getUnsignedApp))
 	e.GET("/apps/:id/install", appResolver(renderInstall))
 	e.GET("/apps/:id/manifest", appResolver(getManifest))
-	e.GET("/apps/:id/resign", appResolver(resignApp), basicAuth)
+	e.GET("/manifest", getMultiManifest)
+	e.GET("/apps/:id/icon", appResolver(getAppIcon))
+	e.GET("/apps/:id/resign", appResolver(resignApp), basicAuth, rejectWhileDraining)
+	e.GET("/apps/:id/cancel", appResolver(cancelApp), basicAuth)
 	e.GET("/apps/:id/delete", appResolver(deleteApp), basicAuth)
 	e.GET("/apps/:id/rename", appResolver(renderRenameApp), basicAuth)
 	e.POST("/apps/:id/rename", appResolver(renameApp), basicAuth)
 	e.GET("/apps/:id/2fa", appResolver(render2FAPage), basicAuth)
 	e.POST("/apps/:id/2fa", appResolver(set2FA), basicAuth)
-	getAndHead(e, "/jobs", getLastJob, getEmpty200, workflowKeyAuth)
+	e.GET("/apps/:id/jobs", appResolver(getJobHistory), basicAuth)
+	e.GET("/apps/:id/info", appResolver(getAppInfo), basicAuth)
+	e.GET("/apps/:id/logs/:jobId", appResolver(getJobLog), basicAuth)
+	e.GET("/apps/:id/auto-resign", appResolver(renderAppResign), basicAuth)
+	e.POST("/apps/:id/auto-resign", appResolver(setAppResign), basicAuth)
+	e.GET("/resign/history", getResignHistory, basicAuth)
+	e.GET("/events", streamEvents, basicAuth)
+	if config.Current.File().Metrics.Enable {
+		registerMetrics()
+		e.GET("/metrics", getMetrics, metricsAuth)
+	}
+	e.POST("/admin/reload", reloadConfig, basicAuth)
+	e.GET("/profiles", renderProfiles, basicAuth)
+	e.GET("/profiles/list", getProfiles, basicAuth)
+	e.GET("/profiles/expiring", getExpiringProfiles, basicAuth)
+	e.GET("/profiles/new", renderProfileForm, basicAuth)
+	e.POST("/profiles", createProfile, basicAuth)
+	e.GET("/profiles/:id", renderProfileDetails, basicAuth)
+	e.GET("/profiles/:id/details", getProfileDetails, basicAuth)
+	e.GET("/profiles/:id/edit", renderProfileForm, basicAuth)
+	e.POST("/profiles/:id", updateProfile, basicAuth)
+	e.GET("/profiles/:id/delete", deleteProfile, basicAuth)
+	e.GET("/profiles/:id/auto-resign", renderProfileResign, basicAuth)
+	e.POST("/profiles/:id/auto-resign", setProfileResign, basicAuth)
+	e.GET("/profiles/:id/migrate", renderMigrateProfile, basicAuth)
+	e.POST("/profiles/:id/migrate", migrateProfile, basicAuth, rejectWhileDraining)
+	e.GET("/migrations", getMigrations, basicAuth)
+	e.GET("/migrations/:id", renderMigration, basicAuth)
+	e.GET("/queue", renderQueue, basicAuth)
+	e.GET("/queue/jobs", getQueue, basicAuth)
+	e.GET("/queue/:id/up", appResolver(moveQueuedJobUp), basicAuth)
+	e.GET("/queue/:id/down", appResolver(moveQueuedJobDown), basicAuth)
+	e.POST("/queue/:id/move", appResolver(moveQueuedJob), basicAuth)
+	e.POST("/queue/:id/priority", appResolver(setQueuedJobPriority), basicAuth)
+	e.GET("/queue/:id/remove", appResolver(removeQueuedJob), basicAuth)
+	getAndHead(e, "/jobs", getNextJob, getEmpty200, workflowKeyAuth)
 	e.GET("/jobs/:id/2fa", jobResolver(get2FA), workflowKeyAuth)
 	e.POST("/jobs/:id/signed", jobResolver(uploadSignedApp), workflowKeyAuth)
 	getAndHead(e, "/jobs/:id/unsigned", jobResolver(getUnsignedAppJob), jobResolver(getUnsignedAppJob), workflowKeyAuth)
 	e.GET("/jobs/:id/fail", jobResolver(failJob), workflowKeyAuth)
 
-	if err := addTusHandlers(e, map[string]echo.MiddlewareFunc{
-		"/tus/":          basicAuth,
-		"/jobs/:id/tus/": workflowKeyAuth,
-	}); err != nil {
+	// The sign script keeps uploading signed apps while running jobs drain
+	if err := addTusHandlers(e, map[string][]echo.MiddlewareFunc{
+		"/tus/":          {basicAuth, rejectWhileDraining},
+		"/jobs/:id/tus/": {workflowKeyAuth},
+	}, stopTasks); err != nil {
 		log.Fatal().Err(err).Send()
 	}
 
@@ -311,7 +386,157 @@ func serve(host string, port uint64) {
 	}
 
 	log.Info().Str("address", fmt.Sprintf("%s:%d", host, actualPort)).Msg("starting server")
-	log.Fatal().Err(e.Server.Serve(listener)).Send()
+	signalCtx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- e.Server.Serve(listener)
+	}()
+	select {
+	case err := <-serverErr:
+		log.Fatal().Err(err).Send()
+	case <-signalCtx.Done():
+	}
+	stopSignals()
+	shutdown(e)
+	close(stopTasks)
+	log.Info().Msg("server stopped")
+}
+
+func cleanup() {
+	timeout := time.Duration(config.Current.File().SignTimeoutMins) * time.Minute
+	storage.Jobs.Cleanup(timeout)
+	storage.Uploads.Cleanup(timeout)
+}
+
+// Only one reload runs at a time, whether it comes from a signal or the admin endpoint.
+var reloadMu sync.Mutex
+
+// reload re-reads the config file and the profiles, returning warnings about the settings that need a restart.
+func reload() ([]string, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	log.Info().Msg("reloading config")
+	warnings, err := config.Reload()
+	if err != nil {
+		return nil, errors.WithMessage(err, "reload config")
+	}
+	for _, warning := range warnings {
+		log.Warn().Msg(warning)
+	}
+	for _, builder := range config.Current.Builder {
+		if err := config.SetBuilderSecrets(builder); err != nil {
+			return warnings, err
+		}
+	}
+	if err := storage.ReloadProfiles(); err != nil {
+		return warnings, errors.WithMessage(err, "reload profiles")
+	}
+	for _, invalid := range storage.Profiles.GetInvalid() {
+		warnings = append(warnings, fmt.Sprintf("profile %s is invalid: %s", invalid.Name, invalid.Err))
+	}
+	log.Info().Msg("config reloaded")
+	return warnings, nil
+}
+
+// reloadOnSignal reloads the config on SIGHUP, until stop is closed.
+func reloadOnSignal(stop <-chan bool) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+	for {
+		select {
+		case <-hangup:
+			if _, err := reload(); err != nil {
+				log.Err(err).Msg("reload")
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func reloadConfig(c echo.Context) error {
+	warnings, err := reload()
+	if warnings == nil {
+		warnings = []string{}
+	}
+	if err != nil {
+		return c.JSON(500, map[string]any{"status": "error", "error": err.Error(), "warnings": warnings})
+	}
+	return c.JSON(200, map[string]any{"status": "ok", "warnings": warnings})
+}
+
+// Set once the server is shutting down, to refuse new work while running jobs finish.
+var draining atomic.Bool
+
+// Cancelled on shutdown to end long-lived responses, which would otherwise keep the HTTP server from stopping.
+var streamsCtx, closeStreams = context.WithCancel(context.Background())
+
+// How long the HTTP server waits for requests in progress once all jobs have stopped.
+const httpShutdownTimeout = 10 * time.Second
+
+// shutdown stops taking new uploads and jobs, waits up to the grace period for running jobs,
+// and queues the jobs that are still running again before the HTTP server is stopped.
+// The HTTP server stays up until then, as the sign script uses it for 2FA codes and uploads.
+func shutdown(e *echo.Echo) {
+	draining.Store(true)
+	grace := time.Duration(config.Current.File().ShutdownGraceSecs) * time.Second
+	log.Info().Dur("grace_period", grace).Msg("shutting down, waiting for running jobs to finish. Press Ctrl+C again to stop them now")
+	graceCtx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	// a second signal ends the grace period early
+	graceCtx, stopSignals := signal.NotifyContext(graceCtx, os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	var wg sync.WaitGroup
+	for builderId, builder := range config.Current.Builder {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			builder.Shutdown(graceCtx)
+			log.Info().Str("builder", builderId).Msg("builder stopped")
+		}()
+	}
+	wg.Wait()
+
+	closeStreams()
+	httpCtx, cancelHttp := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	defer cancelHttp()
+	if err := e.Shutdown(httpCtx); err != nil {
+		log.Warn().Err(err).Msg("shut down http server")
+	}
+}
+
+// rejectWhileDraining refuses requests that would start new work once the server is shutting down.
+func rejectWhileDraining(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if draining.Load() {
+			return c.String(503, "The server is shutting down, please try again later")
+		}
+		return next(c)
+	}
+}
+
+// resumePendingJobs triggers the builders of all sign jobs that were restored from disk.
+// Retries that are still backing off are triggered once they may be taken.
+func resumePendingJobs() {
+	for _, job := range storage.Jobs.GetQueue() {
+		app, ok := storage.Apps.Get(job.AppId)
+		if !ok {
+			continue
+		}
+		builder, ok := getAppBuilder(app)
+		if !ok {
+			log.Warn().Str("app_id", job.AppId).Msg("no builder for restored job")
+			continue
+		}
+		time.AfterFunc(time.Until(job.NotBefore), func() {
+			if err := builder.Trigger(); err != nil {
+				logErrApp(err, app).Msg("trigger builder for restored job")
+			}
+		})
+	}
 }
 
 // getAndHead registers both GET and HEAD handlers for a path
@@ -331,6 +556,13 @@ func renderInstall(c echo.Context, app storage.App) error {
 		if err != nil {
 			return errors.WithMessage(err, "build manifest url")
 		}
+		// iOS fails silently on a broken manifest, so check it here where the error can be shown
+		var manifestErr manifestError
+		if _, err := makeManifest(baseUrl, app); errors.As(err, &manifestErr) {
+			return c.String(400, "Unable to install: "+err.Error())
+		} else if err != nil {
+			return errors.WithMessage(err, "make manifest")
+		}
 	} else {
 		usingManifestProxy = true
 		downloadFullUrl, err := util.JoinUrls(baseUrl, "/apps", app.GetId(), "signed")
@@ -366,6 +598,7 @@ func renderInstall(c echo.Context, app storage.App) error {
 	data := assets.InstallData{
 		ManifestUrl: manifestUrl,
 		AppName:     appName,
+		IconUrl:     getAppIconUrl(app),
 	}
 	t, err := htmlTemplate.New("").Parse(assets.InstallHtml)
 	if err != nil {
@@ -379,7 +612,7 @@ func renderInstall(c echo.Context, app storage.App) error {
 }
 
 // https://tus.github.io/tusd/advanced-topics/usage-package/
-func addTusHandlers(e *echo.Echo, uploadEndpoints map[string]echo.MiddlewareFunc) error {
+func addTusHandlers(e *echo.Echo, uploadEndpoints map[string][]echo.MiddlewareFunc, stop <-chan bool) error {
 	uploadsPath := storage.GetUploadsPath()
 	store := filestore.New(uploadsPath)
 	locker := filelocker.New(uploadsPath)
@@ -393,11 +626,31 @@ func addTusHandlers(e *echo.Echo, uploadEndpoints map[string]echo.MiddlewareFunc
 		NotifyCompleteUploads: true,
 		UseRelativeUrls:       true,
 		Logger:                log3.New(logger),
+		// reject broken ipas as soon as they are uploaded, with the reason shown by the uploader
+		PreFinishResponseCallback: func(hook tusd.HookEvent) (tusd.HTTPResponse, error) {
+			if !isIpaUpload(hook.Upload) {
+				return tusd.HTTPResponse{}, nil
+			}
+			var invalidErr storage.InvalidAppError
+			if err := storage.ValidateIpaUpload(hook.Upload.ID); errors.As(err, &invalidErr) {
+				return tusd.HTTPResponse{}, tusd.NewError("ERR_INVALID_IPA", err.Error(), 400)
+			} else if err != nil {
+				// it is validated again when the app is created
+				log.Err(err).Str("upload_id", hook.Upload.ID).Msg("validate upload")
+			}
+			return tusd.HTTPResponse{}, nil
+		},
 	})
 	go func() {
 		for {
-			event := <-handler.CompleteUploads
-			storage.Uploads.Add(event.Upload.ID)
+			select {
+			case event := <-handler.CompleteUploads:
+				storage.Uploads.Add(event.Upload.ID)
+				metrics.Uploads.Inc()
+				metrics.UploadBytes.Add(float64(event.Upload.Size))
+			case <-stop:
+				return
+			}
 		}
 	}()
 	if err != nil {
@@ -407,12 +660,12 @@ func addTusHandlers(e *echo.Echo, uploadEndpoints map[string]echo.MiddlewareFunc
 	stripMiddleware := echo.WrapMiddleware(func(h http.Handler) http.Handler {
 		return http.StripPrefix("/files/", h)
 	})
-	for prefix, authMiddleware := range uploadEndpoints {
+	for prefix, middlewares := range uploadEndpoints {
 		// middlewares run in order, make sure auth goes first!
 		e.POST(prefix, func(c echo.Context) error {
 			handler.PostFile(c.Response().Writer, c.Request())
 			return nil
-		}, authMiddleware, tusMiddleware)
+		}, append(middlewares, tusMiddleware)...)
 	}
 	e.HEAD("/files/:file_id", func(c echo.Context) error {
 		handler.HeadFile(c.Response().Writer, c.Request())
@@ -425,56 +678,994 @@ func addTusHandlers(e *echo.Echo, uploadEndpoints map[string]echo.MiddlewareFunc
 	return nil
 }
 
+// Tweaks are uploaded the same way as ipas, the web interface tells them apart with the uploadType metadata.
+func isIpaUpload(info tusd.FileInfo) bool {
+	if uploadType, ok := info.MetaData["uploadType"]; ok {
+		return uploadType == "ipa"
+	}
+	return strings.EqualFold(filepath.Ext(info.MetaData["filename"]), ".ipa")
+}
+
 func getTweaks(c echo.Context, app storage.App) error {
 	tweaks, err := app.ReadDir(storage.TweaksDir)
 	if os.IsNotExist(err) {
 		return c.NoContent(404)
-	} else if err != nil {
+	} else if err != nil {
+		return err
+	}
+	if len(tweaks) < 1 {
+		return c.NoContent(404)
+	}
+	appName, err := app.GetString(storage.AppName)
+	if err != nil {
+		return err
+	}
+	c.Response().Header().Set("Content-Type", mime.TypeByExtension(".tar"))
+	c.Response().Header().Add("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-tweaks.tar"`, appName))
+	writer := tar.NewWriter(c.Response().Writer)
+	defer writer.Close()
+	for _, tweak := range tweaks {
+		tweakPath := storage.FSName(filepath.Join(string(storage.TweaksDir), tweak.Name()))
+		file, err := app.GetFile(tweakPath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		stat, err := app.Stat(tweakPath)
+		if err != nil {
+			return err
+		}
+		if err := writer.WriteHeader(&tar.Header{
+			Name: tweak.Name(),
+			Mode: 0600,
+			Size: stat.Size(),
+		}); err != nil {
+			return err
+		}
+		if _, err := io.Copy(writer, file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func renderRenameApp(c echo.Context, app storage.App) error {
+	appName, err := app.GetString(storage.AppName)
+	if err != nil {
+		return err
+	}
+	data := assets.RenameData{AppName: appName}
+	t, err := htmlTemplate.New("").Parse(assets.RenameHtml)
+	if err != nil {
+		return err
+	}
+	var result bytes.Buffer
+	if err := t.Execute(&result, data); err != nil {
+		return err
+	}
+	return c.HTMLBlob(200, result.Bytes())
+}
+
+func renameApp(c echo.Context, app storage.App) error {
+	if err := app.SetString(storage.AppName, c.FormValue("name")); err != nil {
+		return err
+	}
+	return c.Redirect(302, "/")
+}
+
+// The number of recent automatic re-signs shown with the re-sign settings.
+const resignHistoryRows = 10
+
+func renderAppResign(c echo.Context, app storage.App) error {
+	plan, err := resign.GetPlan(app, time.Now())
+	if err != nil {
+		return err
+	}
+	if strings.Contains(c.Request().Header.Get("Accept"), "application/json") {
+		return c.JSON(200, plan)
+	}
+	data := assets.ResignData{
+		IsApp:            true,
+		FormUrl:          path.Join("/apps", app.GetId(), "auto-resign"),
+		BackUrl:          "/",
+		Inherit:          plan.FromProfile,
+		BeforeExpiry:     plan.Settings.BeforeExpiry,
+		Schedule:         plan.Settings.Schedule,
+		DaysBeforeExpiry: config.Current.File().Resign.DaysBeforeExpiry,
+	}
+	data.Name, _ = app.GetString(storage.AppName)
+	profileId, _ := app.GetString(storage.AppProfileId)
+	if profile, ok := storage.Profiles.GetById(profileId); ok {
+		data.ProfileName, _ = profile.GetString(storage.ProfileName)
+	}
+	if !plan.ExpiresAt.IsZero() {
+		data.ExpiresAt = plan.ExpiresAt.Format(time.RFC822)
+	}
+	if !plan.NextResign.IsZero() {
+		data.NextResign = fmt.Sprintf("%s, %s", plan.NextResign.Format(time.RFC822), getResignReasonText(plan.NextReason))
+	}
+	if data.History, err = getResignRecords(func(record *resign.Record) bool {
+		return record.AppId == app.GetId()
+	}); err != nil {
+		return err
+	}
+	return renderResign(c, &data)
+}
+
+func setAppResign(c echo.Context, app storage.App) error {
+	var settings *storage.ResignSettings
+	if c.FormValue("inherit") != "true" {
+		var err error
+		if settings, err = getResignInput(c); err != nil {
+			return c.String(400, err.Error())
+		}
+	}
+	if err := storage.SetResignSettings(app, settings); err != nil {
+		return err
+	}
+	if strings.Contains(c.Request().Header.Get("Accept"), "application/json") {
+		return renderAppResign(c, app)
+	}
+	return c.Redirect(302, "/")
+}
+
+func renderProfileResign(c echo.Context) error {
+	profile, ok := storage.Profiles.GetById(c.Param("id"))
+	if !ok {
+		return c.NoContent(404)
+	}
+	if storage.IsProfileReadOnly(profile) {
+		return profileActionResult(c, nil, storage.ErrProfileReadOnly)
+	}
+	settings, err := storage.GetResignSettings(profile)
+	if err != nil {
+		return err
+	} else if settings == nil {
+		settings = &storage.ResignSettings{}
+	}
+	if strings.Contains(c.Request().Header.Get("Accept"), "application/json") {
+		return c.JSON(200, settings)
+	}
+	data := assets.ResignData{
+		FormUrl:          path.Join("/profiles", profile.GetId(), "auto-resign"),
+		BackUrl:          "/profiles",
+		BeforeExpiry:     settings.BeforeExpiry,
+		Schedule:         settings.Schedule,
+		DaysBeforeExpiry: config.Current.File().Resign.DaysBeforeExpiry,
+	}
+	data.Name, _ = profile.GetString(storage.ProfileName)
+	if data.History, err = getResignRecords(func(record *resign.Record) bool {
+		return record.ProfileId == profile.GetId()
+	}); err != nil {
+		return err
+	}
+	return renderResign(c, &data)
+}
+
+func setProfileResign(c echo.Context) error {
+	profile, ok := storage.Profiles.GetById(c.Param("id"))
+	if !ok {
+		return c.NoContent(404)
+	}
+	if storage.IsProfileReadOnly(profile) {
+		return profileActionResult(c, nil, storage.ErrProfileReadOnly)
+	}
+	settings, err := getResignInput(c)
+	if err != nil {
+		return c.String(400, err.Error())
+	}
+	if !settings.IsEnabled() {
+		settings = nil
+	}
+	return profileActionResult(c, profile, storage.SetResignSettings(profile, settings))
+}
+
+// Reads the re-sign settings form, checking that the schedule is valid.
+func getResignInput(c echo.Context) (*storage.ResignSettings, error) {
+	settings := &storage.ResignSettings{
+		BeforeExpiry: c.FormValue("before_expiry") == "true",
+		Schedule:     strings.TrimSpace(c.FormValue("schedule")),
+	}
+	if settings.Schedule != "" {
+		if _, err := resign.ParseSchedule(settings.Schedule); err != nil {
+			return nil, errors.WithMessage(err, "invalid schedule")
+		}
+	}
+	return settings, nil
+}
+
+func renderResign(c echo.Context, data *assets.ResignData) error {
+	t, err := htmlTemplate.New("").Parse(assets.ResignHtml)
+	if err != nil {
+		return err
+	}
+	var result bytes.Buffer
+	if err := t.Execute(&result, data); err != nil {
+		return err
+	}
+	return c.HTMLBlob(200, result.Bytes())
+}
+
+func getResignReasonText(reason resign.Reason) string {
+	if reason == resign.ReasonSchedule {
+		return "scheduled"
+	}
+	return "before the signature expires"
+}
+
+// Returns the most recent automatic re-signs that match, newest first.
+func getResignRecords(match func(record *resign.Record) bool) ([]assets.ResignRecord, error) {
+	history, err := resign.GetHistory()
+	if err != nil {
+		return nil, err
+	}
+	var rows []assets.ResignRecord
+	for i := len(history) - 1; i >= 0 && len(rows) < resignHistoryRows; i-- {
+		record := &history[i]
+		if !match(record) {
+			continue
+		}
+		reason := getResignReasonText(record.Reason)
+		if record.Reason == resign.ReasonSchedule {
+			reason += " (" + record.Schedule + ")"
+		} else {
+			reason += " (" + record.ExpiresAt.Format(time.RFC822) + ")"
+		}
+		rows = append(rows, assets.ResignRecord{
+			Time:    record.Time.Format(time.RFC822),
+			AppName: record.AppName,
+			Reason:  reason,
+			Error:   record.Error,
+		})
+	}
+	return rows, nil
+}
+
+func getResignHistory(c echo.Context) error {
+	history, err := resign.GetHistory()
+	if err != nil {
+		return err
+	}
+	results := []resign.Record{}
+	for _, record := range history {
+		if appId := c.QueryParam("app_id"); appId == "" || record.AppId == appId {
+			results = append(results, record)
+		}
+	}
+	return c.JSON(200, results)
+}
+
+func failJob(c echo.Context, job *storage.ReturnJob) error {
+	if !storage.Jobs.FailById(job.Id, -1, "reported as failed by the builder") {
+		return errors.Errorf("unable to delete return job %s", job.Id)
+	}
+	// stop the sign script in case it's still running
+	if app, ok := storage.Apps.Get(job.AppId); ok {
+		if builder, ok := getAppBuilder(app); ok {
+			builder.Cancel(job.Id)
+		}
+	}
+	return c.NoContent(200)
+}
+
+func cancelApp(c echo.Context, app storage.App) error {
+	if err := cancelJob(app); errors.Is(err, storage.ErrNotFound) {
+		return c.NoContent(404)
+	} else if err != nil {
+		return err
+	}
+	return c.Redirect(302, "/")
+}
+
+// cancelJob removes the queued sign job of an app, or stops its running one.
+func cancelJob(app storage.App) error {
+	if err := storage.Jobs.RemoveJob(app.GetId(), "cancelled"); !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+	job, ok := storage.Jobs.GetByAppId(app.GetId())
+	if !ok {
+		return errors.WithMessage(storage.ErrNotFound, "job")
+	}
+	if builder, ok := getAppBuilder(app); ok && builder.Cancel(job.Id) {
+		// the builder records the job as cancelled once the sign script has exited
+		return nil
+	}
+	storage.Jobs.CancelById(job.Id)
+	return nil
+}
+
+func getAppBuilder(app storage.App) (builders.Builder, bool) {
+	builderId, err := app.GetString(storage.AppBuilderId)
+	if err != nil {
+		logErrApp(err, app).Msg("get builder id")
+		return nil, false
+	}
+	builder, ok := config.Current.Builder[builderId]
+	return builder, ok
+}
+
+
+func getFavIcon(c echo.Context) error {
+	http.ServeContent(c.Response(), c.Request(), assets.FavIconStat.Name(), assets.FavIconStat.ModTime(), bytes.NewReader(assets.FavIconBytes))
+	return nil
+}
+
+func uploadSignedApp(c echo.Context, job *storage.ReturnJob) error {
+	app, ok := storage.Apps.Get(job.AppId)
+	if !ok {
+		return errors.Errorf("return job %s appid %s not resolved", job.Id, job.AppId)
+	}
+	fileId := c.FormValue(formNames.FormFileId)
+	upload, ok := storage.Uploads.Get(fileId)
+	if !ok {
+		return errors.Errorf("no app upload file with id %s", fileId)
+	}
+	defer storage.Uploads.Delete(fileId)
+	file, err := upload.GetData()
+	if err != nil {
+		return errors.WithMessage(err, "get upload data")
+	}
+	defer file.Close()
+	if err := app.SetFile(storage.AppSignedFile, file); err != nil {
+		return errors.WithMessage(err, "set signed file")
+	}
+	if err := app.SetString(storage.AppBundleId, c.FormValue("bundle_id")); err != nil {
+		return errors.WithMessage(err, "set bundle id")
+	}
+	if !storage.Jobs.CompleteById(job.Id) {
+		return errors.Errorf("unable to delete return job %s", job.Id)
+	}
+	return c.NoContent(200)
+}
+
+func get2FA(c echo.Context, job *storage.ReturnJob) error {
+	code := job.TwoFactorCode.Load()
+	if code == "" {
+		job.RequestTwoFactorCode()
+		return c.NoContent(404)
+	} else {
+		return c.String(200, code)
+	}
+}
+
+func appResolver(handler func(echo.Context, storage.App) error) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		id := c.Param("id")
+		app, ok := storage.Apps.Get(id)
+		if !ok {
+			return c.NoContent(404)
+		}
+		return handler(c, app)
+	}
+}
+
+func jobResolver(handler func(echo.Context, *storage.ReturnJob) error) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		id := c.Param("id")
+		job, ok := storage.Jobs.GetById(id)
+		if !ok {
+			return c.NoContent(404)
+		}
+		return handler(c, job)
+	}
+}
+
+func getNextJob(c echo.Context) error {
+	if err := storage.Jobs.TakeNextJob(c.Response()); errors.Is(err, storage.ErrNotFound) {
+		return c.NoContent(404)
+	} else if err != nil {
+		return err
+	}
+	return c.NoContent(200)
+}
+
+func renderQueue(c echo.Context) error {
+	data := assets.QueueData{}
+	for _, job := range storage.Jobs.GetRunningJobs() {
+		appName, profileName := getJobNames(job.AppId, job.ProfileId)
+		data.Running = append(data.Running, assets.QueueJob{
+			AppName:     appName,
+			ProfileName: profileName,
+			Time:        job.StartedAt.Format(time.RFC822),
+		})
+	}
+	for _, job := range storage.Jobs.GetQueue() {
+		appName, profileName := getJobNames(job.AppId, job.ProfileId)
+		data.Queued = append(data.Queued, assets.QueueJob{
+			Position:    job.Position + 1,
+			AppName:     appName,
+			ProfileName: profileName,
+			Priority:    job.Priority.String(),
+			Time:        job.QueuedAt.Format(time.RFC822),
+			PriorityUrl: path.Join("/queue", job.AppId, "priority"),
+			UpUrl:       path.Join("/queue", job.AppId, "up"),
+			DownUrl:     path.Join("/queue", job.AppId, "down"),
+			RemoveUrl:   path.Join("/queue", job.AppId, "remove"),
+		})
+	}
+	for _, priority := range storage.JobPriorities() {
+		data.Priorities = append(data.Priorities, priority.String())
+	}
+	t, err := htmlTemplate.New("").Parse(assets.QueueHtml)
+	if err != nil {
+		return err
+	}
+	var result bytes.Buffer
+	if err := t.Execute(&result, data); err != nil {
+		return err
+	}
+	return c.HTMLBlob(200, result.Bytes())
+}
+
+// Returns the names of a job's app and profile, for display.
+func getJobNames(appId string, profileId string) (string, string) {
+	appName := "unknown"
+	if app, ok := storage.Apps.Get(appId); ok {
+		if name, err := app.GetString(storage.AppName); err == nil {
+			appName = name
+		}
+	}
+	profileName := "unknown"
+	if profile, ok := storage.Profiles.GetById(profileId); ok {
+		if name, err := profile.GetString(storage.ProfileName); err == nil {
+			profileName = name
+		}
+	}
+	return appName, profileName
+}
+
+func getQueue(c echo.Context) error {
+	return c.JSON(200, map[string]interface{}{
+		"running": storage.Jobs.GetRunningJobs(),
+		"queued":  storage.Jobs.GetQueue(),
+	})
+}
+
+func moveQueuedJobUp(c echo.Context, app storage.App) error {
+	return moveQueuedJobBy(c, app, -1)
+}
+
+func moveQueuedJobDown(c echo.Context, app storage.App) error {
+	return moveQueuedJobBy(c, app, 1)
+}
+
+func moveQueuedJobBy(c echo.Context, app storage.App, offset int) error {
+	for _, job := range storage.Jobs.GetQueue() {
+		if job.AppId == app.GetId() {
+			return queueActionResult(c, storage.Jobs.MoveJob(app.GetId(), job.Position+offset))
+		}
+	}
+	return c.NoContent(404)
+}
+
+func moveQueuedJob(c echo.Context, app storage.App) error {
+	position, err := strconv.Atoi(c.FormValue("position"))
+	if err != nil {
+		return c.String(400, "invalid position")
+	}
+	return queueActionResult(c, storage.Jobs.MoveJob(app.GetId(), position))
+}
+
+func setQueuedJobPriority(c echo.Context, app storage.App) error {
+	priority, err := storage.ParseJobPriority(c.FormValue("priority"))
+	if err != nil {
+		return c.String(400, err.Error())
+	}
+	return queueActionResult(c, storage.Jobs.SetJobPriority(app.GetId(), priority))
+}
+
+func removeQueuedJob(c echo.Context, app storage.App) error {
+	return queueActionResult(c, storage.Jobs.RemoveJob(app.GetId(), "removed from the queue"))
+}
+
+func queueActionResult(c echo.Context, err error) error {
+	if errors.Is(err, storage.ErrNotFound) {
+		return c.NoContent(404)
+	} else if err != nil {
+		return err
+	}
+	return c.Redirect(302, "/queue")
+}
+
+type jobHistoryItem struct {
+	storage.JobHistoryEntry
+	LogUrl string `json:"log_url,omitempty"`
+}
+
+func getJobHistory(c echo.Context, app storage.App) error {
+	history, err := storage.GetJobHistory(app)
+	if err != nil {
+		return err
+	}
+	items := []jobHistoryItem{}
+	for _, entry := range history {
+		item := jobHistoryItem{JobHistoryEntry: entry}
+		if storage.HasJobLog(app, entry.Id) {
+			item.LogUrl = path.Join("/apps", app.GetId(), "logs", entry.Id)
+		}
+		items = append(items, item)
+	}
+	return c.JSON(200, items)
+}
+
+func getAppInfo(c echo.Context, app storage.App) error {
+	info, err := storage.GetAppInfo(app)
+	if err != nil {
+		return err
+	}
+	return c.JSON(200, info)
+}
+
+func getJobLog(c echo.Context, app storage.App) error {
+	jobId := c.Param("jobId")
+	file, err := storage.GetJobLog(app, jobId)
+	if os.IsNotExist(err) {
+		return c.NoContent(404)
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	appName, err := app.GetString(storage.AppName)
+	if err != nil {
+		return err
+	}
+	fileName := fmt.Sprintf("%s-%s.log", strings.TrimSuffix(appName, filepath.Ext(appName)), jobId)
+	c.Response().Header().Set("Content-Type", "text/plain; charset=utf-8")
+	c.Response().Header().Add("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, fileName))
+	http.ServeContent(c.Response(), c.Request(), fileName, stat.ModTime(), file)
+	return nil
+}
+
+// A signing profile, as shown to administrators.
+type profileInfo struct {
+	Id        string `json:"id"`
+	Name      string `json:"name"`
+	IsAccount bool   `json:"is_account"`
+	TeamId    string `json:"team_id"`
+	AppCount  int    `json:"app_count"`
+	// Profiles imported from environment variables can't be changed.
+	ReadOnly bool `json:"read_only"`
+	// Whether new apps can be signed with the profile. Invalid profiles can't.
+	Usable bool `json:"usable"`
+	// Whether a version of the profile is loaded, which is the case for invalid profiles that keep their previous
+	// version to re-sign the apps already signed with it.
+	Loaded bool `json:"loaded"`
+	// Why the profile failed to load.
+	Error string `json:"error,omitempty"`
+	// When the certificate or provisioning profile expires, whichever is first.
+	Expiry *expiry.Status `json:"expiry,omitempty"`
+}
+
+func getProfileInfos() ([]profileInfo, error) {
+	profiles, err := storage.Profiles.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	apps, err := storage.Apps.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	appCounts := map[string]int{}
+	for _, app := range apps {
+		if profileId, err := app.GetString(storage.AppProfileId); err == nil {
+			appCounts[profileId]++
+		}
+	}
+	var infos []profileInfo
+	for _, profile := range profiles {
+		info := profileInfo{Id: profile.GetId(), TeamId: profile.GetTeamId(), AppCount: appCounts[profile.GetId()]}
+		info.Name, _ = profile.GetString(storage.ProfileName)
+		info.IsAccount, _ = profile.IsAccount()
+		info.ReadOnly = storage.IsProfileReadOnly(profile)
+		info.Usable = true
+		info.Loaded = true
+		if status, ok := expiry.Get(profile, time.Now()); ok {
+			info.Expiry = &status
+		}
+		infos = append(infos, info)
+	}
+	for _, invalid := range storage.Profiles.GetInvalid() {
+		found := false
+		for i := range infos {
+			if infos[i].Id == invalid.Id {
+				infos[i].Error = invalid.Err.Error()
+				infos[i].Usable = false
+				found = true
+			}
+		}
+		if !found {
+			infos = append(infos, profileInfo{
+				Id:        invalid.Id,
+				Name:      invalid.Name,
+				IsAccount: invalid.IsAccount,
+				AppCount:  appCounts[invalid.Id],
+				ReadOnly:  storage.IsInvalidProfileReadOnly(invalid),
+				Error:     invalid.Err.Error(),
+			})
+		}
+	}
+	return infos, nil
+}
+
+func renderProfiles(c echo.Context) error {
+	infos, err := getProfileInfos()
+	if err != nil {
+		return err
+	}
+	data := assets.ProfilesData{}
+	for _, info := range infos {
+		var expiresAt, expirySource string
+		var expiring, expired bool
+		if info.Expiry != nil {
+			expiresAt = info.Expiry.ExpiresAt.Format(time.RFC822)
+			expirySource = "Certificate"
+			if info.Expiry.Source == expiry.SourceProvisioning {
+				expirySource = "Provisioning profile"
+			}
+			expiring = info.Expiry.IsSoon()
+			expired = info.Expiry.Expired
+		}
+		var autoResign bool
+		if profile, ok := storage.Profiles.GetById(info.Id); ok && !info.ReadOnly {
+			if settings, err := storage.GetResignSettings(profile); err != nil {
+				log.Err(err).Str("profile_id", info.Id).Msg("get resign settings")
+			} else {
+				autoResign = settings != nil && settings.IsEnabled()
+			}
+		}
+		data.Profiles = append(data.Profiles, assets.ProfileRow{
+			Id:            info.Id,
+			Name:          info.Name,
+			IsAccount:     info.IsAccount,
+			TeamId:        info.TeamId,
+			ExpiresAt:     expiresAt,
+			ExpirySource:  expirySource,
+			Expiring:      expiring,
+			Expired:       expired,
+			AppCount:      info.AppCount,
+			ReadOnly:      info.ReadOnly,
+			Usable:        info.Usable,
+			Loaded:        info.Loaded,
+			Error:         info.Error,
+			DetailsUrl:    path.Join("/profiles", info.Id),
+			EditUrl:       path.Join("/profiles", info.Id, "edit"),
+			DeleteUrl:     path.Join("/profiles", info.Id, "delete"),
+			AutoResign:    autoResign,
+			AutoResignUrl: path.Join("/profiles", info.Id, "auto-resign"),
+			MigrateUrl:    path.Join("/profiles", info.Id, "migrate"),
+		})
+	}
+	t, err := htmlTemplate.New("").Parse(assets.ProfilesHtml)
+	if err != nil {
+		return err
+	}
+	var result bytes.Buffer
+	if err := t.Execute(&result, data); err != nil {
+		return err
+	}
+	return c.HTMLBlob(200, result.Bytes())
+}
+
+// A profile that has expired or expires soon, with the apps signed with it.
+type expiringProfile struct {
+	expiry.Status
+	DaysLeft int      `json:"days_left"`
+	Message  string   `json:"message"`
+	AppIds   []string `json:"app_ids"`
+}
+
+func getExpiringProfileList() ([]expiringProfile, error) {
+	now := time.Now()
+	statuses, err := expiry.GetSoon(now)
+	if err != nil {
+		return nil, err
+	}
+	apps, err := storage.Apps.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	results := []expiringProfile{}
+	for _, status := range statuses {
+		result := expiringProfile{Status: status, DaysLeft: status.DaysLeft(now), Message: status.Message(now), AppIds: []string{}}
+		for _, app := range apps {
+			if profileId, err := app.GetString(storage.AppProfileId); err == nil && profileId == status.ProfileId {
+				result.AppIds = append(result.AppIds, app.GetId())
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func getExpiringProfiles(c echo.Context) error {
+	results, err := getExpiringProfileList()
+	if err != nil {
+		return err
+	}
+	return c.JSON(200, results)
+}
+
+func getProfiles(c echo.Context) error {
+	infos, err := getProfileInfos()
+	if err != nil {
+		return err
+	}
+	if infos == nil {
+		infos = []profileInfo{}
+	}
+	return c.JSON(200, infos)
+}
+
+// A certificate, as shown to administrators.
+type certificateInfo struct {
+	Subject      string    `json:"subject"`
+	SerialNumber string    `json:"serial_number"`
+	NotBefore    time.Time `json:"not_before"`
+	NotAfter     time.Time `json:"not_after"`
+	Sha1         string    `json:"sha1"`
+}
+
+func getCertificateInfos(certificates []*x509.Certificate) []certificateInfo {
+	infos := []certificateInfo{}
+	for _, cert := range certificates {
+		sum := sha1.Sum(cert.Raw)
+		infos = append(infos, certificateInfo{
+			Subject:      cert.Subject.String(),
+			SerialNumber: cert.SerialNumber.Text(16),
+			NotBefore:    cert.NotBefore,
+			NotAfter:     cert.NotAfter,
+			Sha1:         strings.ToUpper(hex.EncodeToString(sum[:])),
+		})
+	}
+	return infos
+}
+
+type provisioningInfo struct {
+	*provisioning.Profile
+	DeveloperCertificates []certificateInfo `json:"developer_certificates"`
+}
+
+type profileDetails struct {
+	profileInfo
+	Certificates []certificateInfo `json:"certificates"`
+	// Not set for account profiles, which get a provisioning profile when signing.
+	Provisioning *provisioningInfo `json:"provisioning,omitempty"`
+}
+
+func getProfileDetailsById(id string) (*profileDetails, bool, error) {
+	profile, ok := storage.Profiles.GetById(id)
+	if !ok {
+		return nil, false, nil
+	}
+	infos, err := getProfileInfos()
+	if err != nil {
+		return nil, false, err
+	}
+	details := &profileDetails{Certificates: getCertificateInfos(profile.GetCertificates())}
+	for _, info := range infos {
+		if info.Id == id {
+			details.profileInfo = info
+		}
+	}
+	if prov := profile.GetProvisioningProfile(); prov != nil {
+		details.Provisioning = &provisioningInfo{Profile: prov, DeveloperCertificates: getCertificateInfos(prov.DeveloperCertificates)}
+	}
+	return details, true, nil
+}
+
+func getProfileDetails(c echo.Context) error {
+	details, ok, err := getProfileDetailsById(c.Param("id"))
+	if err != nil {
+		return err
+	} else if !ok {
+		return c.NoContent(404)
+	}
+	return c.JSON(200, details)
+}
+
+func renderProfileDetails(c echo.Context) error {
+	details, ok, err := getProfileDetailsById(c.Param("id"))
+	if err != nil {
+		return err
+	} else if !ok {
+		return c.NoContent(404)
+	}
+	toCertificates := func(infos []certificateInfo) []assets.Certificate {
+		var results []assets.Certificate
+		for _, info := range infos {
+			results = append(results, assets.Certificate{
+				Subject:      info.Subject,
+				SerialNumber: info.SerialNumber,
+				Expiry:       info.NotAfter.Format(time.RFC822),
+				Sha1:         info.Sha1,
+			})
+		}
+		return results
+	}
+	data := assets.ProfileDetailsData{
+		Id:           details.Id,
+		Name:         details.Name,
+		IsAccount:    details.IsAccount,
+		TeamId:       details.TeamId,
+		Error:        details.Error,
+		ReadOnly:     details.ReadOnly,
+		EditUrl:      path.Join("/profiles", details.Id, "edit"),
+		Certificates: toCertificates(details.Certificates),
+	}
+	if prov := details.Provisioning; prov != nil {
+		entitlements, err := json.MarshalIndent(prov.Entitlements, "", "  ")
+		if err != nil {
+			return errors.WithMessage(err, "marshal entitlements")
+		}
+		data.Provisioning = &assets.ProvisioningProfile{
+			Name:                  prov.Name,
+			UUID:                  prov.UUID,
+			Type:                  string(prov.Type),
+			AppId:                 prov.AppId,
+			AppIdName:             prov.AppIdName,
+			TeamName:              prov.TeamName,
+			Platforms:             strings.Join(prov.Platforms, ", "),
+			Created:               prov.CreationDate.Format(time.RFC822),
+			Expires:               prov.ExpirationDate.Format(time.RFC822),
+			Devices:               prov.Devices,
+			Entitlements:          string(entitlements),
+			DeveloperCertificates: toCertificates(prov.DeveloperCertificates),
+		}
+	}
+	t, err := htmlTemplate.New("").Parse(assets.ProfileDetailsHtml)
+	if err != nil {
+		return err
+	}
+	var result bytes.Buffer
+	if err := t.Execute(&result, data); err != nil {
+		return err
+	}
+	return c.HTMLBlob(200, result.Bytes())
+}
+
+func renderProfileForm(c echo.Context) error {
+	data := assets.ProfileFormData{}
+	if id := c.Param("id"); id != "" {
+		profile, ok := storage.Profiles.GetById(id)
+		if !ok {
+			return c.NoContent(404)
+		}
+		data.Id = id
+		data.Name, _ = profile.GetString(storage.ProfileName)
+		data.IsAccount, _ = profile.IsAccount()
+		if data.IsAccount {
+			data.AccountName, _ = profile.GetString(storage.ProfileAccountName)
+		}
+	}
+	t, err := htmlTemplate.New("").Parse(assets.ProfileHtml)
+	if err != nil {
+		return err
+	}
+	var result bytes.Buffer
+	if err := t.Execute(&result, data); err != nil {
+		return err
+	}
+	return c.HTMLBlob(200, result.Bytes())
+}
+
+// Reads the profile form. Files that weren't uploaded are left empty.
+func getProfileInput(c echo.Context) (*storage.ProfileInput, error) {
+	input := &storage.ProfileInput{
+		Name:        c.FormValue("name"),
+		CertPass:    c.FormValue("cert_pass"),
+		AccountName: c.FormValue("account_name"),
+		AccountPass: c.FormValue("account_pass"),
+	}
+	var err error
+	if input.Cert, err = readFormFile(c, "cert"); err != nil {
+		return nil, err
+	}
+	if input.Prov, err = readFormFile(c, "prov"); err != nil {
+		return nil, err
+	}
+	return input, nil
+}
+
+func readFormFile(c echo.Context, name string) ([]byte, error) {
+	header, err := c.FormFile(name)
+	if errors.Is(err, http.ErrMissingFile) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.WithMessagef(err, "get form file %s", name)
+	}
+	file, err := header.Open()
+	if err != nil {
+		return nil, errors.WithMessagef(err, "open form file %s", name)
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+func createProfile(c echo.Context) error {
+	input, err := getProfileInput(c)
+	if err != nil {
+		return err
+	}
+	profile, err := storage.Profiles.New(input)
+	return profileActionResult(c, profile, err)
+}
+
+func updateProfile(c echo.Context) error {
+	input, err := getProfileInput(c)
+	if err != nil {
+		return err
+	}
+	profile, err := storage.Profiles.Update(c.Param("id"), input)
+	return profileActionResult(c, profile, err)
+}
+
+func deleteProfile(c echo.Context) error {
+	return profileActionResult(c, nil, storage.Profiles.Delete(c.Param("id")))
+}
+
+// Responds to API clients with the profile as JSON, and redirects browsers back to the profiles page.
+func profileActionResult(c echo.Context, profile storage.Profile, err error) error {
+	var inputErr storage.ProfileInputError
+	var inUseErr *storage.ProfileInUseError
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return c.NoContent(404)
+	case errors.Is(err, storage.ErrProfileReadOnly):
+		return c.String(403, "This profile is imported from environment variables and can't be changed")
+	case errors.As(err, &inputErr):
+		return c.String(400, "Invalid profile: "+inputErr.Error())
+	case errors.As(err, &inUseErr):
+		return c.String(409, fmt.Sprintf("The profile is still used by %d apps, delete them first", len(inUseErr.AppIds)))
+	case err != nil:
 		return err
 	}
-	if len(tweaks) < 1 {
-		return c.NoContent(404)
+	if strings.Contains(c.Request().Header.Get("Accept"), "application/json") {
+		if profile == nil {
+			return c.NoContent(204)
+		}
+		name, _ := profile.GetString(storage.ProfileName)
+		return c.JSON(200, map[string]string{"id": profile.GetId(), "name": name})
 	}
-	appName, err := app.GetString(storage.AppName)
+	return c.Redirect(302, "/profiles")
+}
+
+func renderMigrateProfile(c echo.Context) error {
+	sourceId := c.Param("id")
+	data := assets.MigrateData{FormUrl: path.Join("/profiles", sourceId, "migrate")}
+	infos, err := getProfileInfos()
 	if err != nil {
 		return err
 	}
-	c.Response().Header().Set("Content-Type", mime.TypeByExtension(".tar"))
-	c.Response().Header().Add("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-tweaks.tar"`, appName))
-	writer := tar.NewWriter(c.Response().Writer)
-	defer writer.Close()
-	for _, tweak := range tweaks {
-		tweakPath := storage.FSName(filepath.Join(string(storage.TweaksDir), tweak.Name()))
-		file, err := app.GetFile(tweakPath)
-		if err != nil {
-			return err
-		}
-		defer file.Close()
-		stat, err := app.Stat(tweakPath)
-		if err != nil {
-			return err
-		}
-		if err := writer.WriteHeader(&tar.Header{
-			Name: tweak.Name(),
-			Mode: 0600,
-			Size: stat.Size(),
-		}); err != nil {
-			return err
-		}
-		if _, err := io.Copy(writer, file); err != nil {
-			return err
+	found := false
+	for _, info := range infos {
+		if info.Id == sourceId {
+			data.SourceName = info.Name
+			found = true
+		} else if info.Usable {
+			data.Targets = append(data.Targets, assets.Profile{Id: info.Id, Name: info.Name, IsAccount: info.IsAccount})
 		}
 	}
-	return nil
-}
-
-func renderRenameApp(c echo.Context, app storage.App) error {
-	appName, err := app.GetString(storage.AppName)
+	if !found {
+		return c.NoContent(404)
+	}
+	apps, err := storage.Apps.GetAll()
 	if err != nil {
 		return err
 	}
-	data := assets.RenameData{AppName: appName}
-	t, err := htmlTemplate.New("").Parse(assets.RenameHtml)
+	for _, app := range apps {
+		if profileId, _ := app.GetString(storage.AppProfileId); profileId != sourceId {
+			continue
+		}
+		row := assets.MigrateApp{Id: app.GetId()}
+		row.Name, _ = app.GetString(storage.AppName)
+		row.BundleId, _ = app.GetString(storage.AppBundleId)
+		data.Apps = append(data.Apps, row)
+	}
+	t, err := htmlTemplate.New("").Parse(assets.MigrateHtml)
 	if err != nil {
 		return err
 	}
@@ -485,92 +1676,245 @@ func renderRenameApp(c echo.Context, app storage.App) error {
 	return c.HTMLBlob(200, result.Bytes())
 }
 
-func renameApp(c echo.Context, app storage.App) error {
-	if err := app.SetString(storage.AppName, c.FormValue("name")); err != nil {
+func migrateProfile(c echo.Context) error {
+	params, err := c.FormParams()
+	if err != nil {
+		return c.String(400, err.Error())
+	}
+	priority := storage.JobPriorityBulk
+	if value := c.FormValue("priority"); value != "" {
+		if priority, err = storage.ParseJobPriority(value); err != nil {
+			return c.String(400, err.Error())
+		}
+	}
+	// The migration outlives the request, and queues the sign jobs in the background
+	m, err := migration.Start(c.Param("id"), c.FormValue("target"), params["app_ids"], func(app storage.App) error {
+		if draining.Load() {
+			return errors.New("server is shutting down")
+		}
+		return queueReplacingResign(app, priority)
+	})
+	var inputErr migration.InputError
+	if errors.As(err, &inputErr) {
+		return c.String(400, "Unable to migrate: "+inputErr.Error())
+	} else if err != nil {
 		return err
 	}
-	return c.Redirect(302, "/")
-}
-
-func failJob(c echo.Context, job *storage.ReturnJob) error {
-	if !storage.Jobs.DeleteById(job.Id) {
-		return errors.Errorf("unable to delete return job %s", job.Id)
+	if strings.Contains(c.Request().Header.Get("Accept"), "application/json") {
+		return c.JSON(202, m)
 	}
-	return c.NoContent(200)
+	return c.Redirect(302, path.Join("/migrations", m.Id))
 }
 
-
-func getFavIcon(c echo.Context) error {
-	http.ServeContent(c.Response(), c.Request(), assets.FavIconStat.Name(), assets.FavIconStat.ModTime(), bytes.NewReader(assets.FavIconBytes))
-	return nil
+func getMigrations(c echo.Context) error {
+	results := migration.GetAll()
+	if results == nil {
+		results = []migration.Migration{}
+	}
+	return c.JSON(200, results)
 }
 
-func uploadSignedApp(c echo.Context, job *storage.ReturnJob) error {
-	app, ok := storage.Apps.Get(job.AppId)
+func renderMigration(c echo.Context) error {
+	m, ok := migration.Get(c.Param("id"))
 	if !ok {
-		return errors.Errorf("return job %s appid %s not resolved", job.Id, job.AppId)
+		return c.NoContent(404)
 	}
-	fileId := c.FormValue(formNames.FormFileId)
-	upload, ok := storage.Uploads.Get(fileId)
-	if !ok {
-		return errors.Errorf("no app upload file with id %s", fileId)
+	if strings.Contains(c.Request().Header.Get("Accept"), "application/json") {
+		return c.JSON(200, m)
 	}
-	defer storage.Uploads.Delete(fileId)
-	file, err := upload.GetData()
+	data := assets.MigrationData{
+		SourceName: m.SourceName,
+		TargetName: m.TargetName,
+		StartedAt:  m.StartedAt.Format(time.RFC822),
+		Finished:   m.IsFinished(),
+		Done:       m.Done,
+		Total:      len(m.Apps),
+	}
+	if data.Finished {
+		data.FinishedAt = m.FinishedAt.Format(time.RFC822)
+	}
+	if data.Total > 0 {
+		data.Percent = data.Done * 100 / data.Total
+	}
+	for _, app := range m.Apps {
+		if app.Status == migration.AppStatusFailed {
+			data.Failed++
+		}
+		data.Apps = append(data.Apps, assets.MigrationApp{Name: app.AppName, Status: string(app.Status), Error: app.Error})
+	}
+	t, err := htmlTemplate.New("").Parse(assets.MigrationHtml)
 	if err != nil {
-		return errors.WithMessage(err, "get upload data")
+		return err
 	}
-	defer file.Close()
-	if err := app.SetFile(storage.AppSignedFile, file); err != nil {
-		return errors.WithMessage(err, "set signed file")
+	var result bytes.Buffer
+	if err := t.Execute(&result, data); err != nil {
+		return err
 	}
-	if err := app.SetString(storage.AppBundleId, c.FormValue("bundle_id")); err != nil {
-		return errors.WithMessage(err, "set bundle id")
+	return c.HTMLBlob(200, result.Bytes())
+}
+
+// getHealth reports that the server is running, for liveness probes.
+func getHealth(c echo.Context) error {
+	return c.JSON(200, map[string]string{"status": string(health.StatusOk)})
+}
+
+// getReadiness runs all health checks, responding with 503 if the server is unable to sign.
+// The checks name profiles, paths and builders, so they are only listed for those who can log in to the web interface.
+func getReadiness(c echo.Context) error {
+	report := health.Readiness()
+	if draining.Load() {
+		report.Status = health.StatusFail
+		report.Checks = append(report.Checks, health.Check{Name: "server", Status: health.StatusFail, Message: "shutting down"})
 	}
-	if !storage.Jobs.DeleteById(job.Id) {
-		return errors.Errorf("unable to delete return job %s", job.Id)
+	code := 200
+	if report.Status == health.StatusFail {
+		code = 503
 	}
-	return c.NoContent(200)
+	if !hasBasicAuth(c) {
+		return c.JSON(code, map[string]string{"status": string(report.Status)})
+	}
+	return c.JSON(code, report)
 }
 
-func get2FA(c echo.Context, job *storage.ReturnJob) error {
-	code := job.TwoFactorCode.Load()
-	if code == "" {
-		return c.NoContent(404)
-	} else {
-		return c.String(200, code)
+// hasBasicAuth reports whether the request has the credentials of the web interface, or doesn't need them,
+// for routes that are public but show more to those who log in.
+func hasBasicAuth(c echo.Context) bool {
+	basicAuth := config.Current.File().BasicAuth
+	if !basicAuth.Enable {
+		return true
 	}
+	username, password, ok := c.Request().BasicAuth()
+	return ok && username == basicAuth.Username && password == basicAuth.Password
 }
 
-func appResolver(handler func(echo.Context, storage.App) error) func(c echo.Context) error {
-	return func(c echo.Context) error {
-		id := c.Param("id")
-		app, ok := storage.Apps.Get(id)
-		if !ok {
-			return c.NoContent(404)
+// How long the measured size of the save dir is reused, as walking it is slow with many apps.
+const storageMetricMaxAge = time.Minute
+
+// registerMetrics adds the metrics that are read from the current state on every scrape.
+func registerMetrics() {
+	metrics.NewGaugeFunc("signtools_queue_depth", "Sign jobs waiting in the queue, by priority.", func() []metrics.Sample {
+		counts := map[storage.JobPriority]int{}
+		for _, job := range storage.Jobs.GetQueue() {
+			counts[job.Priority]++
 		}
-		return handler(c, app)
-	}
+		var samples []metrics.Sample
+		for _, priority := range storage.JobPriorities() {
+			samples = append(samples, metrics.Sample{LabelValues: []string{priority.String()}, Value: float64(counts[priority])})
+		}
+		return samples
+	}, "priority")
+	metrics.NewGaugeFunc("signtools_jobs_running", "Sign jobs taken by builders.", func() []metrics.Sample {
+		return []metrics.Sample{{Value: float64(len(storage.Jobs.GetRunningJobs()))}}
+	})
+	metrics.NewGaugeFunc("signtools_builder_active_workers", "Workers of the integrated builder that are running a job.", func() []metrics.Sample {
+		var samples []metrics.Sample
+		for builderId, builder := range config.Current.Builder {
+			if integrated, ok := builder.(*builders.Integrated); ok {
+				samples = append(samples, metrics.Sample{LabelValues: []string{builderId}, Value: float64(integrated.GetActiveJobCount())})
+			}
+		}
+		return samples
+	}, "builder")
+	metrics.NewGaugeFunc("signtools_builder_max_workers", "Jobs the integrated builder may run in parallel.", func() []metrics.Sample {
+		var samples []metrics.Sample
+		for builderId, builder := range config.Current.Builder {
+			if integrated, ok := builder.(*builders.Integrated); ok {
+				samples = append(samples, metrics.Sample{LabelValues: []string{builderId}, Value: float64(integrated.GetMaxConcurrentJobs())})
+			}
+		}
+		return samples
+	}, "builder")
+	metrics.NewGaugeFunc("signtools_apps", "Apps in storage.", func() []metrics.Sample {
+		apps, err := storage.Apps.GetAll()
+		if err != nil {
+			log.Err(err).Msg("get apps for metrics")
+			return nil
+		}
+		return []metrics.Sample{{Value: float64(len(apps))}}
+	})
+	metrics.NewGaugeFunc("signtools_storage_bytes", "Total size of the files in the save dir.",
+		metrics.DirSize(config.Current.File().SaveDir, storageMetricMaxAge))
 }
 
-func jobResolver(handler func(echo.Context, *storage.ReturnJob) error) func(c echo.Context) error {
+// metricsAuth checks the metrics credentials, which are separate from the web interface's.
+// Either the basic auth credentials or the bearer token are accepted, and anyone if neither is configured.
+func metricsAuth(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		id := c.Param("id")
-		job, ok := storage.Jobs.GetById(id)
-		if !ok {
-			return c.NoContent(404)
+		metricsConfig := config.Current.File().Metrics
+		if !metricsConfig.BasicAuth.Enable && metricsConfig.BearerToken == "" {
+			return next(c)
 		}
-		return handler(c, job)
+		req := c.Request()
+		if metricsConfig.BearerToken != "" && req.Header.Get("Authorization") == "Bearer "+metricsConfig.BearerToken {
+			return next(c)
+		}
+		if username, password, ok := req.BasicAuth(); ok && metricsConfig.BasicAuth.Enable &&
+			username == metricsConfig.BasicAuth.Username && password == metricsConfig.BasicAuth.Password {
+			return next(c)
+		}
+		if metricsConfig.BasicAuth.Enable {
+			c.Response().Header().Set("WWW-Authenticate", `Basic realm="metrics"`)
+		}
+		return echo.NewHTTPError(http.StatusUnauthorized)
 	}
 }
 
-func getLastJob(c echo.Context) error {
-	if err := storage.Jobs.TakeLastJob(c.Response()); errors.Is(err, storage.ErrNotFound) {
-		return c.NoContent(404)
-	} else if err != nil {
-		return err
+func getMetrics(c echo.Context) error {
+	var result bytes.Buffer
+	if err := metrics.Write(&result); err != nil {
+		return errors.WithMessage(err, "write metrics")
+	}
+	return c.Blob(200, metrics.ContentType, result.Bytes())
+}
+
+// Comments are sent this often on an idle event stream, so proxies don't close the connection.
+const eventKeepAliveInterval = 15 * time.Second
+
+// streamEvents follows job state changes and sign script output as server-sent events.
+// Use the "app_id" query parameter to only receive the events of one app,
+// and "output=false" to leave out the sign script output.
+func streamEvents(c echo.Context) error {
+	appId := c.QueryParam("app_id")
+	withOutput := c.QueryParam("output") != "false"
+	eventChan, unsubscribe := events.Subscribe(func(event events.Event) bool {
+		if appId != "" && event.AppId != appId {
+			return false
+		}
+		return withOutput || event.Type.IsJobState()
+	})
+	defer unsubscribe()
+
+	res := c.Response()
+	res.Header().Set("Content-Type", "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(200)
+	res.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-streamsCtx.Done():
+			return nil
+		case <-keepAlive.C:
+			if _, err := io.WriteString(res, ": keep-alive\n\n"); err != nil {
+				return nil
+			}
+		case event := <-eventChan:
+			data, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(res, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+				return nil
+			}
+		}
+		res.Flush()
 	}
-	return c.NoContent(200)
 }
 
 func render2FAPage(c echo.Context, _ storage.App) error {
@@ -594,13 +1938,57 @@ func deleteApp(c echo.Context, app storage.App) error {
 }
 
 func getManifest(c echo.Context, app storage.App) error {
-	manifestBytes, err := makeManifest(getBaseUrl(c), app)
-	if err != nil {
+	return writeManifest(c, app)
+}
+
+// Installs several apps at once, given by repeated app_id parameters.
+func getMultiManifest(c echo.Context) error {
+	var apps []storage.App
+	for _, appId := range c.QueryParams()["app_id"] {
+		app, ok := storage.Apps.Get(appId)
+		if !ok {
+			return c.NoContent(404)
+		}
+		apps = append(apps, app)
+	}
+	return writeManifest(c, apps...)
+}
+
+func writeManifest(c echo.Context, apps ...storage.App) error {
+	manifestBytes, err := makeManifest(getBaseUrl(c), apps...)
+	var manifestErr manifestError
+	if errors.As(err, &manifestErr) {
+		return c.String(400, "Unable to install: "+err.Error())
+	} else if err != nil {
 		return err
 	}
 	return c.Blob(200, "text/plain", manifestBytes)
 }
 
+func getAppIcon(c echo.Context, app storage.App) error {
+	file, err := storage.GetAppIcon(app)
+	if err != nil {
+		return err
+	} else if file == nil {
+		return c.NoContent(404)
+	}
+	defer file.Close()
+	return c.Stream(200, "image/png", file)
+}
+
+// Returns the url of the icon of an app, or empty if it has none.
+func getAppIconUrl(app storage.App) string {
+	file, err := storage.GetAppIcon(app)
+	if err != nil {
+		logErrApp(err, app).Msg("get app icon")
+		return ""
+	} else if file == nil {
+		return ""
+	}
+	file.Close()
+	return path.Join("/apps", app.GetId(), "icon")
+}
+
 func getBaseUrl(c echo.Context) string {
 	var host = ""
 	if value := c.Request().Header.Get("X-Forwarded-Host"); value != "" {
@@ -615,7 +2003,13 @@ func getBaseUrl(c echo.Context) string {
 	return serverUrl.String()
 }
 
-func makeManifest(baseUrl string, app storage.App) ([]byte, error) {
+// manifestError is returned when an OTA manifest can't be made, as the install would fail on the device.
+type manifestError struct {
+	error
+}
+
+// Makes an OTA manifest that installs the signed apps, one item each.
+func makeManifest(baseUrl string, apps ...storage.App) ([]byte, error) {
 	t, err := textTemplate.New("").Funcs(
 		textTemplate.FuncMap{"escape": func(text string) (string, error) {
 			return escapeXML(text)
@@ -624,28 +2018,91 @@ func makeManifest(baseUrl string, app storage.App) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	if len(apps) < 1 {
+		return nil, manifestError{errors.New("no apps to install")}
+	}
+	var data assets.ManifestData
+	for _, app := range apps {
+		item, err := makeManifestItem(baseUrl, app)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "app %s", app.GetId())
+		}
+		data.Items = append(data.Items, *item)
+	}
+	var result bytes.Buffer
+	if err := t.Execute(&result, data); err != nil {
+		return nil, err
+	}
+	return result.Bytes(), nil
+}
+
+func makeManifestItem(baseUrl string, app storage.App) (*assets.ManifestItem, error) {
+	if signed, err := app.IsSigned(); err != nil {
+		return nil, errors.WithMessage(err, "get is signed")
+	} else if !signed {
+		return nil, manifestError{errors.New("the app is not signed")}
+	}
 	appName, err := app.GetString(storage.AppName)
 	if err != nil {
 		return nil, err
 	}
-	bundleId, err := app.GetString(storage.AppBundleId)
+	info, err := storage.GetSignedAppInfo(app)
 	if err != nil {
-		return nil, err
+		return nil, manifestError{errors.WithMessage(err, "inspect signed app")}
 	}
 	downloadUrl, err := util.JoinUrls(baseUrl, "/apps", app.GetId(), "signed")
 	if err != nil {
 		return nil, err
 	}
-	data := assets.ManifestData{
-		DownloadUrl: downloadUrl,
-		BundleId:    bundleId,
-		Title:       appName,
+	item := &assets.ManifestItem{
+		DownloadUrl:   downloadUrl,
+		BundleId:      info.BundleId,
+		BundleVersion: info.Version,
+		Title:         appName,
 	}
-	var result bytes.Buffer
-	if err := t.Execute(&result, data); err != nil {
-		return nil, err
+	// the marketing version, which is what Xcode puts in the manifests it exports
+	if item.BundleVersion == "" {
+		item.BundleVersion = info.Build
 	}
-	return result.Bytes(), nil
+	if info.Version != "" {
+		item.Subtitle = "Version " + info.Version
+		if info.Build != "" && info.Build != info.Version {
+			item.Subtitle += fmt.Sprintf(" (%s)", info.Build)
+		}
+	}
+	if iconUrl := getAppIconUrl(app); iconUrl != "" {
+		if item.IconUrl, err = util.JoinUrls(baseUrl, iconUrl); err != nil {
+			return nil, err
+		}
+	}
+	if err := validateManifestItem(item); err != nil {
+		return nil, manifestError{err}
+	}
+	return item, nil
+}
+
+// Checks what iOS requires of a manifest item, since it doesn't tell why an install failed.
+func validateManifestItem(item *assets.ManifestItem) error {
+	if item.BundleId == "" {
+		return errors.New("the signed app has no bundle id")
+	}
+	if item.BundleVersion == "" {
+		return errors.New("the signed app has no version")
+	}
+	urls := []string{item.DownloadUrl}
+	if item.IconUrl != "" {
+		urls = append(urls, item.IconUrl)
+	}
+	for _, rawUrl := range urls {
+		parsedUrl, err := url.Parse(rawUrl)
+		if err != nil {
+			return errors.WithMessagef(err, "parse url %s", rawUrl)
+		}
+		if parsedUrl.Scheme != "https" || parsedUrl.Host == "" {
+			return errors.Errorf("iOS only installs apps over https, but the url is %s", rawUrl)
+		}
+	}
+	return nil
 }
 
 func escapeXML(str string) (string, error) {
@@ -725,6 +2182,9 @@ func uploadUnsignedApp(c echo.Context) error {
 	if !ok {
 		return errors.New("no profile with id " + profileId)
 	}
+	if !storage.Profiles.IsUsable(profileId) {
+		return errors.New("profile is invalid, id " + profileId)
+	}
 	builderId := c.FormValue(formNames.FormBuilderId)
 	builder, ok := config.Current.Builder[builderId]
 	if !ok {
@@ -733,13 +2193,19 @@ func uploadUnsignedApp(c echo.Context) error {
 
 	var file io.ReadCloser
 	var fileName string
+	// uploads are validated once they finish, so they aren't read all over again
+	validated := false
 	fileId := c.FormValue(formNames.FormFileId)
 	fileUrl := c.FormValue(formNames.FormFileUrl)
 	if fileUrl != "" {
 		resp, err := http.Get(fileUrl)
-		if err != nil || resp.StatusCode < 200 || resp.StatusCode > 299 {
+		if err != nil {
 			return c.String(400, "Failed to download app from url: "+err.Error())
 		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			resp.Body.Close()
+			return c.String(400, "Failed to download app from url: "+resp.Status)
+		}
 		file = resp.Body
 		defer file.Close()
 		fileName = filepath.Base(fileUrl)
@@ -762,6 +2228,7 @@ func uploadUnsignedApp(c echo.Context) error {
 		}
 		file = readonlyFile
 		defer file.Close()
+		validated = upload.IsValidated()
 		info, err := upload.GetInfo()
 		if err != nil {
 			return err
@@ -801,8 +2268,15 @@ func uploadUnsignedApp(c echo.Context) error {
 			tweakMap[info.MetaData["filename"]] = readonlyFile
 		}
 	}
-	app, err := storage.Apps.New(file, fileName, profile, signArgs, userBundleId, builderId, tweakMap)
-	if err != nil {
+	var unsignedFile io.Reader = file
+	if validated {
+		unsignedFile = storage.ValidatedIpa{Reader: file}
+	}
+	app, err := storage.Apps.New(unsignedFile, fileName, profile, signArgs, userBundleId, builderId, tweakMap)
+	var invalidErr storage.InvalidAppError
+	if errors.As(err, &invalidErr) {
+		return c.String(400, "Unable to sign: "+err.Error())
+	} else if err != nil {
 		return err
 	}
 	if bundleName != "" {
@@ -810,20 +2284,37 @@ func uploadUnsignedApp(c echo.Context) error {
 			return err
 		}
 	}
-	if err := startSign(app, builder); err != nil {
+	if err := startSign(app, builder, storage.JobPriorityInteractive); err != nil {
 		return err
 	}
 	return c.Redirect(302, "/")
 }
 
 func resignApp(c echo.Context, app storage.App) error {
-	builderId, err := app.GetString(storage.AppBuilderId)
+	priority := storage.JobPriorityNormal
+	if value := c.QueryParam("priority"); value != "" {
+		var err error
+		if priority, err = storage.ParseJobPriority(value); err != nil {
+			return c.String(400, err.Error())
+		}
+	}
+	if err := queueResign(app, priority); errors.Is(err, storage.ErrJobRunning) {
+		return c.String(409, "Unable to re-sign: "+err.Error())
+	} else if err != nil {
+		return err
+	}
+	return c.Redirect(302, "/")
+}
+
+// queueResign removes the signed file of an app and queues a new sign job for it.
+func queueResign(app storage.App, priority storage.JobPriority) error {
+	builder, err := getSignBuilder(app)
 	if err != nil {
 		return err
 	}
-	builder, ok := config.Current.Builder[builderId]
-	if !ok {
-		return errors.New("no builder with id " + builderId)
+	// the running job would upload its signed file after it was removed
+	if _, running := storage.Jobs.GetStatusByAppId(app.GetId()); running {
+		return storage.ErrJobRunning
 	}
 	if err := app.RemoveFile(storage.AppSignedFile); err != nil && !os.IsNotExist(err) {
 		return err
@@ -831,10 +2322,30 @@ func resignApp(c echo.Context, app storage.App) error {
 	if err := app.ResetModTime(); err != nil {
 		return err
 	}
-	if err := startSign(app, builder); err != nil {
+	return startSign(app, builder, priority)
+}
+
+// queueReplacingResign queues a new sign job for an app, but keeps its signed file until the job uploads the new one,
+// so that the app can still be installed in the meantime, and keeps working if the job fails.
+func queueReplacingResign(app storage.App, priority storage.JobPriority) error {
+	builder, err := getSignBuilder(app)
+	if err != nil {
 		return err
 	}
-	return c.Redirect(302, "/")
+	return startSign(app, builder, priority)
+}
+
+// Returns the builder an app is signed with, failing if it was removed from the configuration.
+func getSignBuilder(app storage.App) (builders.Builder, error) {
+	builderId, err := app.GetString(storage.AppBuilderId)
+	if err != nil {
+		return nil, err
+	}
+	builder, ok := config.Current.Builder[builderId]
+	if !ok {
+		return nil, errors.New("no builder with id " + builderId)
+	}
+	return builder, nil
 }
 
 // buildSignArgs constructs signing arguments from form values
@@ -870,13 +2381,15 @@ func buildSignArgs(c echo.Context, idType, userBundleId string) string {
 }
 
 // startSign initiates the signing process for an app
-func startSign(app storage.App, builder builders.Builder) error {
+func startSign(app storage.App, builder builders.Builder, priority storage.JobPriority) error {
 	profileId, err := app.GetString(storage.AppProfileId)
 	if err != nil {
 		return errors.WithMessage(err, "get profile id")
 	}
 
-	storage.Jobs.MakeSignJob(app.GetId(), profileId)
+	if err := storage.Jobs.MakeSignJob(app.GetId(), profileId, priority); err != nil {
+		return errors.WithMessage(err, "make sign job")
+	}
 
 	if err := config.SetBuilderSecrets(builder); err != nil {
 		return errors.WithMessage(err, "set builder secrets")
@@ -909,9 +2422,28 @@ func renderIndex(c echo.Context) error {
 		return err
 	}
 	data := assets.IndexData{
-		FormNames: formNames,
+		FormNames:      formNames,
+		ExpiringFilter: c.QueryParam("expiring") == "true",
+	}
+	expiring, err := getExpiringProfileList()
+	if err != nil {
+		return errors.WithMessage(err, "get expiring profiles")
+	}
+	expiringIds := map[string]bool{}
+	for _, profile := range expiring {
+		expiringIds[profile.ProfileId] = true
+		data.Expiring = append(data.Expiring, assets.ExpiringProfile{
+			Message:  profile.Message,
+			Expired:  profile.Expired,
+			AppCount: len(profile.AppIds),
+		})
 	}
 	for _, app := range apps {
+		if data.ExpiringFilter {
+			if profileId, _ := app.GetString(storage.AppProfileId); !expiringIds[profileId] {
+				continue
+			}
+		}
 		isSigned, err := app.IsSigned()
 		if err != nil {
 			return errors.WithMessage(err, "get is signed")
@@ -954,6 +2486,55 @@ func renderIndex(c echo.Context) error {
 		} else {
 			status = assets.AppStatusFailed
 		}
+		var lastError string
+		var logUrl string
+		var retryInfo string
+		if history, err := storage.GetJobHistory(app); err != nil {
+			logErrApp(err, app).Msg("get job history")
+		} else if len(history) > 0 {
+			lastJob := history[len(history)-1]
+			if status == assets.AppStatusFailed {
+				lastError = lastJob.Error
+			}
+			if storage.HasJobLog(app, lastJob.Id) {
+				logUrl = path.Join("/apps", app.GetId(), "logs", lastJob.Id)
+			}
+			// a retry waiting in the queue, after the failed attempt before it
+			if status == assets.AppStatusWaiting && lastJob.Attempt > 1 && len(history) > 1 {
+				failedJob := history[len(history)-2]
+				lastError = failedJob.Error
+				retryInfo = fmt.Sprintf("Retry %d after %s", lastJob.Attempt-1, failedJob.RetryAt.Format(time.Kitchen))
+				if logUrl == "" && storage.HasJobLog(app, failedJob.Id) {
+					logUrl = path.Join("/apps", app.GetId(), "logs", failedJob.Id)
+				}
+			}
+		}
+
+		var nextResign string
+		if plan, err := resign.GetPlan(app, time.Now()); err != nil {
+			logErrApp(err, app).Msg("get resign plan")
+		} else if !plan.NextResign.IsZero() {
+			nextResign = plan.NextResign.Format(time.RFC822)
+		}
+
+		var displayName, version, originalBundleId, minimumOS, deviceFamilies, architectures string
+		var encrypted bool
+		if info, err := storage.GetAppInfo(app); err != nil {
+			logErrApp(err, app).Msg("get app info")
+		} else if info.Error == "" {
+			displayName = info.DisplayName
+			version = info.Version
+			if info.Build != "" && info.Build != info.Version {
+				version = fmt.Sprintf("%s (%s)", info.Version, info.Build)
+			}
+			originalBundleId = info.BundleId
+			minimumOS = info.MinimumOSVersion
+			deviceFamilies = strings.Join(info.DeviceFamilies, ", ")
+			if info.Binaries != nil {
+				architectures = strings.Join(info.Binaries.Main.Architectures, ", ")
+				encrypted = len(info.Binaries.GetEncrypted()) > 0
+			}
+		}
 
 		tweakCount := 0
 		if tweaks, err := app.ReadDir(storage.TweaksDir); err == nil {
@@ -976,9 +2557,24 @@ func renderIndex(c echo.Context) error {
 			DownloadTweaksUrl:   path.Join("/apps", app.GetId(), "tweaks"),
 			TwoFactorUrl:        path.Join("/apps", app.GetId(), "2fa"),
 			ResignUrl:           path.Join("/apps", app.GetId(), "resign"),
+			CancelUrl:           path.Join("/apps", app.GetId(), "cancel"),
 			DeleteUrl:           path.Join("/apps", app.GetId(), "delete"),
 			RenameUrl:           path.Join("/apps", app.GetId(), "rename"),
+			AutoResignUrl:       path.Join("/apps", app.GetId(), "auto-resign"),
+			JobsUrl:             path.Join("/apps", app.GetId(), "jobs"),
+			LogUrl:              logUrl,
 			TweakCount:          tweakCount,
+			LastError:           lastError,
+			RetryInfo:           retryInfo,
+			NextResign:          nextResign,
+			DisplayName:         displayName,
+			Version:             version,
+			OriginalBundleId:    originalBundleId,
+			MinimumOSVersion:    minimumOS,
+			DeviceFamilies:      deviceFamilies,
+			Architectures:       architectures,
+			Encrypted:           encrypted,
+			IconUrl:             getAppIconUrl(app),
 		})
 	}
 	profiles, err := storage.Profiles.GetAll()
@@ -986,6 +2582,9 @@ func renderIndex(c echo.Context) error {
 		return err
 	}
 	for _, profile := range profiles {
+		if !storage.Profiles.IsUsable(profile.GetId()) {
+			continue
+		}
 		name, err := profile.GetString(storage.ProfileName)
 		if err != nil {
 			return err
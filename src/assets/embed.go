@@ -18,6 +18,27 @@ var TwoFactorHtml string
 //go:embed rename.gohtml
 var RenameHtml string
 
+//go:embed queue.gohtml
+var QueueHtml string
+
+//go:embed profiles.gohtml
+var ProfilesHtml string
+
+//go:embed profile_details.gohtml
+var ProfileDetailsHtml string
+
+//go:embed profile.gohtml
+var ProfileHtml string
+
+//go:embed resign.gohtml
+var ResignHtml string
+
+//go:embed migrate.gohtml
+var MigrateHtml string
+
+//go:embed migration.gohtml
+var MigrationHtml string
+
 //go:embed manifest.xml
 var ManifestPlist string
 
@@ -12,11 +12,28 @@ type App struct {
 	DownloadTweaksUrl   string
 	TwoFactorUrl        string
 	ResignUrl           string
+	CancelUrl           string
 	DeleteUrl           string
 	RenameUrl           string
+	AutoResignUrl       string
+	JobsUrl             string
+	LogUrl              string
 	ProfileName         string
 	BundleId            string
 	TweakCount          int
+	LastError           string
+	RetryInfo           string
+	NextResign          string
+	// From the Info.plist of the unsigned ipa.
+	DisplayName      string
+	Version          string
+	OriginalBundleId string
+	MinimumOSVersion string
+	DeviceFamilies   string
+	Architectures    string
+	// Still encrypted by the App Store, so it will crash on launch.
+	Encrypted bool
+	IconUrl   string
 }
 
 const (
@@ -63,20 +80,181 @@ type IndexData struct {
 	Apps     []App
 	Profiles []Profile
 	Builders []Builder
+	// Profiles that expired or expire soon, and whether only the apps signed with them are shown.
+	Expiring       []ExpiringProfile
+	ExpiringFilter bool
 	FormNames
 }
 
+type ExpiringProfile struct {
+	Message  string
+	Expired  bool
+	AppCount int
+}
+
 type ManifestData struct {
-	DownloadUrl string
-	BundleId    string
-	Title       string
+	Items []ManifestItem
+}
+
+type ManifestItem struct {
+	DownloadUrl   string
+	BundleId      string
+	BundleVersion string
+	Title         string
+	Subtitle      string
+	// A full url, or empty if the app has no icon.
+	IconUrl string
 }
 
 type RenameData struct {
 	AppName string
 }
 
+type QueueJob struct {
+	Position    int
+	AppName     string
+	ProfileName string
+	Priority    string
+	Time        string
+	PriorityUrl string
+	UpUrl       string
+	DownUrl     string
+	RemoveUrl   string
+}
+
+type QueueData struct {
+	Running    []QueueJob
+	Queued     []QueueJob
+	Priorities []string
+}
+
+type ProfileRow struct {
+	Id           string
+	Name         string
+	IsAccount    bool
+	TeamId       string
+	ExpiresAt    string
+	ExpirySource string
+	Expiring     bool
+	Expired      bool
+	AppCount     int
+	ReadOnly     bool
+	Usable       bool
+	Loaded       bool
+	Error        string
+	DetailsUrl   string
+	EditUrl      string
+	DeleteUrl    string
+	// Set if the profile's apps are re-signed automatically.
+	AutoResign    bool
+	AutoResignUrl string
+	MigrateUrl    string
+}
+
+type ProfilesData struct {
+	Profiles []ProfileRow
+}
+
+type Certificate struct {
+	Subject      string
+	SerialNumber string
+	Expiry       string
+	Sha1         string
+}
+
+type ProvisioningProfile struct {
+	Name                  string
+	UUID                  string
+	Type                  string
+	AppId                 string
+	AppIdName             string
+	TeamName              string
+	Platforms             string
+	Created               string
+	Expires               string
+	Devices               []string
+	Entitlements          string
+	DeveloperCertificates []Certificate
+}
+
+type ProfileDetailsData struct {
+	Id           string
+	Name         string
+	IsAccount    bool
+	TeamId       string
+	Error        string
+	ReadOnly     bool
+	EditUrl      string
+	Certificates []Certificate
+	Provisioning *ProvisioningProfile
+}
+
+type ProfileFormData struct {
+	// Empty when creating a profile.
+	Id          string
+	Name        string
+	IsAccount   bool
+	AccountName string
+}
+
+type ResignData struct {
+	// Whether the settings are those of an app, or else of a profile.
+	IsApp            bool
+	Name             string
+	FormUrl          string
+	BackUrl          string
+	ProfileName      string
+	Inherit          bool
+	BeforeExpiry     bool
+	Schedule         string
+	DaysBeforeExpiry uint64
+	ExpiresAt        string
+	NextResign       string
+	History          []ResignRecord
+}
+
+type ResignRecord struct {
+	Time    string
+	AppName string
+	Reason  string
+	Error   string
+}
+
+type MigrateApp struct {
+	Id       string
+	Name     string
+	BundleId string
+}
+
+type MigrateData struct {
+	SourceName string
+	FormUrl    string
+	// The profiles that the apps can be moved to.
+	Targets []Profile
+	Apps    []MigrateApp
+}
+
+type MigrationApp struct {
+	Name   string
+	Status string
+	Error  string
+}
+
+type MigrationData struct {
+	SourceName string
+	TargetName string
+	StartedAt  string
+	FinishedAt string
+	Finished   bool
+	Done       int
+	Failed     int
+	Total      int
+	Percent    int
+	Apps       []MigrationApp
+}
+
 type InstallData struct {
 	ManifestUrl string
 	AppName     string
+	IconUrl     string
 }
@@ -1,6 +1,7 @@
 package builders
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/pkg/errors"
@@ -15,39 +16,110 @@ type IntegratedData struct {
 	SignFilesDir  string `yaml:"sign_files_dir"`
 	Entrypoint    string `yaml:"entrypoint"`
 	JobTimeoutMin uint64 `yaml:"job_timeout_mins"`
+	// Jobs of the same profile never run at the same time, regardless of this limit.
+	MaxConcurrentJobs uint64      `yaml:"max_concurrent_jobs"`
+	Retry             RetryPolicy `yaml:"retry"`
 }
 
 type Integrated struct {
+	// Guards the settings below, which Reconfigure replaces while jobs run.
+	settingsMu sync.RWMutex
 	*IntegratedData
+	jobTimeout time.Duration
+	retry      *retryRules
+
+	// Counts the running jobs against the limit, which Reconfigure may change while they run.
+	slotsMu   sync.Mutex
+	slotsFree *sync.Cond
+	usedSlots uint64
+	maxSlots  uint64
+
 	secrets      atomic.Value
 	jobChan      chan bool
-	workerChan   chan bool
-	jobTimeout   time.Duration
-	processJobFn func() error
+	activeJobs   atomic.Int32
+	processJobFn func() (bool, error)
 	initialized  sync.Once
+	runningMu    sync.Mutex
+	running      map[string]context.CancelCauseFunc
+	// Set once the grace period of Shutdown is over, guarded by runningMu.
+	stopping bool
+	// Guards draining, so that no job starts after Shutdown began waiting.
+	drainMu  sync.Mutex
+	draining bool
+	jobs     sync.WaitGroup
 }
 
+// The cause of the context of a job that was cancelled on request.
+var errJobCancelled = errors.New("job cancelled")
+
+// The cause of the context of a job that was stopped because the server is shutting down.
+var errServerShutdown = errors.New("server shutting down")
+
 func MakeIntegrated(data *IntegratedData) *Integrated {
 	integrated := &Integrated{
-		IntegratedData: data,
-		jobChan:         make(chan bool, 1000),
-		workerChan:      make(chan bool, 1),
-		jobTimeout:      time.Duration(data.JobTimeoutMin) * time.Minute,
-	}
-	if integrated.jobTimeout == 0 {
-		integrated.jobTimeout = 15 * time.Minute
-	}
-	if integrated.Entrypoint == "" {
-		integrated.Entrypoint = "sign.py"
+		jobChan: make(chan bool, 1000),
+		running: map[string]context.CancelCauseFunc{},
 	}
+	integrated.slotsFree = sync.NewCond(&integrated.slotsMu)
+	integrated.Reconfigure(data)
 	integrated.secrets.Store(map[string]string{})
 	return integrated
 }
 
+// Reconfigure applies new settings, such as after the config file was reloaded.
+// Running jobs keep the settings they started with. If the number of concurrent jobs is lowered,
+// new jobs only start once enough of the running ones finished.
+func (i *Integrated) Reconfigure(data *IntegratedData) {
+	if data.MaxConcurrentJobs == 0 {
+		data.MaxConcurrentJobs = 1
+	}
+	if data.Entrypoint == "" {
+		data.Entrypoint = "sign.py"
+	}
+	jobTimeout := time.Duration(data.JobTimeoutMin) * time.Minute
+	if jobTimeout == 0 {
+		jobTimeout = 15 * time.Minute
+	}
+	i.settingsMu.Lock()
+	i.IntegratedData = data
+	i.jobTimeout = jobTimeout
+	i.retry = makeRetryRules(data.Retry)
+	i.settingsMu.Unlock()
+	i.slotsMu.Lock()
+	i.maxSlots = data.MaxConcurrentJobs
+	i.slotsMu.Unlock()
+	// a raised limit lets the worker start a job right away
+	i.slotsFree.Broadcast()
+}
+
+// Waits until fewer jobs are running than the current limit allows, and takes a slot for a new one.
+func (i *Integrated) acquireSlot() {
+	i.slotsMu.Lock()
+	defer i.slotsMu.Unlock()
+	for i.usedSlots >= i.maxSlots {
+		i.slotsFree.Wait()
+	}
+	i.usedSlots++
+}
+
+func (i *Integrated) releaseSlot() {
+	i.slotsMu.Lock()
+	i.usedSlots--
+	i.slotsMu.Unlock()
+	i.slotsFree.Broadcast()
+}
+
+func (i *Integrated) getRetryRules() *retryRules {
+	i.settingsMu.RLock()
+	defer i.settingsMu.RUnlock()
+	return i.retry
+}
+
 // SetProcessJobFn sets the function that will process jobs.
 // This is called from main.go to avoid import cycles.
 // The function receives the necessary dependencies via dependency injection.
-func (i *Integrated) SetProcessJobFn(fn func() error) {
+// It must return whether it took a job from the queue.
+func (i *Integrated) SetProcessJobFn(fn func() (bool, error)) {
 	i.processJobFn = fn
 	i.initialized.Do(func() {
 		i.startWorker()
@@ -58,15 +130,30 @@ func (i *Integrated) startWorker() {
 	go func() {
 		for {
 			<-i.jobChan
-			i.workerChan <- true
+			i.acquireSlot()
+			if !i.startJob() {
+				// the jobs stay queued until the next start
+				i.releaseSlot()
+				continue
+			}
+			i.activeJobs.Add(1)
 			go func() {
 				defer func() {
-					<-i.workerChan
+					i.activeJobs.Add(-1)
+					i.releaseSlot()
+					i.jobs.Done()
 				}()
 				if i.processJobFn != nil {
-					if err := i.processJobFn(); err != nil {
+					processed, err := i.processJobFn()
+					if err != nil {
 						log.Error().Err(err).Msg("integrated builder job failed")
 					}
+					// Jobs may have been skipped while their profile was busy with this one, so look again.
+					if processed {
+						if err := i.Trigger(); err != nil {
+							log.Warn().Err(err).Msg("re-trigger integrated builder")
+						}
+					}
 				} else {
 					log.Warn().Msg("integrated builder processJobFn not set")
 				}
@@ -90,6 +177,81 @@ func (i *Integrated) Trigger() error {
 	}
 }
 
+// Reports whether a job may start, and if so, counts it as running until jobs.Done is called.
+func (i *Integrated) startJob() bool {
+	i.drainMu.Lock()
+	defer i.drainMu.Unlock()
+	if i.draining {
+		return false
+	}
+	i.jobs.Add(1)
+	return true
+}
+
+// Shutdown stops taking jobs from the queue and waits for the running jobs to finish.
+// Jobs still running once ctx is done have their sign script killed and are queued again,
+// so that they run after the next start.
+func (i *Integrated) Shutdown(ctx context.Context) {
+	i.drainMu.Lock()
+	i.draining = true
+	i.drainMu.Unlock()
+	done := make(chan bool)
+	go func() {
+		i.jobs.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+	i.runningMu.Lock()
+	i.stopping = true
+	for jobId, cancel := range i.running {
+		log.Info().Str("job_id", jobId).Msg("stopping integrated sign job for shutdown")
+		cancel(errServerShutdown)
+	}
+	i.runningMu.Unlock()
+	<-done
+}
+
+// Cancel stops a running job. Its sign script is killed, and the job is recorded as cancelled once it has exited.
+func (i *Integrated) Cancel(jobId string) bool {
+	i.runningMu.Lock()
+	defer i.runningMu.Unlock()
+	cancel, ok := i.running[jobId]
+	if !ok {
+		return false
+	}
+	cancel(errJobCancelled)
+	return true
+}
+
+func (i *Integrated) trackJob(jobId string, cancel context.CancelCauseFunc) {
+	i.runningMu.Lock()
+	defer i.runningMu.Unlock()
+	i.running[jobId] = cancel
+	// the job was taken from the queue just as Shutdown stopped the others
+	if i.stopping {
+		cancel(errServerShutdown)
+	}
+}
+
+func (i *Integrated) untrackJob(jobId string) {
+	i.runningMu.Lock()
+	defer i.runningMu.Unlock()
+	delete(i.running, jobId)
+}
+
+// Triggers the builder once the delay has passed, for jobs that can't be taken before then.
+func (i *Integrated) triggerAfter(delay time.Duration) {
+	time.AfterFunc(delay, func() {
+		if err := i.Trigger(); err != nil {
+			log.Warn().Err(err).Msg("delayed trigger of integrated builder")
+		}
+	})
+}
+
 func (i *Integrated) SetSecrets(secrets map[string]string) error {
 	i.secrets.Store(secrets)
 	return nil
@@ -98,24 +260,38 @@ func (i *Integrated) SetSecrets(secrets map[string]string) error {
 // GetStatusUrl returns a data URL containing JSON status information for the integrated builder.
 // This includes pending and active job counts.
 func (i *Integrated) GetStatusUrl() (string, error) {
-	pendingJobs := len(i.jobChan)
-	activeJobs := 0
-	select {
-	case <-i.workerChan:
-		activeJobs = 1
-		i.workerChan <- true
-	default:
-	}
-
 	status := map[string]interface{}{
-		"pending_jobs": pendingJobs,
-		"active_jobs":  activeJobs,
-		"type":         "integrated",
+		"pending_jobs":        len(i.jobChan),
+		"active_jobs":         i.GetActiveJobCount(),
+		"max_concurrent_jobs": i.GetMaxConcurrentJobs(),
+		"type":                "integrated",
 	}
 	statusJson, _ := json.Marshal(status)
 	return fmt.Sprintf("data:application/json,%s", statusJson), nil
 }
 
+// IsWorkerStarted reports whether jobs are being processed, which starts once SetProcessJobFn is called.
+func (i *Integrated) IsWorkerStarted() bool {
+	return i.processJobFn != nil
+}
+
+// GetPendingTriggerCount returns how many triggers are waiting for a free worker.
+func (i *Integrated) GetPendingTriggerCount() int {
+	return len(i.jobChan)
+}
+
+// GetActiveJobCount returns how many jobs are running right now, out of at most GetMaxConcurrentJobs.
+func (i *Integrated) GetActiveJobCount() int {
+	return int(i.activeJobs.Load())
+}
+
+// GetMaxConcurrentJobs returns how many jobs may run in parallel
+func (i *Integrated) GetMaxConcurrentJobs() int {
+	i.settingsMu.RLock()
+	defer i.settingsMu.RUnlock()
+	return int(i.MaxConcurrentJobs)
+}
+
 // GetSecrets returns the current secrets
 func (i *Integrated) GetSecrets() map[string]string {
 	if secrets := i.secrets.Load(); secrets != nil {
@@ -126,15 +302,21 @@ func (i *Integrated) GetSecrets() map[string]string {
 
 // GetSignFilesDir returns the sign files directory
 func (i *Integrated) GetSignFilesDir() string {
+	i.settingsMu.RLock()
+	defer i.settingsMu.RUnlock()
 	return i.SignFilesDir
 }
 
 // GetEntrypoint returns the entrypoint script name
 func (i *Integrated) GetEntrypoint() string {
+	i.settingsMu.RLock()
+	defer i.settingsMu.RUnlock()
 	return i.Entrypoint
 }
 
 // GetJobTimeout returns the job timeout duration
 func (i *Integrated) GetJobTimeout() time.Duration {
+	i.settingsMu.RLock()
+	defer i.settingsMu.RUnlock()
 	return i.jobTimeout
 }
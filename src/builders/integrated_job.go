@@ -21,14 +21,22 @@ import (
 // JobStorage defines the interface for job storage operations
 // This allows ProcessIntegratedJob to work without directly importing storage package
 type JobStorage interface {
-	TakeLastJob(writer io.Writer) error
+	TakeNextJob(writer io.Writer) error
 	GetById(id string) (ReturnJob, bool)
-	DeleteById(id string) bool
+	CompleteById(id string) bool
+	FailById(id string, exitStatus int, reason string) bool
+	CancelById(id string) bool
+	// RetryById fails a return job and queues it again, to be taken once the delay has passed.
+	RetryById(id string, exitStatus int, reason string, delay time.Duration) bool
+	// RequeueById queues an interrupted return job again, without counting it as an attempt.
+	RequeueById(id string, reason string) bool
 }
 
 // ReturnJob defines the interface for return job operations
 type ReturnJob interface {
 	GetAppId() string
+	// GetAttempt returns how many times the job has been attempted, including this time.
+	GetAttempt() int
 }
 
 // AppStorage defines the interface for app storage operations
@@ -41,6 +49,24 @@ type App interface {
 	GetFile(name string) (io.ReadCloser, error)
 	SetFile(name string, file io.ReadSeeker) error
 	SetString(name string, value string) error
+	SetJobLog(jobId string, log io.Reader) error
+}
+
+// How many lines at the end of the sign script output are kept as the failure reason of a job.
+const failReasonLines = 5
+
+// lastLines returns the last n non-empty lines of the output
+func lastLines(output string, n int) string {
+	var lines []string
+	for _, line := range strings.Split(output, "\n") {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
 }
 
 // extractJobIdFromArchive extracts the job ID from a tar archive buffer
@@ -75,8 +101,9 @@ func extractJobIdFromArchive(archiveBuffer *bytes.Buffer) string {
 // ProcessIntegratedJob processes a job for the integrated builder
 // This function is called from the integrated builder's worker goroutine
 // Dependencies are injected to avoid circular imports
-func ProcessIntegratedJob(integrated *Integrated, jobStorage JobStorage, appStorage AppStorage, errNotFound error) error {
-	// Get the last job from storage and read archive into memory
+// Returns whether a job was taken from the queue, even if it then failed.
+func ProcessIntegratedJob(integrated *Integrated, jobStorage JobStorage, appStorage AppStorage, errNotFound error) (bool, error) {
+	// Get the next job from storage and read archive into memory
 	var archiveBuffer bytes.Buffer
 	var archiveErr error
 	
@@ -84,7 +111,7 @@ func ProcessIntegratedJob(integrated *Integrated, jobStorage JobStorage, appStor
 	pr, pw := io.Pipe()
 	go func() {
 		defer pw.Close()
-		if err := jobStorage.TakeLastJob(pw); err != nil {
+		if err := jobStorage.TakeNextJob(pw); err != nil {
 			if errors.Is(err, errNotFound) {
 				log.Debug().Msg("no job found for integrated builder")
 				archiveErr = err
@@ -103,31 +130,39 @@ func ProcessIntegratedJob(integrated *Integrated, jobStorage JobStorage, appStor
 		// Try to extract job ID even if archive read failed partially
 		// This allows us to clean up the job even if there was an error
 		if returnJobId := extractJobIdFromArchive(&archiveBuffer); returnJobId != "" {
-			if jobStorage.DeleteById(returnJobId) {
+			if jobStorage.FailById(returnJobId, -1, "read job archive: "+err.Error()) {
 				log.Info().Str("job_id", returnJobId).Msg("cleaned up job after archive read error")
 			}
 		}
-		return err
+		return true, err
 	}
 	pr.Close()
 
 	if archiveErr != nil {
 		if errors.Is(archiveErr, errNotFound) {
-			return nil // No job available, not an error
+			return false, nil // No job available, not an error
 		}
-		return archiveErr
+		return false, archiveErr
 	}
 
 	// Extract job ID from archive
 	returnJobId := extractJobIdFromArchive(&archiveBuffer)
 	if returnJobId == "" {
-		return errors.New("job id not found in archive")
+		return true, errors.New("job id not found in archive")
 	}
 
+	// Make the job cancellable before looking it up, so that a cancellation can't slip in between
+	ctx, cancelJob := context.WithCancelCause(context.Background())
+	defer cancelJob(nil)
+	integrated.trackJob(returnJobId, cancelJob)
+	defer integrated.untrackJob(returnJobId)
+	ctx, cancel := context.WithTimeout(ctx, integrated.GetJobTimeout())
+	defer cancel()
+
 	// Get return job to find app ID
 	returnJob, ok := jobStorage.GetById(returnJobId)
 	if !ok {
-		return errors.Errorf("return job not found: %s", returnJobId)
+		return true, errors.Errorf("return job not found: %s", returnJobId)
 	}
 	appId := returnJob.GetAppId()
 
@@ -138,9 +173,10 @@ func ProcessIntegratedJob(integrated *Integrated, jobStorage JobStorage, appStor
 	id := fmt.Sprintf("integrated-%d", time.Now().UnixNano())
 	log.Info().Str("job_id", id).Str("app_id", appId).Msg("running integrated sign job")
 
-	ctx, cancel := context.WithTimeout(context.Background(), integrated.GetJobTimeout())
-	defer cancel()
-
+	exitStatus := -1
+	failReason := ""
+	jobLog := newJobLog(appId, returnJobId, os.Stdout)
+	jobLog.Event("starting job %s for app %s", returnJobId, appId)
 	err := func() error {
 		tempDir, err := os.MkdirTemp("", "ios-signer-integrated-")
 		if err != nil {
@@ -222,12 +258,18 @@ func ProcessIntegratedJob(integrated *Integrated, jobStorage JobStorage, appStor
 		signEnv = append(signEnv, "PYTHONUNBUFFERED=1")
 		// Set flag to indicate integrated builder mode (job archive already extracted)
 		signEnv = append(signEnv, "INTEGRATED_BUILDER=1")
+		// Name the keychain after the job, so it can be removed if the script is killed
+		keychainName := jobKeychainName(returnJobId)
+		signEnv = append(signEnv, "KEYCHAIN_NAME="+keychainName)
 
 		// Execute sign script
 		entrypointPath := filepath.Join(workDir, integrated.GetEntrypoint())
 		cmd := exec.CommandContext(ctx, entrypointPath)
 		cmd.Dir = workDir
 		cmd.Env = signEnv
+		configureProcessGroup(cmd)
+		// Don't wait forever for output pipes held open by stray processes
+		cmd.WaitDelay = 10 * time.Second
 
 		// Stream output in real-time for better debugging (especially for 2FA)
 		stdout, err := cmd.StdoutPipe()
@@ -244,23 +286,21 @@ func ProcessIntegratedJob(integrated *Integrated, jobStorage JobStorage, appStor
 		}
 
 		// Stream stdout and stderr to logs in real-time
-		var outputBuffer bytes.Buffer
 		outputDone := make(chan bool)
 		go func() {
 			defer close(outputDone)
-			multiWriter := io.MultiWriter(&outputBuffer, os.Stdout)
-			
+
 			// Read from stdout and stderr concurrently
 			stdoutDone := make(chan bool)
 			stderrDone := make(chan bool)
-			
+
 			// Read stdout
 			go func() {
 				defer func() { stdoutDone <- true }()
 				scanner := bufio.NewScanner(stdout)
 				for scanner.Scan() {
 					line := scanner.Text()
-					multiWriter.Write([]byte(line + "\n"))
+					jobLog.Line("stdout", line)
 					// Log only truly important messages (errors, critical warnings, 2FA prompts)
 					// Skip routine fastlane output to reduce log noise
 					lineLower := strings.ToLower(line)
@@ -274,29 +314,40 @@ func ProcessIntegratedJob(integrated *Integrated, jobStorage JobStorage, appStor
 					}
 				}
 			}()
-			
+
 			// Read stderr
 			go func() {
 				defer func() { stderrDone <- true }()
 				scanner := bufio.NewScanner(stderr)
 				for scanner.Scan() {
 					line := scanner.Text()
-					multiWriter.Write([]byte(line + "\n"))
+					jobLog.Line("stderr", line)
 					// Log all stderr messages (usually errors)
 					log.Warn().Str("line", line).Msg("sign script stderr")
 				}
 			}()
-			
+
 			<-stdoutDone
 			<-stderrDone
 		}()
 
 		err = cmd.Wait()
 		<-outputDone
+		exitStatus = cmd.ProcessState.ExitCode()
+		if ctx.Err() != nil {
+			if err := removeKeychain(keychainName); err != nil {
+				log.Warn().Err(err).Str("job_id", returnJobId).Msg("remove keychain of killed sign script")
+			}
+		}
 
 		if err != nil {
-			output := outputBuffer.String()
+			output := jobLog.Output()
 			log.Error().Err(err).Str("output", output).Msg("sign script failed")
+			if ctx.Err() == context.DeadlineExceeded {
+				failReason = fmt.Sprintf("sign script timed out after %s", integrated.GetJobTimeout())
+			} else {
+				failReason = fmt.Sprintf("sign script: %s\n%s", err.Error(), lastLines(output, failReasonLines))
+			}
 			return errors.WithMessage(errors.WithMessage(errors.New(output), err.Error()), "sign script")
 		}
 
@@ -328,22 +379,76 @@ func ProcessIntegratedJob(integrated *Integrated, jobStorage JobStorage, appStor
 		}
 
 		// Clean up return job
-		if !jobStorage.DeleteById(returnJobId) {
+		if !jobStorage.CompleteById(returnJobId) {
 			log.Warn().Str("job_id", returnJobId).Msg("unable to delete return job")
 		}
 
 		return nil
 	}()
 
+	cancelled := err != nil && errors.Is(context.Cause(ctx), errJobCancelled)
+	interrupted := err != nil && errors.Is(context.Cause(ctx), errServerShutdown)
+	// a job that ran out of time would most likely run out of time again, and its output may match the timeout pattern
+	timedOut := err != nil && ctx.Err() == context.DeadlineExceeded
+	var retryDelay time.Duration
+	retry := false
+	if err != nil && !cancelled && !interrupted && !timedOut {
+		// the error ends with the sign script output, if there is any
+		retryDelay, retry = integrated.getRetryRules().nextDelay(returnJob.GetAttempt(), exitStatus, lastLines(err.Error(), retryOutputLines))
+	}
+	if cancelled {
+		jobLog.Event("job cancelled")
+	} else if interrupted {
+		jobLog.Event("job interrupted by server shutdown")
+	} else if retry {
+		jobLog.Event("job failed, retrying in %s: %s", retryDelay, err.Error())
+	} else if err != nil {
+		jobLog.Event("job failed: %s", err.Error())
+	} else {
+		jobLog.Event("job completed")
+	}
+	if app, ok := appStorage.Get(appId); ok {
+		if err := app.SetJobLog(returnJobId, jobLog.Reader()); err != nil {
+			log.Warn().Err(err).Str("job_id", returnJobId).Msg("save job log")
+		}
+	}
+
+	if cancelled {
+		log.Info().Str("job_id", id).Msg("integrated sign job cancelled")
+		if !jobStorage.CancelById(returnJobId) {
+			log.Warn().Str("job_id", returnJobId).Msg("unable to delete cancelled return job")
+		}
+		return true, nil
+	}
+
+	if interrupted {
+		log.Info().Str("job_id", id).Msg("integrated sign job interrupted by server shutdown")
+		if jobStorage.RequeueById(returnJobId, "interrupted by server shutdown") {
+			return true, nil
+		}
+		if !jobStorage.FailById(returnJobId, -1, "interrupted by server shutdown") {
+			log.Warn().Str("job_id", returnJobId).Msg("unable to delete interrupted return job")
+		}
+		return true, nil
+	}
+
 	if err != nil {
 		log.Error().Err(err).Str("job_id", id).Msg("integrated sign job failed")
 		// Mark job as failed
-		if !jobStorage.DeleteById(returnJobId) {
+		if failReason == "" {
+			failReason = err.Error()
+		}
+		if retry && jobStorage.RetryById(returnJobId, exitStatus, failReason, retryDelay) {
+			log.Info().Str("job_id", returnJobId).Int("attempt", returnJob.GetAttempt()).Dur("delay", retryDelay).Msg("retrying integrated sign job")
+			integrated.triggerAfter(retryDelay)
+			return true, err
+		}
+		if !jobStorage.FailById(returnJobId, exitStatus, failReason) {
 			log.Warn().Str("job_id", returnJobId).Msg("unable to delete failed return job")
 		}
-		return err
+		return true, err
 	}
 
 	log.Info().Str("job_id", id).Msg("integrated sign job completed")
-	return nil
+	return true, nil
 }
@@ -0,0 +1,68 @@
+package builders
+
+import (
+	"LocalSignTools/src/events"
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const jobLogTimeFormat = "2006-01-02 15:04:05.000"
+
+// jobLog collects the complete, timestamped output of a sign job and publishes it live.
+// It is safe for concurrent use, as stdout and stderr are read in parallel.
+type jobLog struct {
+	mu     sync.Mutex
+	appId  string
+	jobId  string
+	log    bytes.Buffer
+	output bytes.Buffer
+	mirror io.Writer
+}
+
+func newJobLog(appId string, jobId string, mirror io.Writer) *jobLog {
+	return &jobLog{appId: appId, jobId: jobId, mirror: mirror}
+}
+
+// Line records a line of sign script output from the given stream.
+func (l *jobLog) Line(stream string, line string) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(&l.log, "%s [%s] %s\n", now.Format(jobLogTimeFormat), stream, line)
+	l.output.WriteString(line + "\n")
+	if l.mirror != nil {
+		l.mirror.Write([]byte(line + "\n"))
+	}
+	events.Publish(events.Event{
+		Type:   events.JobOutput,
+		Ts:     now,
+		AppId:  l.appId,
+		JobId:  l.jobId,
+		Stream: stream,
+		Line:   line,
+	})
+}
+
+// Event records a message from the builder itself, such as the job starting or failing.
+func (l *jobLog) Event(format string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(&l.log, "%s [builder] %s\n", time.Now().Format(jobLogTimeFormat), fmt.Sprintf(format, args...))
+}
+
+// Output returns the raw sign script output, without timestamps.
+func (l *jobLog) Output() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.output.String()
+}
+
+// Reader returns the full timestamped log.
+func (l *jobLog) Reader() io.ReadSeeker {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return bytes.NewReader(bytes.Clone(l.log.Bytes()))
+}
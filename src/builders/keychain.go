@@ -0,0 +1,55 @@
+package builders
+
+import (
+	"github.com/pkg/errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Must match the lock file of sign.py, which changes the keychain search list as well.
+var keychainListLockPath = filepath.Join(os.TempDir(), "ios-signer-keychains.lock")
+
+// Returns the name of the temporary keychain that the sign script creates for a job.
+func jobKeychainName(jobId string) string {
+	return "ios-signer-" + jobId
+}
+
+// Deletes a keychain left behind by a sign script that was killed before it could clean up after itself.
+func removeKeychain(name string) error {
+	if _, err := exec.LookPath("security"); err != nil {
+		return nil // not on macOS
+	}
+	unlock, err := lockKeychainList()
+	if err != nil {
+		return errors.WithMessage(err, "lock keychain list")
+	}
+	defer unlock()
+
+	output, err := exec.Command("security", "list-keychains", "-d", "user").Output()
+	if err != nil {
+		return errors.WithMessage(err, "list keychains")
+	}
+	var keychains []string
+	found := false
+	for _, keychain := range strings.Fields(string(output)) {
+		keychain = strings.Trim(keychain, `"`)
+		if strings.Contains(keychain, name) {
+			found = true
+			continue
+		}
+		keychains = append(keychains, keychain)
+	}
+	if found {
+		args := append([]string{"list-keychains", "-d", "user", "-s"}, keychains...)
+		if err := exec.Command("security", args...).Run(); err != nil {
+			return errors.WithMessage(err, "set keychain list")
+		}
+	}
+	// the keychain may have been created without making it into the search list yet
+	if err := exec.Command("security", "delete-keychain", name).Run(); err != nil && found {
+		return errors.WithMessagef(err, "delete keychain %s", name)
+	}
+	return nil
+}
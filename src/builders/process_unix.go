@@ -0,0 +1,34 @@
+//go:build !windows
+
+package builders
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// Runs the command in its own process group and kills the whole group on cancellation,
+// so that fastlane, codesign and the other tools started by the sign script don't outlive it.
+func configureProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
+
+// Takes the lock that serializes changes to the keychain search list, and returns a function to release it.
+func lockKeychainList() (func(), error) {
+	file, err := os.OpenFile(keychainListLockPath, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
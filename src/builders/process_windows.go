@@ -0,0 +1,15 @@
+//go:build windows
+
+package builders
+
+import (
+	"os/exec"
+)
+
+// Windows has no process groups to kill, so only the sign script itself is stopped on cancellation.
+func configureProcessGroup(cmd *exec.Cmd) {}
+
+// Keychains only exist on macOS, so there's nothing to lock.
+func lockKeychainList() (func(), error) {
+	return func() {}, nil
+}
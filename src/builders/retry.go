@@ -0,0 +1,86 @@
+package builders
+
+import (
+	"github.com/rs/zerolog/log"
+	"regexp"
+	"time"
+)
+
+// RetryPolicy decides which failed jobs are attempted again, and when.
+// A failure is retryable if the sign script exited with one of ExitCodes,
+// or if the end of its output or the error matches one of OutputPatterns.
+type RetryPolicy struct {
+	// The total number of attempts of a job, including the first one. 1 disables retries.
+	MaxAttempts uint64 `yaml:"max_attempts"`
+	// The delay before the first retry, doubled for every retry after that.
+	BackoffSecs    uint64   `yaml:"backoff_secs"`
+	MaxBackoffSecs uint64   `yaml:"max_backoff_secs"`
+	ExitCodes      []int    `yaml:"exit_codes"`
+	OutputPatterns []string `yaml:"output_patterns"`
+}
+
+// Failures of the Apple portal and the network which usually go away on their own.
+// Only network timeouts are matched, not timeouts of commands or of waiting for a 2FA code, which a retry won't fix.
+var DefaultRetryOutputPatterns = []string{
+	`(?i)(connection|connect|request|operation|read|handshake) timed out`,
+	`(?i)(i/o|tls handshake) timeout`,
+	`(?i)\b(connect|read|open)timeout\b`,
+	`(?i)connection (reset|refused|lost)`,
+	`(?i)network (connection|is unreachable)`,
+	`(?i)could not resolve host`,
+	`(?i)(internal server error|bad gateway|service unavailable|gateway timeout)`,
+	`(?i)try again later`,
+}
+
+// How many lines at the end of the sign script output are matched against the retry output patterns.
+// Failures are reported at the end, and older lines are more likely to match by accident.
+const retryOutputLines = 20
+
+type retryRules struct {
+	policy   RetryPolicy
+	patterns []*regexp.Regexp
+}
+
+func makeRetryRules(policy RetryPolicy) *retryRules {
+	rules := &retryRules{policy: policy}
+	for _, pattern := range policy.OutputPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			log.Warn().Err(err).Str("pattern", pattern).Msg("ignoring invalid retry output pattern")
+			continue
+		}
+		rules.patterns = append(rules.patterns, re)
+	}
+	return rules
+}
+
+// Returns how long to wait before attempting a job again after the given attempt failed,
+// or false if the job shouldn't be attempted again.
+func (r *retryRules) nextDelay(attempt int, exitStatus int, output string) (time.Duration, bool) {
+	if uint64(attempt) >= r.policy.MaxAttempts || !r.isRetryable(exitStatus, output) {
+		return 0, false
+	}
+	delay := time.Duration(r.policy.BackoffSecs) * time.Second
+	maxDelay := time.Duration(r.policy.MaxBackoffSecs) * time.Second
+	for i := 1; i < attempt && (maxDelay == 0 || delay < maxDelay); i++ {
+		delay *= 2
+	}
+	if maxDelay > 0 && delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay, true
+}
+
+func (r *retryRules) isRetryable(exitStatus int, output string) bool {
+	for _, code := range r.policy.ExitCodes {
+		if code == exitStatus {
+			return true
+		}
+	}
+	for _, re := range r.patterns {
+		if re.MatchString(output) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package builders
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  RetryPolicy
+		attempt int
+		want    time.Duration
+		wantOk  bool
+	}{
+		{"first retry", RetryPolicy{MaxAttempts: 5, BackoffSecs: 60}, 1, time.Minute, true},
+		{"doubled", RetryPolicy{MaxAttempts: 5, BackoffSecs: 60}, 2, 2 * time.Minute, true},
+		{"doubled twice", RetryPolicy{MaxAttempts: 5, BackoffSecs: 60}, 3, 4 * time.Minute, true},
+		{"capped", RetryPolicy{MaxAttempts: 5, BackoffSecs: 60, MaxBackoffSecs: 150}, 3, 150 * time.Second, true},
+		{"cap above the first delay", RetryPolicy{MaxAttempts: 5, BackoffSecs: 60, MaxBackoffSecs: 150}, 1, time.Minute, true},
+		{"cap below the first delay", RetryPolicy{MaxAttempts: 5, BackoffSecs: 60, MaxBackoffSecs: 30}, 1, 30 * time.Second, true},
+		{"capped after many attempts", RetryPolicy{MaxAttempts: 1000, BackoffSecs: 60, MaxBackoffSecs: 900}, 999, 15 * time.Minute, true},
+		{"no delay", RetryPolicy{MaxAttempts: 5}, 3, 0, true},
+		{"last attempt", RetryPolicy{MaxAttempts: 3, BackoffSecs: 60}, 3, 0, false},
+		{"past the last attempt", RetryPolicy{MaxAttempts: 3, BackoffSecs: 60}, 4, 0, false},
+		{"retries disabled", RetryPolicy{MaxAttempts: 1, BackoffSecs: 60}, 1, 0, false},
+		{"no attempts set", RetryPolicy{BackoffSecs: 60}, 1, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.policy.ExitCodes = []int{75}
+			got, ok := makeRetryRules(tt.policy).nextDelay(tt.attempt, 75, "")
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("nextDelay() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestNextDelayNotRetryable(t *testing.T) {
+	rules := makeRetryRules(RetryPolicy{MaxAttempts: 3, BackoffSecs: 60, ExitCodes: []int{75}})
+	if got, ok := rules.nextDelay(1, 1, "invalid certificate"); ok {
+		t.Errorf("nextDelay() = %v, true, want false", got)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name       string
+		exitStatus int
+		output     string
+		want       bool
+	}{
+		{"listed exit code", 75, "", true},
+		{"other exit code", 1, "", false},
+		{"no output", 1, "", false},
+		{"connection reset", 1, "error: Connection reset by peer", true},
+		{"could not resolve host", 1, "curl: (6) Could not resolve host: developer.apple.com", true},
+		{"server error", 1, "Apple returned 503 Service Unavailable", true},
+		{"try again later", 1, "The service is temporarily unavailable, try again later.", true},
+		{"read timed out", 1, "urllib3.exceptions.ReadTimeoutError: Read timed out. (read timeout=30)", true},
+		{"operation timed out", 1, "curl: (28) Operation timed out after 30001 milliseconds", true},
+		{"request timed out", 1, "Error Domain=NSURLErrorDomain Code=-1001 \"The request timed out.\"", true},
+		{"i/o timeout", 1, "dial tcp 17.253.144.10:443: i/o timeout", true},
+		{"tls handshake timeout", 1, "net/http: TLS handshake timeout", true},
+		{"ruby open timeout", 1, "Net::OpenTimeout: execution expired", true},
+		{"python connect timeout", 1, "requests.exceptions.ConnectTimeout: HTTPSConnectionPool", true},
+		{"command timed out", 1, "Command '['security', 'unlock-keychain']' timed out after 30 seconds", false},
+		{"2fa timed out", 1, "Timed out waiting for the 2FA code", false},
+		{"user input timeout", 1, "Timeout waiting for user input", false},
+		{"invalid credentials", 1, "Invalid username and password combination", false},
+	}
+	rules := makeRetryRules(RetryPolicy{ExitCodes: []int{75}, OutputPatterns: DefaultRetryOutputPatterns})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rules.isRetryable(tt.exitStatus, tt.output); got != tt.want {
+				t.Errorf("isRetryable(%d, %q) = %v, want %v", tt.exitStatus, tt.output, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMakeRetryRulesInvalidPattern(t *testing.T) {
+	rules := makeRetryRules(RetryPolicy{OutputPatterns: []string{"(unclosed", "(?i)busy"}})
+	if len(rules.patterns) != 1 {
+		t.Fatalf("makeRetryRules() has %d patterns, want the valid one only", len(rules.patterns))
+	}
+	if !rules.isRetryable(1, "server BUSY") {
+		t.Error("isRetryable() = false for the valid pattern, want true")
+	}
+}
@@ -1,6 +1,7 @@
 package builders
 
 import (
+	"context"
 	"net/http"
 )
 
@@ -12,6 +13,11 @@ type Builder interface {
 	Trigger() error
 	SetSecrets(map[string]string) error
 	GetStatusUrl() (string, error)
+	// Cancel stops the job with the given ID, returning false if it isn't running on this builder.
+	Cancel(jobId string) bool
+	// Shutdown stops taking new jobs and waits for the running ones to finish.
+	// Jobs still running once ctx is done are stopped and queued again.
+	Shutdown(ctx context.Context)
 }
 
 // static check to ensure all methods are implemented
@@ -15,6 +15,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 )
 
 type BasicAuth struct {
@@ -23,6 +24,50 @@ type BasicAuth struct {
 	Password string `yaml:"password"`
 }
 
+// Metrics configures the Prometheus metrics endpoint, which has its own credentials
+// so that a scraper doesn't need access to the web interface.
+type Metrics struct {
+	Enable    bool      `yaml:"enable"`
+	BasicAuth BasicAuth `yaml:"basic_auth"`
+	// If set, requests may authenticate with an "Authorization: Bearer <token>" header instead.
+	BearerToken string `yaml:"bearer_token"`
+}
+
+// Health configures the readiness checks of /readyz.
+type Health struct {
+	// The server isn't ready if the save dir has less free disk space than this.
+	MinFreeDiskMb uint64 `yaml:"min_free_disk_mb"`
+}
+
+// Expiry configures the warnings about expiring certificates and provisioning profiles.
+type Expiry struct {
+	// Warn when a profile expires within any of these numbers of days, once per threshold.
+	WarnDays          []int  `yaml:"warn_days"`
+	CheckIntervalMins uint64 `yaml:"check_interval_mins"`
+}
+
+// Resign configures the automatic re-signing of apps, which is turned on per app or per profile.
+type Resign struct {
+	// Re-sign an app this many days before its signature expires.
+	DaysBeforeExpiry uint64 `yaml:"days_before_expiry"`
+}
+
+// Upload configures the checks of uploaded apps.
+type Upload struct {
+	// Reject apps that are still encrypted by the App Store, which are signed fine but crash on launch.
+	// If false, they are only warned about.
+	BlockEncrypted bool `yaml:"block_encrypted"`
+}
+
+// Webhook is a URL that receives a JSON POST request for every app, job and profile event.
+type Webhook struct {
+	Url string `yaml:"url"`
+	// Used to sign the requests with HMAC-SHA256, so the receiver can verify them. Optional.
+	Secret string `yaml:"secret"`
+	// The event types to send, or all of them if empty.
+	Events []string `yaml:"events"`
+}
+
 // Builder contains configuration for all available builders.
 // For LocalSignTools, only the integrated builder is supported.
 type Builder struct {
@@ -44,7 +89,15 @@ type File struct {
 	SaveDir             string    `yaml:"save_dir"`
 	CleanupIntervalMins uint64    `yaml:"cleanup_interval_mins"`
 	SignTimeoutMins     uint64    `yaml:"sign_timeout_mins"`
+	ShutdownGraceSecs   uint64    `yaml:"shutdown_grace_secs"`
 	BasicAuth           BasicAuth `yaml:"basic_auth"`
+	Webhooks            []Webhook `yaml:"webhooks"`
+	Metrics             Metrics   `yaml:"metrics"`
+	Health              Health    `yaml:"health"`
+	WatchProfiles       bool      `yaml:"watch_profiles"`
+	Expiry              Expiry    `yaml:"expiry"`
+	Resign              Resign    `yaml:"resign"`
+	Upload              Upload    `yaml:"upload"`
 	BuilderKey          string    `yaml:"builder_key,omitempty"`
 }
 
@@ -52,10 +105,18 @@ func createDefaultFile() *File {
 	return &File{
 		Builder: Builder{
 			Integrated: builders.IntegratedData{
-				Enable:        true,
-				SignFilesDir:  "./builder",
-				Entrypoint:    "sign.py",
-				JobTimeoutMin: 15,
+				Enable:            true,
+				SignFilesDir:      "./builder",
+				Entrypoint:        "sign.py",
+				JobTimeoutMin:     15,
+				MaxConcurrentJobs: 1,
+				Retry: builders.RetryPolicy{
+					MaxAttempts:    3,
+					BackoffSecs:    60,
+					MaxBackoffSecs: 900,
+					ExitCodes:      []int{},
+					OutputPatterns: builders.DefaultRetryOutputPatterns,
+				},
 			},
 		},
 		ServerUrl:           "http://localhost:8080",
@@ -63,11 +124,35 @@ func createDefaultFile() *File {
 		SaveDir:             "data",
 		SignTimeoutMins:     60,
 		CleanupIntervalMins: 5,
+		ShutdownGraceSecs:   120,
 		BasicAuth: BasicAuth{
 			Enable:   false,
 			Username: "admin",
 			Password: "admin",
 		},
+		Webhooks: []Webhook{},
+		Metrics: Metrics{
+			Enable: false,
+			BasicAuth: BasicAuth{
+				Enable:   false,
+				Username: "prometheus",
+				Password: "prometheus",
+			},
+		},
+		Health: Health{
+			MinFreeDiskMb: 500,
+		},
+		WatchProfiles: false,
+		Expiry: Expiry{
+			WarnDays:          []int{30, 7, 1},
+			CheckIntervalMins: 60,
+		},
+		Resign: Resign{
+			DaysBeforeExpiry: 2,
+		},
+		Upload: Upload{
+			BlockEncrypted: true,
+		},
 	}
 }
 
@@ -87,12 +172,26 @@ type ProfileBox struct {
 type Config struct {
 	Builder    map[string]builders.Builder
 	BuilderKey string
-	*File
 	EnvProfile *EnvProfile
+	// Replaced as a whole by Reload, while requests and background loops read it.
+	file atomic.Pointer[File]
+}
+
+// File returns the current config file. It may be replaced by a reload at any time,
+// so read it once when several of its settings must agree.
+func (c *Config) File() *File {
+	return c.file.Load()
 }
 
 var Current Config
 
+var (
+	// The config file read by Load, for Reload.
+	loadedFileName string
+	// Set when the server url comes from a tunnel, which takes precedence over the config file.
+	serverUrlOverride string
+)
+
 func Load(fileName string) {
 	allowedExts := []string{".yml", ".yaml"}
 	if !isAllowedExt(allowedExts, fileName) {
@@ -134,12 +233,67 @@ func Load(fileName string) {
 	if err != nil {
 		log.Fatal().Err(err).Msg("init: error checking for signing profile from envvars")
 	}
-	Current = Config{
-		Builder:    builderMap,
-		BuilderKey: builderKey,
-		File:       fileConfig,
-		EnvProfile: profile,
+	Current.Builder = builderMap
+	Current.BuilderKey = builderKey
+	Current.EnvProfile = profile
+	Current.file.Store(fileConfig)
+	loadedFileName = fileName
+}
+
+// OverrideServerUrl replaces the server url of the config file, also after a reload.
+func OverrideServerUrl(serverUrl string) {
+	serverUrlOverride = serverUrl
+	fileConfig := *Current.File()
+	fileConfig.ServerUrl = serverUrl
+	Current.file.Store(&fileConfig)
+}
+
+// Reload reads the config file again and applies it to Current.
+// The builders are reconfigured in place, so that queued and running jobs are unaffected.
+// Settings that only take effect on start keep their old value, and are returned as warnings if they changed.
+func Reload() ([]string, error) {
+	if _, err := os.Stat(loadedFileName); err != nil {
+		return nil, errors.WithMessage(err, "stat config file")
+	}
+	fileConfig, err := getFile('.', loadedFileName)
+	if err != nil {
+		return nil, errors.WithMessage(err, "get config")
+	}
+	oldConfig := Current.File()
+	var warnings []string
+	keep := func(name string, changed bool, restore func()) {
+		if changed {
+			warnings = append(warnings, name+" can only be changed with a restart")
+			restore()
+		}
+	}
+	keep("save_dir", fileConfig.SaveDir != oldConfig.SaveDir, func() {
+		fileConfig.SaveDir = oldConfig.SaveDir
+	})
+	keep("redirect_https", fileConfig.RedirectHttps != oldConfig.RedirectHttps, func() {
+		fileConfig.RedirectHttps = oldConfig.RedirectHttps
+	})
+	keep("watch_profiles", fileConfig.WatchProfiles != oldConfig.WatchProfiles, func() {
+		fileConfig.WatchProfiles = oldConfig.WatchProfiles
+	})
+	keep("metrics.enable", fileConfig.Metrics.Enable != oldConfig.Metrics.Enable, func() {
+		fileConfig.Metrics.Enable = oldConfig.Metrics.Enable
+	})
+	keep("builder.integrated.enable", fileConfig.Builder.Integrated.Enable != oldConfig.Builder.Integrated.Enable, func() {
+		fileConfig.Builder.Integrated.Enable = oldConfig.Builder.Integrated.Enable
+	})
+	// running sign scripts authenticate with the old key
+	fileConfig.BuilderKey = Current.BuilderKey
+	if serverUrlOverride != "" {
+		fileConfig.ServerUrl = serverUrlOverride
+	}
+	for _, builder := range Current.Builder {
+		if integrated, ok := builder.(*builders.Integrated); ok {
+			integrated.Reconfigure(&fileConfig.Builder.Integrated)
+		}
 	}
+	Current.file.Store(fileConfig)
+	return warnings, nil
 }
 
 // Loads a single signing profile entirely from environment variables.
@@ -207,7 +361,7 @@ func getFile(mapDelim rune, fileName string) (*File, error) {
 func SetBuilderSecrets(builder builders.Builder) error {
 	secrets := map[string]string{
 		"SECRET_KEY": Current.BuilderKey,
-		"SECRET_URL": Current.ServerUrl,
+		"SECRET_URL": Current.File().ServerUrl,
 	}
 	return errors.WithMessage(builder.SetSecrets(secrets), "set builder secrets")
 }
@@ -0,0 +1,92 @@
+package events
+
+import (
+	"github.com/rs/zerolog/log"
+	"sync"
+	"time"
+)
+
+type Type string
+
+const (
+	AppUploaded  Type = "app_uploaded"
+	JobQueued    Type = "job_queued"
+	JobStarted   Type = "job_started"
+	JobSucceeded Type = "job_succeeded"
+	JobFailed    Type = "job_failed"
+	JobCancelled Type = "job_cancelled"
+	// A job failed, but will be attempted again.
+	JobRetrying Type = "job_retrying"
+	// The sign script has been waiting for a 2FA code for a while.
+	TwoFactorRequired Type = "2fa_required"
+	JobOutput         Type = "job_output"
+	// The certificate or provisioning profile of a profile expires within one of the warning thresholds.
+	ProfileExpiring Type = "profile_expiring"
+	ProfileExpired  Type = "profile_expired"
+)
+
+// IsJobState reports whether the event is a change of state of an app, its job or a profile, as opposed to job output.
+func (t Type) IsJobState() bool {
+	return t != JobOutput
+}
+
+type Event struct {
+	Type   Type      `json:"type"`
+	Ts     time.Time `json:"ts"`
+	AppId  string    `json:"app_id"`
+	JobId  string    `json:"job_id,omitempty"`
+	Stream string    `json:"stream,omitempty"`
+	Line   string    `json:"line,omitempty"`
+	Error  string    `json:"error,omitempty"`
+	// Set for profile events, which have no app.
+	ProfileId string `json:"profile_id,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+// Events are dropped for subscribers that fall this far behind, so that a slow client can't block signing.
+const subscriberBuffer = 256
+
+type subscriber struct {
+	ch     chan Event
+	filter func(Event) bool
+}
+
+type broker struct {
+	mu          sync.Mutex
+	subscribers map[*subscriber]bool
+}
+
+var bus = &broker{subscribers: map[*subscriber]bool{}}
+
+// Publish sends an event to all interested subscribers without blocking.
+func Publish(event Event) {
+	if event.Ts.IsZero() {
+		event.Ts = time.Now()
+	}
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	for sub := range bus.subscribers {
+		if sub.filter != nil && !sub.filter(event) {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+			log.Debug().Str("type", string(event.Type)).Msg("dropping event for slow subscriber")
+		}
+	}
+}
+
+// Subscribe returns a channel receiving all future events accepted by filter, or all events if filter is nil.
+// The returned function must be called to unsubscribe once the channel is no longer read.
+func Subscribe(filter func(Event) bool) (<-chan Event, func()) {
+	sub := &subscriber{ch: make(chan Event, subscriberBuffer), filter: filter}
+	bus.mu.Lock()
+	bus.subscribers[sub] = true
+	bus.mu.Unlock()
+	return sub.ch, func() {
+		bus.mu.Lock()
+		delete(bus.subscribers, sub)
+		bus.mu.Unlock()
+	}
+}
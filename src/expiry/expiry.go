@@ -0,0 +1,168 @@
+// Package expiry tracks when the certificates and provisioning profiles of the signing profiles expire,
+// since apps signed with them stop launching once they do.
+package expiry
+
+import (
+	"LocalSignTools/src/config"
+	"LocalSignTools/src/events"
+	"LocalSignTools/src/storage"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"sort"
+	"time"
+)
+
+const (
+	SourceCertificate  = "certificate"
+	SourceProvisioning = "provisioning_profile"
+)
+
+type Status struct {
+	ProfileId   string `json:"profile_id"`
+	ProfileName string `json:"profile_name"`
+	// What expires first, the certificate or the provisioning profile.
+	Source    string    `json:"source"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Expired   bool      `json:"expired"`
+	// The smallest warning threshold in days that the profile expires within, or 0 if none.
+	ThresholdDays int `json:"threshold_days,omitempty"`
+}
+
+// IsSoon reports whether the profile has expired or expires within a warning threshold.
+func (s *Status) IsSoon() bool {
+	return s.Expired || s.ThresholdDays > 0
+}
+
+// DaysLeft returns the number of whole days until the profile expires.
+func (s *Status) DaysLeft(now time.Time) int {
+	return int(s.ExpiresAt.Sub(now) / (24 * time.Hour))
+}
+
+// Message describes the expiry for people, such as "The certificate of Personal expires in 5 days".
+func (s *Status) Message(now time.Time) string {
+	what := "certificate"
+	if s.Source == SourceProvisioning {
+		what = "provisioning profile"
+	}
+	date := s.ExpiresAt.Format(time.RFC822)
+	if s.Expired {
+		return fmt.Sprintf("The %s of %s expired at %s", what, s.ProfileName, date)
+	}
+	days := s.DaysLeft(now)
+	if days < 1 {
+		return fmt.Sprintf("The %s of %s expires today, at %s", what, s.ProfileName, date)
+	}
+	return fmt.Sprintf("The %s of %s expires in %d days, at %s", what, s.ProfileName, days, date)
+}
+
+// Get returns when the certificates or the provisioning profile of a profile expire, whichever is first.
+func Get(profile storage.Profile, now time.Time) (Status, bool) {
+	status := Status{ProfileId: profile.GetId()}
+	status.ProfileName, _ = profile.GetString(storage.ProfileName)
+	// all certificates must be valid, since the sign script may pick any of them
+	for _, cert := range profile.GetCertificates() {
+		if status.ExpiresAt.IsZero() || cert.NotAfter.Before(status.ExpiresAt) {
+			status.ExpiresAt = cert.NotAfter
+			status.Source = SourceCertificate
+		}
+	}
+	if prov := profile.GetProvisioningProfile(); prov != nil && !prov.ExpirationDate.IsZero() {
+		if status.ExpiresAt.IsZero() || prov.ExpirationDate.Before(status.ExpiresAt) {
+			status.ExpiresAt = prov.ExpirationDate
+			status.Source = SourceProvisioning
+		}
+	}
+	if status.ExpiresAt.IsZero() {
+		return status, false
+	}
+	status.Expired = !now.Before(status.ExpiresAt)
+	if !status.Expired {
+		for _, days := range config.Current.File().Expiry.WarnDays {
+			if days <= 0 || status.ExpiresAt.Sub(now) > time.Duration(days)*24*time.Hour {
+				continue
+			}
+			if status.ThresholdDays == 0 || days < status.ThresholdDays {
+				status.ThresholdDays = days
+			}
+		}
+	}
+	return status, true
+}
+
+// GetSoon returns the profiles that have expired or expire within a warning threshold, soonest first.
+func GetSoon(now time.Time) ([]Status, error) {
+	profiles, err := storage.Profiles.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	var results []Status
+	for _, profile := range profiles {
+		if status, ok := Get(profile, now); ok && status.IsSoon() {
+			results = append(results, status)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].ExpiresAt.Before(results[j].ExpiresAt)
+	})
+	return results, nil
+}
+
+// What was last announced about a profile, so that every threshold is only announced once.
+type notice struct {
+	expiresAt     time.Time
+	thresholdDays int
+	expired       bool
+}
+
+// Start checks the profiles every check interval until stop is closed, publishing an event the first time
+// a profile crosses a warning threshold or expires. Renewing a profile resets its warnings.
+func Start(stop <-chan bool) {
+	notices := map[string]notice{}
+	go func() {
+		for {
+			check(notices, time.Now())
+			interval := time.Duration(config.Current.File().Expiry.CheckIntervalMins) * time.Minute
+			if interval <= 0 {
+				interval = time.Hour
+			}
+			select {
+			case <-time.After(interval):
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func check(notices map[string]notice, now time.Time) {
+	statuses, err := GetSoon(now)
+	if err != nil {
+		log.Err(err).Msg("check profile expiry")
+		return
+	}
+	soon := map[string]bool{}
+	for _, status := range statuses {
+		soon[status.ProfileId] = true
+		last, announced := notices[status.ProfileId]
+		if announced && !last.expiresAt.Equal(status.ExpiresAt) {
+			announced = false
+		}
+		message := status.Message(now)
+		switch {
+		case status.Expired && !(announced && last.expired):
+			log.Error().Str("profile", status.ProfileId).Msg(message)
+			events.Publish(events.Event{Type: events.ProfileExpired, ProfileId: status.ProfileId, Message: message})
+		case !status.Expired && (!announced || status.ThresholdDays < last.thresholdDays):
+			log.Warn().Str("profile", status.ProfileId).Msg(message)
+			events.Publish(events.Event{Type: events.ProfileExpiring, ProfileId: status.ProfileId, Message: message})
+		default:
+			continue
+		}
+		notices[status.ProfileId] = notice{expiresAt: status.ExpiresAt, thresholdDays: status.ThresholdDays, expired: status.Expired}
+	}
+	for profileId := range notices {
+		if !soon[profileId] {
+			delete(notices, profileId)
+		}
+	}
+}
@@ -0,0 +1,7 @@
+package health
+
+import "github.com/pkg/errors"
+
+func freeDiskSpace(path string) (uint64, error) {
+	return 0, errors.New("not supported on windows")
+}
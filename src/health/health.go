@@ -0,0 +1,219 @@
+// Package health checks whether the server is able to sign apps.
+package health
+
+import (
+	"LocalSignTools/src/builders"
+	"LocalSignTools/src/config"
+	"LocalSignTools/src/expiry"
+	"LocalSignTools/src/storage"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+type Status string
+
+const (
+	StatusOk Status = "ok"
+	// Signing works, but something needs attention soon.
+	StatusWarn Status = "warn"
+	// Signing doesn't work.
+	StatusFail Status = "fail"
+)
+
+var statusSeverity = map[Status]int{StatusOk: 0, StatusWarn: 1, StatusFail: 2}
+
+type Check struct {
+	Name    string `json:"name"`
+	Status  Status `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+type Report struct {
+	// The worst status of all checks.
+	Status    Status    `json:"status"`
+	CheckedAt time.Time `json:"checked_at"`
+	Checks    []Check   `json:"checks"`
+}
+
+func makeReport(checks []Check) *Report {
+	report := &Report{Status: StatusOk, CheckedAt: time.Now(), Checks: checks}
+	for _, check := range checks {
+		if statusSeverity[check.Status] > statusSeverity[report.Status] {
+			report.Status = check.Status
+		}
+	}
+	return report
+}
+
+// Readiness runs all checks. The server is ready to sign if none of them failed.
+func Readiness() *Report {
+	var checks []Check
+	checks = append(checks, CheckDependencies()...)
+	checks = append(checks, checkSaveDir()...)
+	checks = append(checks, checkProfiles()...)
+	checks = append(checks, checkBuilders()...)
+	return makeReport(checks)
+}
+
+type dependency struct {
+	command string
+	// Missing optional dependencies are only a warning.
+	optional bool
+	hint     string
+}
+
+var dependencies = []dependency{
+	{command: "fastlane", hint: "Install with: brew install fastlane or gem install fastlane"},
+	{command: "python3", hint: "Python 3 is typically included with macOS. Please install manually if needed."},
+	{command: "node", hint: "Install with: brew install node"},
+	{command: "npm", optional: true, hint: "npm usually comes with Node.js. Please ensure Node.js is properly installed."},
+}
+
+// CheckDependencies checks for the external programs used by the sign script.
+func CheckDependencies() []Check {
+	var checks []Check
+	for _, dep := range dependencies {
+		check := Check{Name: "command:" + dep.command, Status: StatusOk}
+		if path, err := exec.LookPath(dep.command); err != nil {
+			check.Status = StatusFail
+			if dep.optional {
+				check.Status = StatusWarn
+			}
+			check.Message = fmt.Sprintf("%s not found. %s", dep.command, dep.hint)
+		} else {
+			check.Message = path
+		}
+		checks = append(checks, check)
+	}
+	// They are installed when signing if missing, so it's only a warning.
+	nodeModulesDir := filepath.Join(getSignFilesDir(), "node-utils", "node_modules")
+	check := Check{Name: "node_modules", Status: StatusOk, Message: nodeModulesDir}
+	if _, err := os.Stat(nodeModulesDir); err != nil {
+		check.Status = StatusWarn
+		check.Message = fmt.Sprintf("npm dependencies not installed. They will be installed automatically when signing, or run: npm install --prefix %s",
+			filepath.Dir(nodeModulesDir))
+	}
+	return append(checks, check)
+}
+
+func getSignFilesDir() string {
+	for _, builder := range config.Current.Builder {
+		if integrated, ok := builder.(*builders.Integrated); ok && integrated.GetSignFilesDir() != "" {
+			return integrated.GetSignFilesDir()
+		}
+	}
+	return "builder"
+}
+
+func checkSaveDir() []Check {
+	saveDir := config.Current.File().SaveDir
+	writable := Check{Name: "save_dir", Status: StatusOk, Message: saveDir}
+	if file, err := os.CreateTemp(saveDir, ".readyz-*"); err != nil {
+		writable.Status = StatusFail
+		writable.Message = fmt.Sprintf("%s is not writable: %v", saveDir, err)
+	} else {
+		file.Close()
+		os.Remove(file.Name())
+	}
+	disk := Check{Name: "disk_space", Status: StatusOk}
+	free, err := freeDiskSpace(saveDir)
+	if err != nil {
+		disk.Status = StatusWarn
+		disk.Message = fmt.Sprintf("unable to get free disk space: %v", err)
+	} else {
+		freeMb := free / 1024 / 1024
+		disk.Message = fmt.Sprintf("%d MB free", freeMb)
+		if minFreeMb := config.Current.File().Health.MinFreeDiskMb; freeMb < minFreeMb {
+			disk.Status = StatusFail
+			disk.Message += fmt.Sprintf(", less than the required %d MB", minFreeMb)
+		}
+	}
+	return []Check{writable, disk}
+}
+
+func checkProfiles() []Check {
+	profiles, err := storage.Profiles.GetAll()
+	if err != nil {
+		return []Check{{Name: "profiles", Status: StatusFail, Message: err.Error()}}
+	}
+	if len(profiles) < 1 {
+		return []Check{{Name: "profiles", Status: StatusFail, Message: "no signing profiles loaded"}}
+	}
+	var checks []Check
+	// other profiles keep working, so an invalid one only needs attention
+	invalidById := map[string]storage.InvalidProfile{}
+	for _, invalid := range storage.Profiles.GetInvalid() {
+		if invalid.HasPrevious {
+			invalidById[invalid.Id] = invalid
+		} else {
+			checks = append(checks, Check{Name: "profile:" + invalid.Id, Status: StatusWarn,
+				Message: fmt.Sprintf("%s is invalid: %v", invalid.Name, invalid.Err)})
+		}
+	}
+	now := time.Now()
+	for _, profile := range profiles {
+		name, _ := profile.GetString(storage.ProfileName)
+		check := Check{Name: "profile:" + profile.GetId(), Status: StatusOk}
+		certificates := profile.GetCertificates()
+		if len(certificates) < 1 {
+			check.Status = StatusFail
+			check.Message = fmt.Sprintf("%s has no signing certificate", name)
+			checks = append(checks, check)
+			continue
+		}
+		// All certificates must be valid, since the sign script may pick any of them.
+		notBefore := certificates[0].NotBefore
+		for _, cert := range certificates[1:] {
+			if cert.NotBefore.After(notBefore) {
+				notBefore = cert.NotBefore
+			}
+		}
+		status, _ := expiry.Get(profile, now)
+		switch {
+		case now.Before(notBefore):
+			check.Status = StatusFail
+			check.Message = fmt.Sprintf("%s certificate is not valid before %s", name, notBefore.Format(time.RFC3339))
+		case status.Expired:
+			check.Status = StatusFail
+			check.Message = status.Message(now)
+		case status.IsSoon():
+			check.Status = StatusWarn
+			check.Message = status.Message(now)
+		default:
+			check.Message = fmt.Sprintf("%s valid until %s", name, status.ExpiresAt.Format(time.RFC3339))
+		}
+		if invalid, ok := invalidById[profile.GetId()]; ok && check.Status == StatusOk {
+			check.Status = StatusWarn
+			check.Message = fmt.Sprintf("%s is invalid: %v, re-signing its apps with the previous version", name, invalid.Err)
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+func checkBuilders() []Check {
+	var checks []Check
+	for builderId, builder := range config.Current.Builder {
+		integrated, ok := builder.(*builders.Integrated)
+		if !ok {
+			continue
+		}
+		check := Check{Name: "builder:" + builderId, Status: StatusOk}
+		if !integrated.IsWorkerStarted() {
+			check.Status = StatusFail
+			check.Message = "worker not started"
+		} else {
+			check.Message = fmt.Sprintf("%d of %d workers busy, %d triggers pending",
+				integrated.GetActiveJobCount(), integrated.GetMaxConcurrentJobs(), integrated.GetPendingTriggerCount())
+		}
+		checks = append(checks, check)
+	}
+	sort.Slice(checks, func(i, j int) bool {
+		return checks[i].Name < checks[j].Name
+	})
+	return checks
+}
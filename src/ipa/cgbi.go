@@ -0,0 +1,215 @@
+package ipa
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"image"
+	"image/png"
+	"io"
+)
+
+// Xcode optimizes the PNGs of apps into Apple's CgBI format, which only Apple's decoder reads:
+// a CgBI chunk comes first, the image data is raw deflate without a zlib header,
+// and the pixels are premultiplied BGRA instead of RGBA.
+// http://iphonedevwiki.net/index.php/CgBI_file_format
+
+const pngHeader = "\x89PNG\r\n\x1a\n"
+
+// Decoded icons are small, pngs wider or taller than this are refused rather than decoded.
+// Checked before multiplying, since the header holds untrusted 32 bit values.
+const maxPNGDimension = 4096
+
+type pngChunk struct {
+	typ  string
+	data []byte
+}
+
+func readPNGChunks(data []byte) ([]pngChunk, error) {
+	if !bytes.HasPrefix(data, []byte(pngHeader)) {
+		return nil, errors.New("not a png")
+	}
+	var chunks []pngChunk
+	rest := data[len(pngHeader):]
+	for len(rest) > 0 {
+		if len(rest) < 12 {
+			return nil, errors.New("truncated png chunk")
+		}
+		length := binary.BigEndian.Uint32(rest)
+		if uint64(length) > uint64(len(rest)-12) {
+			return nil, errors.New("png chunk out of bounds")
+		}
+		chunks = append(chunks, pngChunk{typ: string(rest[4:8]), data: rest[8 : 8+length]})
+		rest = rest[12+length:]
+		if chunks[len(chunks)-1].typ == "IEND" {
+			break
+		}
+	}
+	if len(chunks) < 1 {
+		return nil, errors.New("png has no chunks")
+	}
+	return chunks, nil
+}
+
+// Returns the dimensions of a standard or CgBI png.
+func pngSize(data []byte) (int, int, error) {
+	chunks, err := readPNGChunks(data)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, chunk := range chunks {
+		if chunk.typ == "IHDR" {
+			return readHeaderSize(chunk.data)
+		}
+	}
+	return 0, 0, errors.New("png has no header")
+}
+
+// Returns the dimensions from an IHDR chunk, refusing those above maxPNGDimension.
+func readHeaderSize(header []byte) (int, int, error) {
+	if len(header) < 8 {
+		return 0, 0, errors.New("invalid png header")
+	}
+	width, height := binary.BigEndian.Uint32(header), binary.BigEndian.Uint32(header[4:])
+	if width < 1 || height < 1 || width > maxPNGDimension || height > maxPNGDimension {
+		return 0, 0, errors.Errorf("unsupported size %dx%d", width, height)
+	}
+	return int(width), int(height), nil
+}
+
+// NormalizePNG returns a png that any decoder reads. CgBI pngs are converted, other pngs are returned as they are.
+func NormalizePNG(data []byte) ([]byte, error) {
+	chunks, err := readPNGChunks(data)
+	if err != nil {
+		return nil, err
+	}
+	if chunks[0].typ != "CgBI" {
+		if _, err := png.DecodeConfig(bytes.NewReader(data)); err != nil {
+			return nil, errors.WithMessage(err, "decode png")
+		}
+		return data, nil
+	}
+	img, err := decodeCgBI(chunks)
+	if err != nil {
+		return nil, errors.WithMessage(err, "decode CgBI png")
+	}
+	var result bytes.Buffer
+	if err := png.Encode(&result, img); err != nil {
+		return nil, errors.WithMessage(err, "encode png")
+	}
+	return result.Bytes(), nil
+}
+
+func decodeCgBI(chunks []pngChunk) (*image.NRGBA, error) {
+	var header []byte
+	var compressed bytes.Buffer
+	for _, chunk := range chunks {
+		switch chunk.typ {
+		case "IHDR":
+			header = chunk.data
+		case "IDAT":
+			compressed.Write(chunk.data)
+		}
+	}
+	if len(header) != 13 {
+		return nil, errors.New("invalid header")
+	}
+	width, height, err := readHeaderSize(header)
+	if err != nil {
+		return nil, err
+	}
+	bitDepth, colorType, interlace := header[8], header[9], header[12]
+	// Xcode only writes 8 bit non-interlaced RGBA
+	if bitDepth != 8 || colorType != 6 || interlace != 0 {
+		return nil, errors.Errorf("unsupported format: bit depth %d, color type %d, interlace %d", bitDepth, colorType, interlace)
+	}
+	stride := width * 4
+	raw := make([]byte, (stride+1)*height)
+	if _, err := io.ReadFull(flate.NewReader(&compressed), raw); err != nil {
+		return nil, errors.WithMessage(err, "inflate image data")
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	previous := make([]byte, stride)
+	for y := 0; y < height; y++ {
+		filter := raw[y*(stride+1)]
+		row := raw[y*(stride+1)+1 : (y+1)*(stride+1)]
+		if err := unfilterRow(filter, row, previous, 4); err != nil {
+			return nil, err
+		}
+		pixels := img.Pix[y*img.Stride : y*img.Stride+stride]
+		for x := 0; x < stride; x += 4 {
+			b, g, r, a := row[x], row[x+1], row[x+2], row[x+3]
+			if a != 0 && a != 0xff {
+				r = unpremultiply(r, a)
+				g = unpremultiply(g, a)
+				b = unpremultiply(b, a)
+			}
+			pixels[x], pixels[x+1], pixels[x+2], pixels[x+3] = r, g, b, a
+		}
+		previous = row
+	}
+	return img, nil
+}
+
+func unpremultiply(value byte, alpha byte) byte {
+	result := (int(value)*0xff + int(alpha)/2) / int(alpha)
+	if result > 0xff {
+		return 0xff
+	}
+	return byte(result)
+}
+
+// Reverses the filter of a row in place, given the unfiltered row above it.
+// https://www.w3.org/TR/png/#9Filter-types
+func unfilterRow(filter byte, row []byte, previous []byte, bytesPerPixel int) error {
+	switch filter {
+	case 0:
+	case 1:
+		for i := bytesPerPixel; i < len(row); i++ {
+			row[i] += row[i-bytesPerPixel]
+		}
+	case 2:
+		for i := range row {
+			row[i] += previous[i]
+		}
+	case 3:
+		for i := range row {
+			var left int
+			if i >= bytesPerPixel {
+				left = int(row[i-bytesPerPixel])
+			}
+			row[i] += byte((left + int(previous[i])) / 2)
+		}
+	case 4:
+		for i := range row {
+			var left, upLeft int
+			if i >= bytesPerPixel {
+				left = int(row[i-bytesPerPixel])
+				upLeft = int(previous[i-bytesPerPixel])
+			}
+			row[i] += paeth(left, int(previous[i]), upLeft)
+		}
+	default:
+		return errors.Errorf("invalid filter type %d", filter)
+	}
+	return nil
+}
+
+func paeth(a int, b int, c int) byte {
+	p := a + b - c
+	pa, pb, pc := abs(p-a), abs(p-b), abs(p-c)
+	if pa <= pb && pa <= pc {
+		return byte(a)
+	} else if pb <= pc {
+		return byte(b)
+	}
+	return byte(c)
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,201 @@
+package ipa
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// The pixels of a 2x2 CgBI png, as premultiplied BGRA.
+var cgbiPixels = [][]byte{
+	{0x10, 0x20, 0x30, 0xff, 0, 50, 100, 128},
+	// the second pixel's blue is out of range for its alpha, and is clamped
+	{0, 0, 0, 0, 200, 64, 64, 64},
+}
+
+// The same pixels after converting.
+var cgbiWant = []color.NRGBA{
+	{0x30, 0x20, 0x10, 0xff}, {199, 100, 0, 128},
+	{0, 0, 0, 0}, {255, 255, 255, 64},
+}
+
+func TestNormalizePNGCgBI(t *testing.T) {
+	for _, filter := range []byte{0, 1, 2, 3, 4} {
+		t.Run(filterNames[filter], func(t *testing.T) {
+			data := makeCgBI(2, 2, filterRows(filter, cgbiPixels))
+			normalized, err := NormalizePNG(data)
+			if err != nil {
+				t.Fatalf("NormalizePNG() error = %v", err)
+			}
+			img, err := png.Decode(bytes.NewReader(normalized))
+			if err != nil {
+				t.Fatalf("decode normalized png: %v", err)
+			}
+			if got := img.Bounds(); got != image.Rect(0, 0, 2, 2) {
+				t.Fatalf("bounds = %v, want 2x2", got)
+			}
+			for y := 0; y < 2; y++ {
+				for x := 0; x < 2; x++ {
+					got := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+					if want := cgbiWant[y*2+x]; got != want {
+						t.Errorf("pixel %d,%d = %v, want %v", x, y, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestNormalizePNGStandard(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	img.Set(1, 1, color.NRGBA{R: 0xff, A: 0xff})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	got, err := NormalizePNG(buf.Bytes())
+	if err != nil {
+		t.Fatalf("NormalizePNG() error = %v", err)
+	}
+	if !bytes.Equal(got, buf.Bytes()) {
+		t.Error("NormalizePNG() changed a standard png")
+	}
+}
+
+func TestNormalizePNGInvalid(t *testing.T) {
+	valid := filterRows(0, cgbiPixels)
+	badFilter := filterRows(0, cgbiPixels)
+	badFilter[1][0] = 5
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"not a png", []byte("GIF89a")},
+		{"truncated chunk", makeCgBI(2, 2, valid)[:40]},
+		{"too wide", makeCgBI(maxPNGDimension+1, 1, nil)},
+		{"too tall", makeCgBI(1, maxPNGDimension+1, nil)},
+		{"too large to multiply", makeCgBI(0xffffffff, 0xffffffff, nil)},
+		{"empty", makeCgBI(0, 0, nil)},
+		{"missing image data", makeCgBI(2, 2, nil)},
+		{"invalid filter", makeCgBI(2, 2, badFilter)},
+		{"zlib instead of raw deflate", makeCgBIWithData(2, 2, zlibWrapped(t, valid))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NormalizePNG(tt.data); err == nil {
+				t.Error("NormalizePNG() succeeded, want error")
+			}
+		})
+	}
+}
+
+var filterNames = []string{"none", "sub", "up", "average", "paeth"}
+
+// Returns the rows filtered with the given filter type, each prefixed with it.
+func filterRows(filter byte, rows [][]byte) [][]byte {
+	var results [][]byte
+	previous := make([]byte, len(rows[0]))
+	for _, row := range rows {
+		filtered := []byte{filter}
+		for i, value := range row {
+			var left, up, upLeft int
+			if i >= 4 {
+				left, upLeft = int(row[i-4]), int(previous[i-4])
+			}
+			up = int(previous[i])
+			var predicted int
+			switch filter {
+			case 1:
+				predicted = left
+			case 2:
+				predicted = up
+			case 3:
+				predicted = (left + up) / 2
+			case 4:
+				predicted = paethPredictor(left, up, upLeft)
+			}
+			filtered = append(filtered, value-byte(predicted))
+		}
+		results = append(results, filtered)
+		previous = row
+	}
+	return results
+}
+
+// The predictor as written in the png specification.
+func paethPredictor(a, b, c int) int {
+	p := a + b - c
+	pa, pb, pc := p-a, p-b, p-c
+	if pa < 0 {
+		pa = -pa
+	}
+	if pb < 0 {
+		pb = -pb
+	}
+	if pc < 0 {
+		pc = -pc
+	}
+	if pa <= pb && pa <= pc {
+		return a
+	} else if pb <= pc {
+		return b
+	}
+	return c
+}
+
+// Returns a CgBI png of the filtered rows, with the image data as a raw deflate stream.
+func makeCgBI(width, height uint32, rows [][]byte) []byte {
+	var compressed bytes.Buffer
+	if rows != nil {
+		w, _ := flate.NewWriter(&compressed, flate.BestCompression)
+		for _, row := range rows {
+			w.Write(row)
+		}
+		w.Close()
+	}
+	return makeCgBIWithData(width, height, compressed.Bytes())
+}
+
+func makeCgBIWithData(width, height uint32, imageData []byte) []byte {
+	header := binary.BigEndian.AppendUint32(nil, width)
+	header = binary.BigEndian.AppendUint32(header, height)
+	// 8 bit RGBA, not interlaced
+	header = append(header, 8, 6, 0, 0, 0)
+	data := []byte(pngHeader)
+	data = appendPNGChunk(data, "CgBI", []byte{0x50, 0x00, 0x20, 0x06})
+	data = appendPNGChunk(data, "IHDR", header)
+	if len(imageData) > 0 {
+		data = appendPNGChunk(data, "IDAT", imageData)
+	}
+	return appendPNGChunk(data, "IEND", nil)
+}
+
+func appendPNGChunk(data []byte, typ string, chunk []byte) []byte {
+	data = binary.BigEndian.AppendUint32(data, uint32(len(chunk)))
+	start := len(data)
+	data = append(data, typ...)
+	data = append(data, chunk...)
+	return binary.BigEndian.AppendUint32(data, crc32.ChecksumIEEE(data[start:]))
+}
+
+// Returns the rows compressed as a standard png has them, with a zlib header and checksum.
+func zlibWrapped(t *testing.T, rows [][]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	for _, row := range rows {
+		if _, err := w.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,79 @@
+package ipa
+
+import (
+	"LocalSignTools/src/plist"
+	"github.com/pkg/errors"
+	"path"
+	"strings"
+)
+
+// Returns the base names of the primary icon files, such as AppIcon60x60, from the places Info.plist may list them.
+// https://developer.apple.com/documentation/bundleresources/information_property_list/cfbundleicons
+func getIconNames(dict plist.Dict) []string {
+	var names []string
+	for _, key := range []string{"CFBundleIcons", "CFBundleIcons~ipad"} {
+		primary := dict.Dict(key).Dict("CFBundlePrimaryIcon")
+		names = append(names, primary.Strings("CFBundleIconFiles")...)
+		// asset catalogs also copy the icon next to Info.plist, named after the icon set
+		if name := primary.String("CFBundleIconName"); name != "" {
+			names = append(names, name)
+		}
+	}
+	// before iOS 5
+	names = append(names, dict.Strings("CFBundleIconFiles")...)
+	if name := dict.String("CFBundleIconFile"); name != "" {
+		names = append(names, name)
+	}
+	for i, name := range names {
+		names[i] = strings.TrimSuffix(name, ".png")
+	}
+	return names
+}
+
+// Icon returns the largest primary icon of the main app as a standard png, or nil if it has none.
+func (i *Ipa) Icon() ([]byte, error) {
+	dict, err := i.InfoPlist()
+	if err != nil {
+		return nil, err
+	}
+	names := getIconNames(dict)
+	if len(names) < 1 {
+		return nil, nil
+	}
+	// the files are the base names with a suffix, such as AppIcon60x60@2x.png or AppIcon76x76@2x~ipad.png
+	var best []byte
+	bestWidth := 0
+	for _, f := range i.reader.File {
+		dir, fileName := path.Split(f.Name)
+		if path.Clean(dir) != i.appPath || path.Ext(fileName) != ".png" {
+			continue
+		}
+		matches := false
+		for _, name := range names {
+			if strings.HasPrefix(fileName, name) {
+				matches = true
+				break
+			}
+		}
+		if !matches {
+			continue
+		}
+		data, err := i.ReadFile(fileName)
+		if err != nil {
+			return nil, err
+		}
+		width, _, err := pngSize(data)
+		if err != nil || width <= bestWidth {
+			continue
+		}
+		best, bestWidth = data, width
+	}
+	if best == nil {
+		return nil, nil
+	}
+	result, err := NormalizePNG(best)
+	if err != nil {
+		return nil, errors.WithMessage(err, "decode icon")
+	}
+	return result, nil
+}
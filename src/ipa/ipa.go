@@ -0,0 +1,172 @@
+// Package ipa reads what an app says about itself from its ipa, without extracting it.
+package ipa
+
+import (
+	"LocalSignTools/src/plist"
+	"archive/zip"
+	"github.com/pkg/errors"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Files read from an ipa are small, anything larger than this is refused rather than read into memory.
+const maxFileSize = 16 * 1024 * 1024
+
+// https://developer.apple.com/documentation/bundleresources/information_property_list/uidevicefamily
+var deviceFamilies = map[int64]string{
+	1: "iPhone",
+	2: "iPad",
+	3: "Apple TV",
+	4: "Apple Watch",
+	6: "Mac",
+	7: "Apple Vision",
+}
+
+// Info is what the Info.plist of the main app of an ipa says.
+type Info struct {
+	// CFBundleDisplayName, or CFBundleName if it has none.
+	DisplayName string `json:"display_name"`
+	BundleId    string `json:"bundle_id"`
+	// CFBundleShortVersionString, the version shown to users.
+	Version string `json:"version"`
+	// CFBundleVersion, the build number.
+	Build            string   `json:"build"`
+	MinimumOSVersion string   `json:"minimum_os_version,omitempty"`
+	DeviceFamilies   []string `json:"device_families,omitempty"`
+	Executable       string   `json:"executable,omitempty"`
+	// The path of the main app in the ipa, such as Payload/Name.app.
+	AppPath string `json:"app_path"`
+}
+
+// Ipa is an opened ipa, with its main app found.
+type Ipa struct {
+	reader  *zip.Reader
+	appPath string
+}
+
+// Open opens an ipa and finds its main app, which must be the only app in Payload.
+func Open(file io.ReaderAt, size int64) (*Ipa, error) {
+	reader, err := zip.NewReader(file, size)
+	if err != nil {
+		return nil, errors.WithMessage(err, "open zip, the file may be truncated or not an ipa")
+	}
+	apps := map[string]bool{}
+	for _, f := range reader.File {
+		parts := strings.SplitN(f.Name, "/", 3)
+		if len(parts) >= 2 && parts[0] == "Payload" && path.Ext(parts[1]) == ".app" {
+			apps[path.Join(parts[0], parts[1])] = true
+		}
+	}
+	if len(apps) < 1 {
+		return nil, errors.New("no app found in Payload")
+	} else if len(apps) > 1 {
+		var names []string
+		for name := range apps {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, errors.Errorf("found %d apps in Payload, expected one: %s", len(apps), strings.Join(names, ", "))
+	}
+	result := &Ipa{reader: reader}
+	for appPath := range apps {
+		result.appPath = appPath
+	}
+	return result, nil
+}
+
+// AppPath returns the path of the main app in the ipa, such as Payload/Name.app.
+func (i *Ipa) AppPath() string {
+	return i.appPath
+}
+
+// ReadFile reads a file of the main app, such as Info.plist.
+func (i *Ipa) ReadFile(name string) ([]byte, error) {
+	fullName := path.Join(i.appPath, name)
+	for _, f := range i.reader.File {
+		if f.Name != fullName {
+			continue
+		}
+		if f.UncompressedSize64 > maxFileSize {
+			return nil, errors.Errorf("%s is too large", name)
+		}
+		r, err := f.Open()
+		if err != nil {
+			return nil, errors.WithMessagef(err, "open %s", name)
+		}
+		defer r.Close()
+		data, err := io.ReadAll(io.LimitReader(r, maxFileSize))
+		if err != nil {
+			return nil, errors.WithMessagef(err, "read %s", name)
+		}
+		return data, nil
+	}
+	return nil, errors.Errorf("%s not found in %s", name, i.appPath)
+}
+
+// InfoPlist returns the Info.plist of the main app, which may be XML or binary.
+func (i *Ipa) InfoPlist() (plist.Dict, error) {
+	return i.readInfoPlist("")
+}
+
+// Reads the Info.plist of a bundle in the main app, or of the main app itself if bundlePath is empty.
+func (i *Ipa) readInfoPlist(bundlePath string) (plist.Dict, error) {
+	name := path.Join(bundlePath, "Info.plist")
+	data, err := i.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	dict, err := plist.DecodeDict(data)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "parse %s", name)
+	}
+	return dict, nil
+}
+
+// Info returns what the Info.plist of the main app says.
+func (i *Ipa) Info() (*Info, error) {
+	dict, err := i.InfoPlist()
+	if err != nil {
+		return nil, err
+	}
+	info := &Info{
+		DisplayName:      dict.String("CFBundleDisplayName"),
+		BundleId:         dict.String("CFBundleIdentifier"),
+		Version:          dict.String("CFBundleShortVersionString"),
+		Build:            dict.String("CFBundleVersion"),
+		MinimumOSVersion: dict.String("MinimumOSVersion"),
+		Executable:       dict.String("CFBundleExecutable"),
+		AppPath:          i.appPath,
+	}
+	if info.DisplayName == "" {
+		info.DisplayName = dict.String("CFBundleName")
+	}
+	if info.BundleId == "" {
+		return nil, errors.New("Info.plist has no CFBundleIdentifier")
+	}
+	for _, item := range dict.Array("UIDeviceFamily") {
+		// usually integers, but sometimes strings
+		var family int64
+		switch item := item.(type) {
+		case int64:
+			family = item
+		case string:
+			family, _ = strconv.ParseInt(item, 10, 64)
+		}
+		if name, ok := deviceFamilies[family]; ok {
+			info.DeviceFamilies = append(info.DeviceFamilies, name)
+		}
+	}
+	return info, nil
+}
+
+// Inspect returns what the Info.plist of the main app of an ipa says.
+func Inspect(file io.ReaderAt, size int64) (*Info, error) {
+	i, err := Open(file, size)
+	if err != nil {
+		return nil, err
+	}
+	return i.Info()
+}
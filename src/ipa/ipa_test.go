@@ -0,0 +1,159 @@
+package ipa
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Returns an ipa holding the given files, in order.
+func makeIpa(t *testing.T, files ...file) *bytes.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, f := range files {
+		fw, err := w.Create(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write(f.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+type file struct {
+	name string
+	data []byte
+}
+
+func xmlPlist(body string) []byte {
+	return []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0"><dict>` + body + `</dict></plist>`)
+}
+
+func TestInspect(t *testing.T) {
+	binaryInfo, err := os.ReadFile(filepath.Join("testdata", "Info.plist"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		files []file
+		want  *Info
+	}{
+		{
+			name: "binary Info.plist",
+			files: []file{
+				{"Payload/Example.app/Info.plist", binaryInfo},
+				{"Payload/Example.app/Example", []byte("executable")},
+			},
+			want: &Info{
+				DisplayName:      "Example App",
+				BundleId:         "com.example.app",
+				Version:          "1.2.3",
+				Build:            "45",
+				MinimumOSVersion: "15.0",
+				DeviceFamilies:   []string{"iPhone", "iPad"},
+				Executable:       "Example",
+				AppPath:          "Payload/Example.app",
+			},
+		},
+		{
+			name: "xml Info.plist",
+			files: []file{
+				{"Payload/Example.app/Info.plist", xmlPlist(`
+	<key>CFBundleIdentifier</key><string>com.example.xml</string>
+	<key>CFBundleName</key><string>Example</string>
+	<key>CFBundleShortVersionString</key><string>2.0</string>
+	<key>CFBundleVersion</key><string>1</string>
+	<key>UIDeviceFamily</key><array><integer>2</integer><integer>5</integer></array>`)},
+			},
+			want: &Info{
+				DisplayName:    "Example",
+				BundleId:       "com.example.xml",
+				Version:        "2.0",
+				Build:          "1",
+				DeviceFamilies: []string{"iPad"},
+				AppPath:        "Payload/Example.app",
+			},
+		},
+		{
+			name: "string device families",
+			files: []file{
+				{"Payload/Example.app/Info.plist", xmlPlist(`
+	<key>CFBundleIdentifier</key><string>com.example.strings</string>
+	<key>UIDeviceFamily</key><array><string>1</string><string>tv</string><string>7</string></array>`)},
+			},
+			want: &Info{
+				BundleId:       "com.example.strings",
+				DeviceFamilies: []string{"iPhone", "Apple Vision"},
+				AppPath:        "Payload/Example.app",
+			},
+		},
+		{
+			name: "extensions and metadata",
+			files: []file{
+				{"iTunesMetadata.plist", xmlPlist(``)},
+				{"Payload/Example.app/Info.plist", xmlPlist(`<key>CFBundleIdentifier</key><string>com.example.app</string>`)},
+				{"Payload/Example.app/PlugIns/Widget.appex/Info.plist", xmlPlist(`<key>CFBundleIdentifier</key><string>com.example.app.widget</string>`)},
+			},
+			want: &Info{
+				BundleId: "com.example.app",
+				AppPath:  "Payload/Example.app",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := makeIpa(t, tt.files...)
+			got, err := Inspect(r, r.Size())
+			if err != nil {
+				t.Fatalf("Inspect() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Inspect() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInspectInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []file
+	}{
+		{"no payload", []file{{"Example.app/Info.plist", xmlPlist(`<key>CFBundleIdentifier</key><string>a</string>`)}}},
+		{"two apps", []file{
+			{"Payload/A.app/Info.plist", xmlPlist(`<key>CFBundleIdentifier</key><string>a</string>`)},
+			{"Payload/B.app/Info.plist", xmlPlist(`<key>CFBundleIdentifier</key><string>b</string>`)},
+		}},
+		{"no Info.plist", []file{{"Payload/Example.app/Example", []byte("executable")}}},
+		{"invalid Info.plist", []file{{"Payload/Example.app/Info.plist", []byte("not a plist")}}},
+		{"Info.plist is not a dict", []file{{"Payload/Example.app/Info.plist", []byte(`<plist version="1.0"><array/></plist>`)}}},
+		{"missing bundle id", []file{{"Payload/Example.app/Info.plist", xmlPlist(`<key>CFBundleName</key><string>Example</string>`)}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := makeIpa(t, tt.files...)
+			if got, err := Inspect(r, r.Size()); err == nil {
+				t.Errorf("Inspect() = %+v, want error", got)
+			}
+		})
+	}
+}
+
+func TestOpenNotZip(t *testing.T) {
+	data := []byte("<html>not found</html>")
+	if _, err := Open(bytes.NewReader(data), int64(len(data))); err == nil {
+		t.Error("Open() of html succeeded, want error")
+	}
+}
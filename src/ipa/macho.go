@@ -0,0 +1,402 @@
+package ipa
+
+import (
+	"bytes"
+	"debug/macho"
+	"encoding/binary"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Only the headers and load commands of a binary are read, since its code can be hundreds of MB.
+// Load commands are a few KB, anything much larger isn't a real binary.
+const maxLoadCommandsSize = 16 * 1024 * 1024
+
+const (
+	fatMagic   = 0xcafebabe
+	fatMagic64 = 0xcafebabf
+)
+
+// Load commands that debug/macho doesn't name.
+// https://github.com/apple-oss-distributions/xnu/blob/main/EXTERNAL_HEADERS/mach-o/loader.h
+const (
+	loadCmdLoadWeakDylib    = 0x80000018
+	loadCmdReexportDylib    = 0x8000001f
+	loadCmdLazyLoadDylib    = 0x20
+	loadCmdLoadUpwardDylib  = 0x80000023
+	loadCmdEncryptionInfo   = 0x21
+	loadCmdEncryptionInfo64 = 0x2c
+	loadCmdVersionMinMacOS  = 0x24
+	loadCmdVersionMinIOS    = 0x25
+	loadCmdVersionMinTVOS   = 0x2f
+	loadCmdVersionMinWatch  = 0x30
+	loadCmdBuildVersion     = 0x32
+)
+
+// Binary is what the headers of a Mach-O executable say, combined across the architectures of a fat binary.
+type Binary struct {
+	// The path in the ipa.
+	Path          string   `json:"path"`
+	Architectures []string `json:"architectures"`
+	// From LC_BUILD_VERSION or LC_VERSION_MIN_*, the highest of the architectures.
+	MinimumOSVersion string `json:"minimum_os_version,omitempty"`
+	// Whether any architecture is still encrypted by the App Store, with a non-zero cryptid.
+	// Such apps are signed fine, but crash on launch.
+	Encrypted bool `json:"encrypted"`
+	// The paths of the linked dylibs and frameworks, such as @rpath/Foo.framework/Foo.
+	Libraries []string `json:"libraries"`
+	// Why the binary couldn't be analyzed, in which case only Path is set.
+	Error string `json:"error,omitempty"`
+}
+
+// Binaries are the executables of the main app and its extensions.
+type Binaries struct {
+	Main       Binary   `json:"main"`
+	Extensions []Binary `json:"extensions,omitempty"`
+}
+
+// GetEncrypted returns the paths of the binaries that are encrypted, of the main app and its extensions.
+func (b *Binaries) GetEncrypted() []string {
+	var results []string
+	for _, binary := range append([]Binary{b.Main}, b.Extensions...) {
+		if binary.Encrypted {
+			results = append(results, binary.Path)
+		}
+	}
+	return results
+}
+
+// GetErrors returns why binaries couldn't be analyzed, as "path: reason".
+func (b *Binaries) GetErrors() []string {
+	var results []string
+	for _, binary := range append([]Binary{b.Main}, b.Extensions...) {
+		if binary.Error != "" {
+			results = append(results, binary.Path+": "+binary.Error)
+		}
+	}
+	return results
+}
+
+// Binaries analyzes the executables of the main app and of its extensions in PlugIns and Extensions.
+// A binary that can't be analyzed doesn't stop the others, and has the reason in its Error instead.
+func (i *Ipa) Binaries() (*Binaries, error) {
+	mainInfo, err := i.Info()
+	if err != nil {
+		return nil, err
+	}
+	if mainInfo.Executable == "" {
+		return nil, errors.New("Info.plist has no CFBundleExecutable")
+	}
+	result := &Binaries{Main: i.readBinaryOrError(path.Join(i.appPath, mainInfo.Executable))}
+	for _, extensionPath := range i.getExtensionPaths() {
+		dict, err := i.readInfoPlist(strings.TrimPrefix(extensionPath, i.appPath+"/"))
+		if err != nil {
+			result.Extensions = append(result.Extensions, Binary{Path: extensionPath, Error: err.Error()})
+			continue
+		}
+		executable := dict.String("CFBundleExecutable")
+		if executable == "" {
+			result.Extensions = append(result.Extensions, Binary{Path: extensionPath, Error: "Info.plist has no CFBundleExecutable"})
+			continue
+		}
+		result.Extensions = append(result.Extensions, i.readBinaryOrError(path.Join(extensionPath, executable)))
+	}
+	return result, nil
+}
+
+func (i *Ipa) readBinaryOrError(name string) Binary {
+	result, err := i.readBinary(name)
+	if err != nil {
+		return Binary{Path: name, Error: err.Error()}
+	}
+	return *result
+}
+
+// Returns the paths of the extensions of the main app, such as Payload/Name.app/PlugIns/Share.appex.
+func (i *Ipa) getExtensionPaths() []string {
+	found := map[string]bool{}
+	for _, f := range i.reader.File {
+		rest := strings.TrimPrefix(f.Name, i.appPath+"/")
+		if rest == f.Name {
+			continue
+		}
+		parts := strings.SplitN(rest, "/", 3)
+		if len(parts) == 3 && (parts[0] == "PlugIns" || parts[0] == "Extensions") && path.Ext(parts[1]) == ".appex" {
+			found[path.Join(i.appPath, parts[0], parts[1])] = true
+		}
+	}
+	var results []string
+	for extensionPath := range found {
+		results = append(results, extensionPath)
+	}
+	sort.Strings(results)
+	return results
+}
+
+// Reads the headers of a binary in the ipa. Compressed files can't be read at an offset, so the file is read
+// from the start, skipping what comes between the headers of each architecture.
+func (i *Ipa) readBinary(name string) (*Binary, error) {
+	for _, f := range i.reader.File {
+		if f.Name != name {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return nil, errors.WithMessagef(err, "open %s", name)
+		}
+		defer r.Close()
+		result, err := parseBinary(&offsetReader{r: r})
+		if err != nil {
+			return nil, errors.WithMessagef(err, "parse %s", name)
+		}
+		result.Path = name
+		return result, nil
+	}
+	return nil, errors.Errorf("%s not found", name)
+}
+
+// A reader that can only move forward, tracking where it is.
+type offsetReader struct {
+	r      io.Reader
+	offset uint64
+}
+
+func (o *offsetReader) readAt(offset uint64, n uint64) ([]byte, error) {
+	if offset < o.offset {
+		return nil, errors.New("overlapping architectures")
+	}
+	if _, err := io.CopyN(io.Discard, o.r, int64(offset-o.offset)); err != nil {
+		return nil, err
+	}
+	o.offset = offset
+	b := make([]byte, n)
+	if _, err := io.ReadFull(o.r, b); err != nil {
+		return nil, err
+	}
+	o.offset += n
+	return b, nil
+}
+
+type fatArch struct {
+	offset uint64
+}
+
+func parseBinary(r *offsetReader) (*Binary, error) {
+	magic, err := r.readAt(0, 8)
+	if err != nil {
+		return nil, errors.WithMessage(err, "read header")
+	}
+	var archs []fatArch
+	switch binary.BigEndian.Uint32(magic) {
+	case fatMagic, fatMagic64:
+		archSize := uint64(20)
+		if binary.BigEndian.Uint32(magic) == fatMagic64 {
+			archSize = 32
+		}
+		count := uint64(binary.BigEndian.Uint32(magic[4:]))
+		if count < 1 || count > 64 {
+			return nil, errors.Errorf("invalid number of architectures %d", count)
+		}
+		table, err := r.readAt(8, count*archSize)
+		if err != nil {
+			return nil, errors.WithMessage(err, "read architectures")
+		}
+		for j := uint64(0); j < count; j++ {
+			entry := table[j*archSize:]
+			if archSize == 20 {
+				archs = append(archs, fatArch{offset: uint64(binary.BigEndian.Uint32(entry[8:]))})
+			} else {
+				archs = append(archs, fatArch{offset: binary.BigEndian.Uint64(entry[8:])})
+			}
+		}
+		sort.Slice(archs, func(a, b int) bool {
+			return archs[a].offset < archs[b].offset
+		})
+	default:
+		// thin, put back what was read to look for the fat header
+		archs = []fatArch{{offset: 0}}
+		r.offset = 0
+		r.r = io.MultiReader(bytes.NewReader(magic), r.r)
+	}
+	result := &Binary{Architectures: []string{}, Libraries: []string{}}
+	libraries := map[string]bool{}
+	for _, arch := range archs {
+		slice, err := parseSlice(r, arch.offset)
+		if err != nil {
+			return nil, err
+		}
+		result.Architectures = append(result.Architectures, slice.arch)
+		if slice.encrypted {
+			result.Encrypted = true
+		}
+		if compareVersions(slice.minimumOS, result.MinimumOSVersion) > 0 {
+			result.MinimumOSVersion = slice.minimumOS
+		}
+		for _, library := range slice.libraries {
+			if !libraries[library] {
+				libraries[library] = true
+				result.Libraries = append(result.Libraries, library)
+			}
+		}
+	}
+	return result, nil
+}
+
+type slice struct {
+	arch      string
+	minimumOS string
+	encrypted bool
+	libraries []string
+}
+
+func parseSlice(r *offsetReader, offset uint64) (*slice, error) {
+	header, err := r.readAt(offset, 28)
+	if err != nil {
+		return nil, errors.WithMessage(err, "read mach header")
+	}
+	var order binary.ByteOrder
+	is64 := false
+	switch {
+	case binary.LittleEndian.Uint32(header) == macho.Magic32:
+		order = binary.LittleEndian
+	case binary.LittleEndian.Uint32(header) == macho.Magic64:
+		order, is64 = binary.LittleEndian, true
+	case binary.BigEndian.Uint32(header) == macho.Magic32:
+		order = binary.BigEndian
+	case binary.BigEndian.Uint32(header) == macho.Magic64:
+		order, is64 = binary.BigEndian, true
+	default:
+		return nil, errors.New("not a Mach-O binary")
+	}
+	result := &slice{arch: getArchName(macho.Cpu(order.Uint32(header[4:])), order.Uint32(header[8:]))}
+	count := order.Uint32(header[16:])
+	size := uint64(order.Uint32(header[20:]))
+	if size > maxLoadCommandsSize {
+		return nil, errors.New("load commands too large")
+	}
+	commandsOffset := r.offset
+	if is64 {
+		// reserved field of the 64 bit header
+		commandsOffset += 4
+	}
+	commands, err := r.readAt(commandsOffset, size)
+	if err != nil {
+		return nil, errors.WithMessage(err, "read load commands")
+	}
+	for j := uint32(0); j < count; j++ {
+		if len(commands) < 8 {
+			return nil, errors.New("truncated load commands")
+		}
+		cmd, cmdSize := order.Uint32(commands), order.Uint32(commands[4:])
+		if cmdSize < 8 || uint64(cmdSize) > uint64(len(commands)) {
+			return nil, errors.Errorf("invalid load command size %d", cmdSize)
+		}
+		data := commands[:cmdSize]
+		commands = commands[cmdSize:]
+		switch cmd {
+		case uint32(macho.LoadCmdDylib), loadCmdLoadWeakDylib, loadCmdReexportDylib, loadCmdLazyLoadDylib, loadCmdLoadUpwardDylib:
+			if len(data) < 12 {
+				continue
+			}
+			nameOffset := order.Uint32(data[8:])
+			if nameOffset < uint32(len(data)) {
+				name := data[nameOffset:]
+				if end := bytes.IndexByte(name, 0); end >= 0 {
+					name = name[:end]
+				}
+				result.libraries = append(result.libraries, string(name))
+			}
+		case loadCmdEncryptionInfo, loadCmdEncryptionInfo64:
+			if len(data) >= 20 && order.Uint32(data[16:]) != 0 {
+				result.encrypted = true
+			}
+		case loadCmdVersionMinIOS, loadCmdVersionMinTVOS, loadCmdVersionMinWatch, loadCmdVersionMinMacOS:
+			if len(data) >= 12 && result.minimumOS == "" {
+				result.minimumOS = formatVersion(order.Uint32(data[8:]))
+			}
+		case loadCmdBuildVersion:
+			// takes precedence over the older command
+			if len(data) >= 16 {
+				result.minimumOS = formatVersion(order.Uint32(data[12:]))
+			}
+		}
+	}
+	return result, nil
+}
+
+// Versions are encoded as xxxx.yy.zz in nibbles.
+func formatVersion(version uint32) string {
+	major, minor, patch := version>>16, (version>>8)&0xff, version&0xff
+	if patch == 0 {
+		return fmt.Sprintf("%d.%d", major, minor)
+	}
+	return fmt.Sprintf("%d.%d.%d", major, minor, patch)
+}
+
+// Compares two dotted versions, where empty is the lowest.
+func compareVersions(a string, b string) int {
+	if a == "" || b == "" {
+		return len(a) - len(b)
+	}
+	aParts, bParts := strings.Split(a, "."), strings.Split(b, ".")
+	for j := 0; j < len(aParts) || j < len(bParts); j++ {
+		var aPart, bPart int
+		if j < len(aParts) {
+			fmt.Sscanf(aParts[j], "%d", &aPart)
+		}
+		if j < len(bParts) {
+			fmt.Sscanf(bParts[j], "%d", &bPart)
+		}
+		if aPart != bPart {
+			return aPart - bPart
+		}
+	}
+	return 0
+}
+
+const (
+	// the 32 bit pointer arm64 of watches
+	cpuArm64_32      = macho.CpuArm | 0x02000000
+	cpuSubtypeMask   = 0x00ffffff
+	cpuSubtypeArm64e = 2
+)
+
+var armSubtypes = map[uint32]string{
+	5:  "armv4t",
+	6:  "armv6",
+	7:  "armv5",
+	9:  "armv7",
+	10: "armv7f",
+	11: "armv7s",
+	12: "armv7k",
+	14: "armv6m",
+	15: "armv7m",
+	16: "armv7em",
+}
+
+func getArchName(cpu macho.Cpu, subtype uint32) string {
+	subtype &= cpuSubtypeMask
+	switch cpu {
+	case macho.CpuArm64:
+		if subtype == cpuSubtypeArm64e {
+			return "arm64e"
+		}
+		return "arm64"
+	case cpuArm64_32:
+		return "arm64_32"
+	case macho.CpuArm:
+		if name, ok := armSubtypes[subtype]; ok {
+			return name
+		}
+		return "arm"
+	case macho.CpuAmd64:
+		return "x86_64"
+	case macho.Cpu386:
+		return "i386"
+	}
+	return fmt.Sprintf("cpu %d", cpu)
+}
@@ -0,0 +1,385 @@
+package ipa
+
+import (
+	"bytes"
+	"debug/macho"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// A load command, encoded in the byte order of its binary.
+type command func(order binary.AppendByteOrder) []byte
+
+// Returns a load command of the given fields, followed by data padded to 8 bytes.
+func makeCommand(order binary.AppendByteOrder, cmd uint32, fields []uint32, data []byte) []byte {
+	size := 8 + 4*len(fields) + len(data)
+	size = (size + 7) &^ 7
+	b := order.AppendUint32(nil, cmd)
+	b = order.AppendUint32(b, uint32(size))
+	for _, field := range fields {
+		b = order.AppendUint32(b, field)
+	}
+	b = append(b, data...)
+	return append(b, make([]byte, size-len(b))...)
+}
+
+func dylib(cmd uint32, name string) command {
+	return func(order binary.AppendByteOrder) []byte {
+		// name offset, timestamp, current and compatibility version
+		return makeCommand(order, cmd, []uint32{24, 0, 0x10000, 0x10000}, append([]byte(name), 0))
+	}
+}
+
+func encryptionInfo(cmd uint32, cryptId uint32) command {
+	return func(order binary.AppendByteOrder) []byte {
+		// crypt offset, size and id
+		return makeCommand(order, cmd, []uint32{0x4000, 0x8000, cryptId}, nil)
+	}
+}
+
+func versionMin(cmd uint32, version uint32) command {
+	return func(order binary.AppendByteOrder) []byte {
+		return makeCommand(order, cmd, []uint32{version, version}, nil)
+	}
+}
+
+func buildVersion(minOS uint32) command {
+	return func(order binary.AppendByteOrder) []byte {
+		// platform iOS, minimum OS, sdk, no tools
+		return makeCommand(order, loadCmdBuildVersion, []uint32{2, minOS, minOS, 0}, nil)
+	}
+}
+
+// A command of the given raw bytes, which may be invalid.
+func rawCommand(b ...byte) command {
+	return func(order binary.AppendByteOrder) []byte {
+		return b
+	}
+}
+
+// Returns a Mach-O binary of a single architecture with the given load commands.
+func makeSlice(order binary.AppendByteOrder, is64 bool, cpu macho.Cpu, subtype uint32, commands ...command) []byte {
+	var encoded []byte
+	for _, cmd := range commands {
+		encoded = append(encoded, cmd(order)...)
+	}
+	return makeSliceHeader(order, is64, cpu, subtype, uint32(len(commands)), uint32(len(encoded)), encoded)
+}
+
+func makeSliceHeader(order binary.AppendByteOrder, is64 bool, cpu macho.Cpu, subtype uint32, count uint32, size uint32, commands []byte) []byte {
+	magic := uint32(macho.Magic32)
+	if is64 {
+		magic = macho.Magic64
+	}
+	b := order.AppendUint32(nil, magic)
+	b = order.AppendUint32(b, uint32(cpu))
+	b = order.AppendUint32(b, subtype)
+	b = order.AppendUint32(b, uint32(macho.TypeExec))
+	b = order.AppendUint32(b, count)
+	b = order.AppendUint32(b, size)
+	b = order.AppendUint32(b, 0)
+	if is64 {
+		b = order.AppendUint32(b, 0)
+	}
+	return append(b, commands...)
+}
+
+type fatSlice struct {
+	cpu     macho.Cpu
+	subtype uint32
+	data    []byte
+}
+
+// Returns a fat binary of the given slices, each aligned to 4 KB, with 64 bit offsets if is64.
+func makeFat(is64 bool, slices ...fatSlice) []byte {
+	const align = 0x1000
+	magic, entrySize := uint32(fatMagic), 20
+	if is64 {
+		magic, entrySize = fatMagic64, 32
+	}
+	header := binary.BigEndian.AppendUint32(nil, magic)
+	header = binary.BigEndian.AppendUint32(header, uint32(len(slices)))
+	offset := uint64((8 + entrySize*len(slices) + align - 1) &^ (align - 1))
+	var body []byte
+	for _, s := range slices {
+		header = binary.BigEndian.AppendUint32(header, uint32(s.cpu))
+		header = binary.BigEndian.AppendUint32(header, s.subtype)
+		if is64 {
+			header = binary.BigEndian.AppendUint64(header, offset)
+			header = binary.BigEndian.AppendUint64(header, uint64(len(s.data)))
+			header = binary.BigEndian.AppendUint32(header, 12)
+			header = binary.BigEndian.AppendUint32(header, 0)
+		} else {
+			header = binary.BigEndian.AppendUint32(header, uint32(offset))
+			header = binary.BigEndian.AppendUint32(header, uint32(len(s.data)))
+			header = binary.BigEndian.AppendUint32(header, 12)
+		}
+		padded := append(append([]byte{}, s.data...), make([]byte, align-len(s.data)%align)...)
+		body = append(body, padded...)
+		offset += uint64(len(padded))
+	}
+	header = append(header, make([]byte, align-len(header)%align)...)
+	return append(header, body...)
+}
+
+// iOS 12.0, 13.0 and 14.5.1 in the nibble encoding of load commands.
+const (
+	ios12   = 12 << 16
+	ios13   = 13 << 16
+	ios1451 = 14<<16 | 5<<8 | 1
+)
+
+func TestParseBinary(t *testing.T) {
+	le, be := binary.LittleEndian, binary.BigEndian
+	foundation := dylib(uint32(macho.LoadCmdDylib), "/System/Library/Frameworks/Foundation.framework/Foundation")
+	libSystem := dylib(uint32(macho.LoadCmdDylib), "/usr/lib/libSystem.B.dylib")
+	arm64 := makeSlice(le, true, macho.CpuArm64, 0, foundation, libSystem,
+		versionMin(loadCmdVersionMinIOS, ios12), encryptionInfo(loadCmdEncryptionInfo64, 0))
+	armv7 := makeSlice(le, false, macho.CpuArm, 9, foundation,
+		versionMin(loadCmdVersionMinIOS, ios13), encryptionInfo(loadCmdEncryptionInfo, 0))
+	arm64e := makeSlice(le, true, macho.CpuArm64, cpuSubtypeArm64e|0x80000000, libSystem,
+		dylib(loadCmdLoadWeakDylib, "@rpath/Weak.framework/Weak"),
+		buildVersion(ios1451), encryptionInfo(loadCmdEncryptionInfo64, 1))
+	tests := []struct {
+		name string
+		data []byte
+		want *Binary
+	}{
+		{
+			name: "thin arm64",
+			data: arm64,
+			want: &Binary{
+				Architectures:    []string{"arm64"},
+				MinimumOSVersion: "12.0",
+				Libraries:        []string{"/System/Library/Frameworks/Foundation.framework/Foundation", "/usr/lib/libSystem.B.dylib"},
+			},
+		},
+		{
+			name: "thin armv7",
+			data: armv7,
+			want: &Binary{
+				Architectures:    []string{"armv7"},
+				MinimumOSVersion: "13.0",
+				Libraries:        []string{"/System/Library/Frameworks/Foundation.framework/Foundation"},
+			},
+		},
+		{
+			name: "thin big endian",
+			data: makeSlice(be, false, macho.CpuPpc, 0, libSystem, versionMin(loadCmdVersionMinMacOS, 10<<16|4<<8)),
+			want: &Binary{
+				Architectures:    []string{"cpu 18"},
+				MinimumOSVersion: "10.4",
+				Libraries:        []string{"/usr/lib/libSystem.B.dylib"},
+			},
+		},
+		{
+			name: "encrypted",
+			data: arm64e,
+			want: &Binary{
+				Architectures:    []string{"arm64e"},
+				MinimumOSVersion: "14.5.1",
+				Encrypted:        true,
+				Libraries:        []string{"/usr/lib/libSystem.B.dylib", "@rpath/Weak.framework/Weak"},
+			},
+		},
+		{
+			name: "build version after version min",
+			data: makeSlice(le, true, macho.CpuArm64, 0, versionMin(loadCmdVersionMinIOS, ios12), buildVersion(ios13)),
+			want: &Binary{Architectures: []string{"arm64"}, MinimumOSVersion: "13.0", Libraries: []string{}},
+		},
+		{
+			name: "build version before version min",
+			data: makeSlice(le, true, macho.CpuArm64, 0, buildVersion(ios13), versionMin(loadCmdVersionMinIOS, ios12)),
+			want: &Binary{Architectures: []string{"arm64"}, MinimumOSVersion: "13.0", Libraries: []string{}},
+		},
+		{
+			name: "no version",
+			data: makeSlice(le, true, macho.CpuAmd64, 3),
+			want: &Binary{Architectures: []string{"x86_64"}, Libraries: []string{}},
+		},
+		{
+			name: "fat",
+			data: makeFat(false, fatSlice{macho.CpuArm, 9, armv7}, fatSlice{macho.CpuArm64, 0, arm64}),
+			want: &Binary{
+				Architectures:    []string{"armv7", "arm64"},
+				MinimumOSVersion: "13.0",
+				Libraries:        []string{"/System/Library/Frameworks/Foundation.framework/Foundation", "/usr/lib/libSystem.B.dylib"},
+			},
+		},
+		{
+			name: "fat 64",
+			data: makeFat(true, fatSlice{macho.CpuArm64, 0, arm64}, fatSlice{macho.CpuArm64, cpuSubtypeArm64e, arm64e}),
+			want: &Binary{
+				Architectures:    []string{"arm64", "arm64e"},
+				MinimumOSVersion: "14.5.1",
+				Encrypted:        true,
+				Libraries: []string{"/System/Library/Frameworks/Foundation.framework/Foundation", "/usr/lib/libSystem.B.dylib",
+					"@rpath/Weak.framework/Weak"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseBinary(&offsetReader{r: bytes.NewReader(tt.data)})
+			if err != nil {
+				t.Fatalf("parseBinary() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseBinary() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseBinaryFatOrder(t *testing.T) {
+	armv7 := makeSlice(binary.LittleEndian, false, macho.CpuArm, 9)
+	arm64 := makeSlice(binary.LittleEndian, true, macho.CpuArm64, 0)
+	data := makeFat(false, fatSlice{macho.CpuArm, 9, armv7}, fatSlice{macho.CpuArm64, 0, arm64})
+	// swap the entries, the slices are read in the order they are stored
+	entries := append([]byte{}, data[8:48]...)
+	copy(data[8:28], entries[20:])
+	copy(data[28:48], entries[:20])
+	got, err := parseBinary(&offsetReader{r: bytes.NewReader(data)})
+	if err != nil {
+		t.Fatalf("parseBinary() error = %v", err)
+	}
+	if want := []string{"armv7", "arm64"}; !reflect.DeepEqual(got.Architectures, want) {
+		t.Errorf("parseBinary() architectures = %v, want %v", got.Architectures, want)
+	}
+}
+
+func TestParseBinaryInvalid(t *testing.T) {
+	le := binary.LittleEndian
+	arm64 := makeSlice(le, true, macho.CpuArm64, 0, buildVersion(ios13))
+	fatHeader := func(magic uint32, count uint32) []byte {
+		return binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, magic), count)
+	}
+	overlapping := makeFat(false, fatSlice{macho.CpuArm64, 0, arm64}, fatSlice{macho.CpuArm64, 0, arm64})
+	// point the second architecture at the first
+	copy(overlapping[36:40], overlapping[16:20])
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"too short", []byte{0xcf, 0xfa, 0xed, 0xfe}},
+		{"not mach-o", []byte("#!/bin/sh\necho hello, this is not a binary\n")},
+		{"no architectures", fatHeader(fatMagic, 0)},
+		{"too many architectures", fatHeader(fatMagic, 65)},
+		{"truncated architectures", fatHeader(fatMagic64, 2)},
+		{"overlapping architectures", overlapping},
+		{"truncated header", arm64[:20]},
+		{"load commands past end", arm64[:len(arm64)-1]},
+		{"load commands too large", makeSliceHeader(le, true, macho.CpuArm64, 0, 1, maxLoadCommandsSize+1, nil)},
+		{"more commands than fit", makeSliceHeader(le, true, macho.CpuArm64, 0, 2, 24, buildVersion(ios13)(le))},
+		{"truncated command", makeSlice(le, true, macho.CpuArm64, 0, rawCommand(0x32, 0, 0, 0))},
+		{"command size too small", makeSlice(le, true, macho.CpuArm64, 0, rawCommand(0x32, 0, 0, 0, 4, 0, 0, 0))},
+		{"command size past end", makeSlice(le, true, macho.CpuArm64, 0, rawCommand(0x32, 0, 0, 0, 64, 0, 0, 0))},
+		{"fat slice not mach-o", makeFat(true, fatSlice{macho.CpuArm64, 0, []byte("not a binary, but long enough for a header")})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := parseBinary(&offsetReader{r: bytes.NewReader(tt.data)}); err == nil {
+				t.Errorf("parseBinary() = %+v, want error", got)
+			}
+		})
+	}
+}
+
+func TestBinaries(t *testing.T) {
+	le := binary.LittleEndian
+	mainBinary := makeSlice(le, true, macho.CpuArm64, 0, buildVersion(ios13), encryptionInfo(loadCmdEncryptionInfo64, 0))
+	widget := makeSlice(le, true, macho.CpuArm64, 0, buildVersion(ios1451), encryptionInfo(loadCmdEncryptionInfo64, 1))
+	r := makeIpa(t,
+		file{"Payload/Example.app/Info.plist", xmlPlist(`
+	<key>CFBundleIdentifier</key><string>com.example.app</string>
+	<key>CFBundleExecutable</key><string>Example</string>`)},
+		file{"Payload/Example.app/Example", mainBinary},
+		file{"Payload/Example.app/PlugIns/Widget.appex/Info.plist", xmlPlist(`
+	<key>CFBundleIdentifier</key><string>com.example.app.widget</string>
+	<key>CFBundleExecutable</key><string>Widget</string>`)},
+		file{"Payload/Example.app/PlugIns/Widget.appex/Widget", widget},
+	)
+	i, err := Open(r, r.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	binaries, err := i.Binaries()
+	if err != nil {
+		t.Fatalf("Binaries() error = %v", err)
+	}
+	if binaries.Main.Path != "Payload/Example.app/Example" || binaries.Main.MinimumOSVersion != "13.0" {
+		t.Errorf("Binaries() main = %+v", binaries.Main)
+	}
+	if len(binaries.Extensions) != 1 || binaries.Extensions[0].MinimumOSVersion != "14.5.1" {
+		t.Errorf("Binaries() extensions = %+v", binaries.Extensions)
+	}
+	if got, want := binaries.GetEncrypted(), []string{"Payload/Example.app/PlugIns/Widget.appex/Widget"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetEncrypted() = %v, want %v", got, want)
+	}
+}
+
+func TestBinariesAnalysisErrors(t *testing.T) {
+	le := binary.LittleEndian
+	mainBinary := makeSlice(le, true, macho.CpuArm64, 0, buildVersion(ios13))
+	encrypted := makeSlice(le, true, macho.CpuArm64, 0, encryptionInfo(loadCmdEncryptionInfo64, 1))
+	r := makeIpa(t,
+		file{"Payload/Example.app/Info.plist", xmlPlist(`
+	<key>CFBundleIdentifier</key><string>com.example.app</string>
+	<key>CFBundleExecutable</key><string>Example</string>`)},
+		file{"Payload/Example.app/Example", mainBinary},
+		file{"Payload/Example.app/PlugIns/A.appex/Info.plist", xmlPlist(`<key>CFBundleExecutable</key><string>A</string>`)},
+		file{"Payload/Example.app/PlugIns/A.appex/A", []byte("not a binary")},
+		file{"Payload/Example.app/PlugIns/B.appex/Info.plist", xmlPlist(``)},
+		file{"Payload/Example.app/PlugIns/C.appex/Info.plist", xmlPlist(`<key>CFBundleExecutable</key><string>C</string>`)},
+		file{"Payload/Example.app/PlugIns/C.appex/C", encrypted},
+	)
+	i, err := Open(r, r.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	binaries, err := i.Binaries()
+	if err != nil {
+		t.Fatalf("Binaries() error = %v", err)
+	}
+	if binaries.Main.Error != "" || binaries.Main.MinimumOSVersion != "13.0" {
+		t.Errorf("Binaries() main = %+v", binaries.Main)
+	}
+	var failed []string
+	for _, extension := range binaries.Extensions {
+		if extension.Error != "" {
+			failed = append(failed, extension.Path)
+		}
+	}
+	if want := []string{"Payload/Example.app/PlugIns/A.appex/A", "Payload/Example.app/PlugIns/B.appex"}; !reflect.DeepEqual(failed, want) {
+		t.Errorf("Binaries() failed = %v, want %v", failed, want)
+	}
+	if got := binaries.GetErrors(); len(got) != 2 {
+		t.Errorf("GetErrors() = %v, want 2 errors", got)
+	}
+	if got, want := binaries.GetEncrypted(), []string{"Payload/Example.app/PlugIns/C.appex/C"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetEncrypted() = %v, want %v", got, want)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"", "9.0", -1},
+		{"12.0", "", 1},
+		{"12.0", "12", 0},
+		{"12.1", "12.0.5", 1},
+		{"9.3", "10.0", -1},
+		{"14.5.1", "14.5", 1},
+	}
+	for _, tt := range tests {
+		got := compareVersions(tt.a, tt.b)
+		if (got > 0) != (tt.want > 0) || (got < 0) != (tt.want < 0) {
+			t.Errorf("compareVersions(%q, %q) = %d, want sign of %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package ipa
+
+import (
+	"archive/zip"
+	"github.com/pkg/errors"
+	"io"
+	"path"
+)
+
+// Validate opens an ipa and checks that it can be signed, see (*Ipa).Validate.
+func Validate(file io.ReaderAt, size int64) error {
+	i, err := Open(file, size)
+	if err != nil {
+		return err
+	}
+	return i.Validate()
+}
+
+// Validate checks that an ipa can be signed: the zip is intact, and has exactly one app in Payload
+// with an Info.plist and its main executable. It catches truncated downloads, zips that aren't ipas,
+// and error pages saved as ipas, before they fail inside the sign script.
+func (i *Ipa) Validate() error {
+	info, err := i.Info()
+	if err != nil {
+		return err
+	}
+	if info.Executable == "" {
+		return errors.New("Info.plist has no CFBundleExecutable")
+	}
+	executable := path.Join(i.appPath, info.Executable)
+	foundExecutable := false
+	// every file is read so that its checksum is checked
+	for _, f := range i.reader.File {
+		if f.Name == executable && !f.FileInfo().IsDir() {
+			foundExecutable = true
+		}
+		if err := checkFile(f); err != nil {
+			return errors.WithMessagef(err, "%s is corrupt", f.Name)
+		}
+	}
+	if !foundExecutable {
+		return errors.Errorf("main executable %s not found", executable)
+	}
+	return nil
+}
+
+func checkFile(f *zip.File) error {
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	// the reader returns an error at the end if the checksum or size doesn't match
+	_, err = io.Copy(io.Discard, r)
+	return err
+}
@@ -0,0 +1,84 @@
+package ipa
+
+import (
+	"archive/zip"
+	"bytes"
+	"hash/crc32"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	infoPlist := file{"Payload/Example.app/Info.plist", xmlPlist(`
+	<key>CFBundleIdentifier</key><string>com.example.app</string>
+	<key>CFBundleExecutable</key><string>Example</string>`)}
+	executable := file{"Payload/Example.app/Example", []byte("binary")}
+	validBytes := ipaBytes(t, infoPlist, executable)
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr bool
+	}{
+		{name: "valid", data: validBytes},
+		{name: "valid with directories", data: ipaBytes(t, file{"Payload/", nil}, file{"Payload/Example.app/", nil}, infoPlist, executable)},
+		{name: "empty", data: nil, wantErr: true},
+		{name: "not a zip", data: []byte("<html>Not Found</html>"), wantErr: true},
+		{name: "truncated zip", data: validBytes[:len(validBytes)-10], wantErr: true},
+		{name: "truncated to half", data: validBytes[:len(validBytes)/2], wantErr: true},
+		{name: "missing Payload", data: ipaBytes(t, file{"Example.app/Info.plist", infoPlist.data}, file{"Example.app/Example", executable.data}), wantErr: true},
+		{name: "no app in Payload", data: ipaBytes(t, file{"Payload/Info.plist", infoPlist.data}, file{"Payload/Example", executable.data}), wantErr: true},
+		{name: "two apps", data: ipaBytes(t, infoPlist, executable, file{"Payload/Other.app/Info.plist", infoPlist.data}), wantErr: true},
+		{name: "missing Info.plist", data: ipaBytes(t, executable), wantErr: true},
+		{name: "no CFBundleExecutable", data: ipaBytes(t, file{infoPlist.name, xmlPlist(`<key>CFBundleIdentifier</key><string>com.example.app</string>`)}, executable), wantErr: true},
+		{name: "missing executable", data: ipaBytes(t, infoPlist), wantErr: true},
+		{name: "executable is a directory", data: ipaBytes(t, infoPlist, file{"Payload/Example.app/Example/", nil}), wantErr: true},
+		{name: "crc mismatch", data: ipaWithBadChecksum(t, infoPlist, executable), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(bytes.NewReader(tt.data), int64(len(tt.data)))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func ipaBytes(t *testing.T, files ...file) []byte {
+	t.Helper()
+	r := makeIpa(t, files...)
+	data := make([]byte, r.Size())
+	if _, err := r.ReadAt(data, 0); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// Returns an ipa with the given files, the last of which is stored with a checksum that doesn't match its data.
+func ipaWithBadChecksum(t *testing.T, files ...file) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for i, f := range files {
+		header := &zip.FileHeader{
+			Name:               f.name,
+			Method:             zip.Store,
+			CRC32:              crc32.ChecksumIEEE(f.data),
+			CompressedSize64:   uint64(len(f.data)),
+			UncompressedSize64: uint64(len(f.data)),
+		}
+		if i == len(files)-1 {
+			header.CRC32++
+		}
+		fw, err := w.CreateRaw(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write(f.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,240 @@
+// Package metrics collects server metrics and exposes them in the Prometheus text format.
+// https://prometheus.io/docs/instrumenting/exposition_formats/
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []collector
+)
+
+func register(c collector) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, c)
+}
+
+// Write writes all registered metrics to w, in the order they were registered.
+func Write(w io.Writer) error {
+	registryMu.Lock()
+	collectors := append([]collector{}, registry...)
+	registryMu.Unlock()
+	bw := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.write(bw)
+	}
+	return bw.Flush()
+}
+
+// The content type of the output of Write.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+type metric struct {
+	name   string
+	help   string
+	labels []string
+}
+
+func (m *metric) writeHeader(w *bufio.Writer, metricType string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", m.name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(m.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", m.name, metricType)
+}
+
+// Writes a single sample. extraName and extraValue add one more label, like the "le" of histogram buckets.
+func (m *metric) writeSample(w *bufio.Writer, suffix string, labelValues []string, extraName string, extraValue string, value float64) {
+	w.WriteString(m.name)
+	w.WriteString(suffix)
+	names := m.labels
+	if extraName != "" {
+		names = append(append([]string{}, names...), extraName)
+		labelValues = append(append([]string{}, labelValues...), extraValue)
+	}
+	if len(names) > 0 {
+		w.WriteByte('{')
+		for i, name := range names {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, name, escapeLabelValue(labelValues[i]))
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Label values are joined with a separator that can't appear in them, to key the series of a metric.
+const labelSeparator = "\xff"
+
+func seriesKey(m *metric, labelValues []string) string {
+	if len(labelValues) != len(m.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", m.name, len(m.labels), len(labelValues)))
+	}
+	return strings.Join(labelValues, labelSeparator)
+}
+
+func sortedKeys[V any](series map[string]V) []string {
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func splitKey(m *metric, key string) []string {
+	if len(m.labels) == 0 {
+		return nil
+	}
+	return strings.Split(key, labelSeparator)
+}
+
+// CounterVec is a value that only goes up, with one series per combination of label values.
+type CounterVec struct {
+	metric
+	mu     sync.Mutex
+	series map[string]float64
+}
+
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{metric: metric{name: name, help: help, labels: labels}, series: map[string]float64{}}
+	register(c)
+	return c
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Add(value float64, labelValues ...string) {
+	key := seriesKey(&c.metric, labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.series[key] += value
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeHeader(w, "counter")
+	if len(c.labels) == 0 && len(c.series) == 0 {
+		c.writeSample(w, "", nil, "", "", 0)
+	}
+	for _, key := range sortedKeys(c.series) {
+		c.writeSample(w, "", splitKey(&c.metric, key), "", "", c.series[key])
+	}
+}
+
+// HistogramVec counts observed values, such as durations, in cumulative buckets.
+type HistogramVec struct {
+	metric
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogramVec creates a histogram with the given bucket upper bounds, which must be sorted.
+// The +Inf bucket is always added.
+func NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{metric: metric{name: name, help: help, labels: labels}, buckets: buckets, series: map[string]*histogram{}}
+	register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	key := seriesKey(&h.metric, labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	series, ok := h.series[key]
+	if !ok {
+		series = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = series
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			series.counts[i]++
+		}
+	}
+	series.count++
+	series.sum += value
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writeHeader(w, "histogram")
+	for _, key := range sortedKeys(h.series) {
+		series := h.series[key]
+		labelValues := splitKey(&h.metric, key)
+		for i, bound := range h.buckets {
+			h.writeSample(w, "_bucket", labelValues, "le", formatFloat(bound), float64(series.counts[i]))
+		}
+		h.writeSample(w, "_bucket", labelValues, "le", "+Inf", float64(series.count))
+		h.writeSample(w, "_sum", labelValues, "", "", series.sum)
+		h.writeSample(w, "_count", labelValues, "", "", float64(series.count))
+	}
+}
+
+// Sample is one series of a GaugeFunc.
+type Sample struct {
+	LabelValues []string
+	Value       float64
+}
+
+// GaugeFunc is a value that can go up and down, read from fn every time the metrics are written.
+type GaugeFunc struct {
+	metric
+	fn func() []Sample
+}
+
+func NewGaugeFunc(name string, help string, fn func() []Sample, labels ...string) *GaugeFunc {
+	g := &GaugeFunc{metric: metric{name: name, help: help, labels: labels}, fn: fn}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	g.writeHeader(w, "gauge")
+	for _, sample := range g.fn() {
+		g.writeSample(w, "", sample.LabelValues, "", "", sample.Value)
+	}
+}
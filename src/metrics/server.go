@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Bucket upper bounds in seconds, from quick API calls to large uploads.
+var httpDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Bucket upper bounds in seconds. Signing usually takes tens of seconds to a few minutes.
+var jobDurationBuckets = []float64{5, 10, 30, 60, 120, 300, 600, 900, 1800, 3600}
+
+var (
+	HttpRequests = NewCounterVec("signtools_http_requests_total",
+		"HTTP requests handled, by method, route and status code.",
+		"method", "route", "code")
+	HttpRequestDuration = NewHistogramVec("signtools_http_request_duration_seconds",
+		"Time taken to handle HTTP requests, by method and route.",
+		httpDurationBuckets, "method", "route")
+	HttpResponseBytes = NewCounterVec("signtools_http_response_bytes_total",
+		"Bytes written in HTTP response bodies, by method and route.",
+		"method", "route")
+	UploadBytes = NewCounterVec("signtools_upload_bytes_total",
+		"Bytes of completed file uploads, including unsigned apps, tweaks and signed apps.")
+	Uploads = NewCounterVec("signtools_uploads_total",
+		"Completed file uploads.")
+	JobsFinished = NewCounterVec("signtools_jobs_finished_total",
+		"Sign jobs that finished, by profile id, builder id and status. Failed attempts that are retried count as failed.",
+		"profile", "builder", "status")
+	JobDuration = NewHistogramVec("signtools_job_duration_seconds",
+		"Time from the start to the end of sign jobs, by profile id, builder id and status.",
+		jobDurationBuckets, "profile", "builder", "status")
+	JobQueueWait = NewHistogramVec("signtools_job_queue_wait_seconds",
+		"Time sign jobs waited in the queue before they were started, by profile id and builder id.",
+		jobDurationBuckets, "profile", "builder")
+)
+
+// Middleware records the HTTP request metrics.
+// Requests are labelled with their route, such as /apps/:id/install, so that ids don't create a series each.
+func Middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		start := time.Now()
+		err := next(c)
+		route := c.Path()
+		if route == "" {
+			route = "unmatched"
+		}
+		method := c.Request().Method
+		code := c.Response().Status
+		if err != nil {
+			// The error is turned into a response after all middleware ran.
+			code = 500
+			var httpErr *echo.HTTPError
+			if errors.As(err, &httpErr) {
+				code = httpErr.Code
+			}
+		}
+		HttpRequests.Inc(method, route, strconv.Itoa(code))
+		HttpRequestDuration.Observe(time.Since(start).Seconds(), method, route)
+		HttpResponseBytes.Add(float64(c.Response().Size), method, route)
+		return err
+	}
+}
+
+// DirSize returns a GaugeFunc function that reports the total size of the files in a directory.
+// Walking large directories is slow, so the result is reused for maxAge.
+func DirSize(path string, maxAge time.Duration) func() []Sample {
+	var mu sync.Mutex
+	var size int64
+	var measuredAt time.Time
+	return func() []Sample {
+		mu.Lock()
+		defer mu.Unlock()
+		if time.Since(measuredAt) >= maxAge {
+			size = 0
+			// Files may be deleted while walking, so errors are skipped rather than failing the whole walk.
+			_ = filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+				if err != nil || d.IsDir() {
+					return nil
+				}
+				if info, err := d.Info(); err == nil {
+					size += info.Size()
+				}
+				return nil
+			})
+			measuredAt = time.Now()
+		}
+		return []Sample{{Value: float64(size)}}
+	}
+}
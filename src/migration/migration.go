@@ -0,0 +1,294 @@
+// Package migration moves apps from one signing profile to another, such as when a certificate is revoked
+// or replaced, and re-signs them with the new one.
+package migration
+
+import (
+	"LocalSignTools/src/events"
+	"LocalSignTools/src/storage"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"sort"
+	"sync"
+	"time"
+)
+
+type AppStatus string
+
+const (
+	// The app is waiting for its turn to be moved.
+	AppStatusPending AppStatus = "pending"
+	// The app was moved, and its sign job is queued or running.
+	AppStatusSigning   AppStatus = "signing"
+	AppStatusSucceeded AppStatus = "succeeded"
+	AppStatusFailed    AppStatus = "failed"
+)
+
+// The finished migrations that are kept to be looked at, oldest first.
+const maxFinishedMigrations = 20
+
+// How often the sign jobs are checked, in case their events were missed.
+const pollInterval = 10 * time.Second
+
+// InputError is returned when a migration can't be started with the given profiles or apps.
+type InputError struct {
+	error
+}
+
+type AppResult struct {
+	AppId   string    `json:"app_id"`
+	AppName string    `json:"app_name"`
+	Status  AppStatus `json:"status"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// Migration is the progress of moving apps from a source profile to a target profile.
+type Migration struct {
+	Id         string      `json:"id"`
+	SourceId   string      `json:"source_id"`
+	SourceName string      `json:"source_name"`
+	TargetId   string      `json:"target_id"`
+	TargetName string      `json:"target_name"`
+	StartedAt  time.Time   `json:"started_at"`
+	FinishedAt time.Time   `json:"finished_at,omitzero"`
+	Apps       []AppResult `json:"apps"`
+	// The number of apps that succeeded or failed.
+	Done int `json:"done"`
+}
+
+// IsFinished reports whether every app has succeeded or failed.
+func (m *Migration) IsFinished() bool {
+	return !m.FinishedAt.IsZero()
+}
+
+// A running migration. Its state is only changed while holding mu.
+type migration struct {
+	mu    sync.Mutex
+	state Migration
+}
+
+var (
+	mu         sync.Mutex
+	migrations = map[string]*migration{}
+)
+
+// Get returns a copy of the current state of a migration.
+func Get(id string) (Migration, bool) {
+	mu.Lock()
+	m, ok := migrations[id]
+	mu.Unlock()
+	if !ok {
+		return Migration{}, false
+	}
+	return m.get(), true
+}
+
+// GetAll returns copies of all running and recent migrations, newest first.
+func GetAll() []Migration {
+	mu.Lock()
+	var results []Migration
+	for _, m := range migrations {
+		results = append(results, m.get())
+	}
+	mu.Unlock()
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].StartedAt.After(results[j].StartedAt)
+	})
+	return results
+}
+
+func (m *migration) get() Migration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := m.state
+	result.Apps = append([]AppResult{}, m.state.Apps...)
+	return result
+}
+
+func (m *migration) update(i int, status AppStatus, errStr string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state.Apps[i].Status = status
+	m.state.Apps[i].Error = errStr
+	if status == AppStatusSucceeded || status == AppStatusFailed {
+		m.state.Done++
+	}
+}
+
+// Start moves the apps signed with the source profile to the target profile and re-signs them with sign, one by one
+// in the background. If appIds is empty, all apps of the source profile are moved. The source profile doesn't have to
+// be loaded, since it may have become invalid, but the target profile must be usable.
+func Start(sourceId string, targetId string, appIds []string, sign func(app storage.App) error) (*Migration, error) {
+	if sourceId == targetId {
+		return nil, InputError{errors.New("the source and target profiles are the same")}
+	}
+	target, ok := storage.Profiles.GetById(targetId)
+	if !ok || !storage.Profiles.IsUsable(targetId) {
+		return nil, InputError{errors.Errorf("target profile %s not found or invalid", targetId)}
+	}
+	apps, err := getApps(sourceId, appIds)
+	if err != nil {
+		return nil, err
+	}
+	m := &migration{state: Migration{
+		Id:         uuid.NewString(),
+		SourceId:   sourceId,
+		SourceName: getProfileName(sourceId),
+		TargetId:   targetId,
+		StartedAt:  time.Now(),
+		Apps:       []AppResult{},
+	}}
+	m.state.TargetName, _ = target.GetString(storage.ProfileName)
+	for _, app := range apps {
+		name, _ := app.GetString(storage.AppName)
+		m.state.Apps = append(m.state.Apps, AppResult{AppId: app.GetId(), AppName: name, Status: AppStatusPending})
+	}
+	mu.Lock()
+	migrations[m.state.Id] = m
+	removeOldMigrations()
+	mu.Unlock()
+	log.Info().Str("id", m.state.Id).Str("source", sourceId).Str("target", targetId).Int("apps", len(apps)).
+		Msg("starting profile migration")
+	result := m.get()
+	go m.run(apps, sign)
+	return &result, nil
+}
+
+func getProfileName(id string) string {
+	if profile, ok := storage.Profiles.GetById(id); ok {
+		name, _ := profile.GetString(storage.ProfileName)
+		return name
+	}
+	for _, invalid := range storage.Profiles.GetInvalid() {
+		if invalid.Id == id {
+			return invalid.Name
+		}
+	}
+	return "unknown"
+}
+
+// Returns the apps of the source profile, or the selected ones, which must all belong to it.
+func getApps(sourceId string, appIds []string) ([]storage.App, error) {
+	var results []storage.App
+	if len(appIds) > 0 {
+		for _, appId := range appIds {
+			app, ok := storage.Apps.Get(appId)
+			if !ok {
+				return nil, InputError{errors.Errorf("app %s not found", appId)}
+			}
+			if profileId, _ := app.GetString(storage.AppProfileId); profileId != sourceId {
+				return nil, InputError{errors.Errorf("app %s is not signed with the source profile", appId)}
+			}
+			results = append(results, app)
+		}
+		return results, nil
+	}
+	apps, err := storage.Apps.GetAll()
+	if err != nil {
+		return nil, errors.WithMessage(err, "get apps")
+	}
+	for _, app := range apps {
+		if profileId, _ := app.GetString(storage.AppProfileId); profileId == sourceId {
+			results = append(results, app)
+		}
+	}
+	if len(results) < 1 {
+		return nil, InputError{errors.New("no apps are signed with the source profile")}
+	}
+	return results, nil
+}
+
+// Drops the oldest finished migrations. Must be called while holding mu.
+func removeOldMigrations() {
+	var finished []Migration
+	for _, m := range migrations {
+		if state := m.get(); state.IsFinished() {
+			finished = append(finished, state)
+		}
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].FinishedAt.Before(finished[j].FinishedAt)
+	})
+	for i := 0; i < len(finished)-maxFinishedMigrations; i++ {
+		delete(migrations, finished[i].Id)
+	}
+}
+
+func (m *migration) run(apps []storage.App, sign func(app storage.App) error) {
+	appIndexes := map[string]int{}
+	for i, app := range apps {
+		appIndexes[app.GetId()] = i
+	}
+	eventChan, unsubscribe := events.Subscribe(func(event events.Event) bool {
+		_, ok := appIndexes[event.AppId]
+		return ok && (event.Type == events.JobSucceeded || event.Type == events.JobFailed || event.Type == events.JobCancelled)
+	})
+	defer unsubscribe()
+	signing := map[int]storage.App{}
+	// history entries from before are left by earlier jobs
+	startedAt := time.Now()
+	for i, app := range apps {
+		if err := move(app, m.state.TargetId, sign); err != nil {
+			log.Err(err).Str("app_id", app.GetId()).Msg("migrate app")
+			m.update(i, AppStatusFailed, err.Error())
+			continue
+		}
+		m.update(i, AppStatusSigning, "")
+		signing[i] = app
+	}
+	for len(signing) > 0 {
+		select {
+		case <-eventChan:
+		case <-time.After(pollInterval):
+		}
+		for i, app := range signing {
+			if pending, running := storage.Jobs.GetStatusByAppId(app.GetId()); pending || running {
+				continue
+			}
+			delete(signing, i)
+			// the app keeps its previous signed file if the job failed, so only the job tells whether it worked
+			job, ok, err := storage.GetLastJob(app)
+			if err != nil {
+				log.Err(err).Str("app_id", app.GetId()).Msg("get last job")
+			}
+			if !ok || job.QueuedAt.Before(startedAt) {
+				m.update(i, AppStatusFailed, "the result of the sign job was not recorded")
+			} else if job.Status == storage.JobStatusSucceeded {
+				m.update(i, AppStatusSucceeded, "")
+			} else if job.Error != "" {
+				m.update(i, AppStatusFailed, job.Error)
+			} else {
+				m.update(i, AppStatusFailed, "sign job "+string(job.Status))
+			}
+		}
+	}
+	m.mu.Lock()
+	m.state.FinishedAt = time.Now()
+	done := m.state.Done
+	m.mu.Unlock()
+	log.Info().Str("id", m.state.Id).Int("apps", done).Msg("profile migration finished")
+}
+
+// Points an app at the target profile and queues a sign job for it, keeping its sign args.
+func move(app storage.App, targetId string, sign func(app storage.App) error) error {
+	if _, exists := storage.Jobs.GetStatusByAppId(app.GetId()); exists {
+		return errors.New("the app already has a sign job, cancel it first")
+	}
+	previousId, err := app.GetString(storage.AppProfileId)
+	if err != nil {
+		return errors.WithMessage(err, "get profile id")
+	}
+	if err := app.SetString(storage.AppProfileId, targetId); err != nil {
+		return errors.WithMessage(err, "set profile id")
+	}
+	if err := sign(app); err != nil {
+		// unless the job was made, in which case it is already signing with the target profile
+		if pending, running := storage.Jobs.GetStatusByAppId(app.GetId()); !pending && !running {
+			if restoreErr := app.SetString(storage.AppProfileId, previousId); restoreErr != nil {
+				log.Err(restoreErr).Str("app_id", app.GetId()).Msg("restore profile id")
+			}
+		}
+		return errors.WithMessage(err, "queue sign job")
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package plist
+
+import (
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"math"
+	"time"
+	"unicode/utf16"
+)
+
+// https://opensource.apple.com/source/CF/CF-1153.18/CFBinaryPList.c
+const binaryHeader = "bplist00"
+
+const binaryTrailerSize = 32
+
+// Objects may reference each other in cycles, and real property lists are never nested this deep.
+const maxBinaryDepth = 128
+
+// Dates are seconds since 2001-01-01 UTC.
+var binaryEpoch = time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type binaryDecoder struct {
+	data          []byte
+	offsets       []uint64
+	objectRefSize int
+	// Objects referenced more than once are only decoded once, so that sharing them can't blow up the result.
+	decoded map[uint64]any
+}
+
+func decodeBinary(data []byte) (any, error) {
+	if len(data) < len(binaryHeader)+binaryTrailerSize {
+		return nil, errors.New("binary plist too short")
+	}
+	trailer := data[len(data)-binaryTrailerSize:]
+	offsetSize := int(trailer[6])
+	objectRefSize := int(trailer[7])
+	numObjects := binary.BigEndian.Uint64(trailer[8:])
+	topObject := binary.BigEndian.Uint64(trailer[16:])
+	offsetTableOffset := binary.BigEndian.Uint64(trailer[24:])
+	if offsetSize < 1 || offsetSize > 8 || objectRefSize < 1 || objectRefSize > 8 {
+		return nil, errors.New("invalid binary plist trailer")
+	}
+	tableEnd := uint64(len(data) - binaryTrailerSize)
+	if numObjects == 0 || offsetTableOffset > tableEnd || numObjects > (tableEnd-offsetTableOffset)/uint64(offsetSize) {
+		return nil, errors.New("invalid binary plist offset table")
+	}
+	d := &binaryDecoder{data: data, offsets: make([]uint64, numObjects), objectRefSize: objectRefSize, decoded: map[uint64]any{}}
+	for i := range d.offsets {
+		start := offsetTableOffset + uint64(i*offsetSize)
+		d.offsets[i] = readUint(data[start : start+uint64(offsetSize)])
+	}
+	return d.decodeObject(topObject, 0)
+}
+
+func readUint(b []byte) uint64 {
+	var value uint64
+	for _, c := range b {
+		value = value<<8 | uint64(c)
+	}
+	return value
+}
+
+// Returns the n bytes at offset, or an error if they are out of bounds.
+func (d *binaryDecoder) read(offset uint64, n uint64) ([]byte, error) {
+	if offset > uint64(len(d.data)) || n > uint64(len(d.data))-offset {
+		return nil, errors.New("object out of bounds")
+	}
+	return d.data[offset : offset+n], nil
+}
+
+// Returns the number of items or bytes of an object, and the offset of its contents.
+// Counts of 15 or more follow the marker as an integer object.
+func (d *binaryDecoder) readCount(marker byte, offset uint64) (uint64, uint64, error) {
+	count := uint64(marker & 0xf)
+	if count != 0xf {
+		return count, offset + 1, nil
+	}
+	intMarker, err := d.read(offset+1, 1)
+	if err != nil {
+		return 0, 0, err
+	}
+	if intMarker[0]>>4 != 0x1 {
+		return 0, 0, errors.Errorf("invalid count marker 0x%x", intMarker[0])
+	}
+	size := uint64(1) << (intMarker[0] & 0xf)
+	if size > 8 {
+		return 0, 0, errors.New("count too large")
+	}
+	b, err := d.read(offset+2, size)
+	if err != nil {
+		return 0, 0, err
+	}
+	return readUint(b), offset + 2 + size, nil
+}
+
+func (d *binaryDecoder) decodeRefs(offset uint64, count uint64, depth int) ([]any, error) {
+	if count > uint64(len(d.data))/uint64(d.objectRefSize) {
+		return nil, errors.New("too many references")
+	}
+	refs, err := d.read(offset, count*uint64(d.objectRefSize))
+	if err != nil {
+		return nil, err
+	}
+	values := make([]any, count)
+	for i := range values {
+		ref := readUint(refs[i*d.objectRefSize : (i+1)*d.objectRefSize])
+		if values[i], err = d.decodeObject(ref, depth+1); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+func (d *binaryDecoder) decodeObject(ref uint64, depth int) (any, error) {
+	if depth > maxBinaryDepth {
+		return nil, errors.New("binary plist nested too deep")
+	}
+	if ref >= uint64(len(d.offsets)) {
+		return nil, errors.Errorf("invalid object reference %d", ref)
+	}
+	if value, ok := d.decoded[ref]; ok {
+		return value, nil
+	}
+	value, err := d.decodeObjectAt(d.offsets[ref], depth)
+	if err != nil {
+		return nil, err
+	}
+	d.decoded[ref] = value
+	return value, nil
+}
+
+func (d *binaryDecoder) decodeObjectAt(offset uint64, depth int) (any, error) {
+	b, err := d.read(offset, 1)
+	if err != nil {
+		return nil, err
+	}
+	marker := b[0]
+	switch marker >> 4 {
+	case 0x0:
+		switch marker {
+		case 0x08:
+			return false, nil
+		case 0x09:
+			return true, nil
+		}
+		return nil, errors.Errorf("unsupported marker 0x%x", marker)
+	case 0x1:
+		size := uint64(1) << (marker & 0xf)
+		b, err := d.read(offset+1, size)
+		if err != nil {
+			return nil, err
+		}
+		switch size {
+		case 1, 2, 4, 8:
+			// smaller integers are unsigned, 8 byte ones signed
+			return int64(readUint(b)), nil
+		case 16:
+			// only the low 8 bytes are used, for unsigned values that don't fit an int64
+			value := readUint(b[8:])
+			if value > math.MaxInt64 {
+				return value, nil
+			}
+			return int64(value), nil
+		}
+		return nil, errors.Errorf("unsupported integer size %d", size)
+	case 0x2:
+		size := uint64(1) << (marker & 0xf)
+		b, err := d.read(offset+1, size)
+		if err != nil {
+			return nil, err
+		}
+		switch size {
+		case 4:
+			return float64(math.Float32frombits(uint32(readUint(b)))), nil
+		case 8:
+			return math.Float64frombits(readUint(b)), nil
+		}
+		return nil, errors.Errorf("unsupported real size %d", size)
+	case 0x3:
+		b, err := d.read(offset+1, 8)
+		if err != nil {
+			return nil, err
+		}
+		seconds := math.Float64frombits(readUint(b))
+		return binaryEpoch.Add(time.Duration(seconds * float64(time.Second))), nil
+	case 0x4, 0x5, 0x6:
+		count, start, err := d.readCount(marker, offset)
+		if err != nil {
+			return nil, err
+		}
+		if marker>>4 == 0x6 {
+			if count > uint64(len(d.data)) {
+				return nil, errors.New("string too long")
+			}
+			count *= 2
+		}
+		b, err := d.read(start, count)
+		if err != nil {
+			return nil, err
+		}
+		switch marker >> 4 {
+		case 0x4:
+			return append([]byte{}, b...), nil
+		case 0x5:
+			return string(b), nil
+		}
+		units := make([]uint16, len(b)/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(b[i*2:])
+		}
+		return string(utf16.Decode(units)), nil
+	case 0x8:
+		// UIDs of keyed archives
+		b, err := d.read(offset+1, uint64(marker&0xf)+1)
+		if err != nil {
+			return nil, err
+		}
+		return readUint(b), nil
+	case 0xA, 0xC:
+		// sets are read as arrays
+		count, start, err := d.readCount(marker, offset)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeRefs(start, count, depth)
+	case 0xD:
+		count, start, err := d.readCount(marker, offset)
+		if err != nil {
+			return nil, err
+		}
+		if count > uint64(len(d.data)) {
+			return nil, errors.New("dictionary too large")
+		}
+		keys, err := d.decodeRefs(start, count, depth)
+		if err != nil {
+			return nil, err
+		}
+		values, err := d.decodeRefs(start+count*uint64(d.objectRefSize), count, depth)
+		if err != nil {
+			return nil, err
+		}
+		dict := make(map[string]any, count)
+		for i, key := range keys {
+			keyStr, ok := key.(string)
+			if !ok {
+				return nil, errors.Errorf("dictionary key is %T, not a string", key)
+			}
+			dict[keyStr] = values[i]
+		}
+		return dict, nil
+	}
+	return nil, errors.Errorf("unsupported marker 0x%x", marker)
+}
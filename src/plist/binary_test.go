@@ -0,0 +1,145 @@
+package plist
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDecodeBinary(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "types.plist"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Decode(data)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	array := []any{int64(1), "two", []any{int64(3)}}
+	for i := 0; i < 20; i++ {
+		array = append(array, "x")
+	}
+	want := map[string]any{
+		"string":   "hello",
+		"unicode":  "Ünïcødé 😀",
+		"small":    int64(200),
+		"negative": int64(-5),
+		"big":      uint64(9223372036854775813),
+		"real":     1.5,
+		"true":     true,
+		"false":    false,
+		"date":     time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		"data":     []byte{0, 1, 2},
+		"array":    array,
+		"nested":   map[string]any{"a": map[string]any{"b": "c"}},
+		"shared":   map[string]any{"a": map[string]any{"b": "c"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Decode() = %#v, want %#v", got, want)
+	}
+}
+
+func TestDecodeBinaryTruncated(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "types.plist"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the trailer is at the end, so every prefix is missing it or points past the end
+	for size := 0; size < len(data); size++ {
+		if _, err := Decode(data[:size]); err == nil {
+			t.Fatalf("Decode() of the first %d bytes succeeded, want error", size)
+		}
+	}
+}
+
+func TestDecodeBinaryInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		objects [][]byte
+		top     uint64
+	}{
+		{"reference out of range", [][]byte{{0xa1, 0x05}}, 0},
+		{"top object out of range", [][]byte{{0x09}}, 1},
+		{"cycle", [][]byte{{0xa1, 0x00}}, 0},
+		{"non-string key", [][]byte{{0xd1, 0x01, 0x01}, {0x10, 0x01}}, 0},
+		{"unsupported marker", [][]byte{{0x70}}, 0},
+		{"unsupported integer size", [][]byte{{0x15, 0x00}}, 0},
+		{"string past end", [][]byte{{0x5f, 0x10, 0xff, 0x61}}, 0},
+		{"invalid count marker", [][]byte{{0x5f, 0x20, 0x01}}, 0},
+		{"count too large", [][]byte{{0xaf, 0x1f, 0xff}}, 0},
+		{"huge dictionary", [][]byte{{0xdf, 0x13, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := Decode(makeBinary(tt.objects, tt.top)); err == nil {
+				t.Errorf("Decode() = %#v, want error", got)
+			}
+		})
+	}
+}
+
+func TestDecodeBinaryInvalidTrailer(t *testing.T) {
+	valid := makeBinary([][]byte{{0x09}}, 0)
+	if _, err := Decode(valid); err != nil {
+		t.Fatalf("Decode() of a valid plist error = %v", err)
+	}
+	tests := []struct {
+		name   string
+		offset int
+		value  byte
+	}{
+		{"offset size", 6, 0},
+		{"object ref size", 7, 9},
+		{"no objects", 15, 0},
+		{"too many objects", 15, 0xff},
+		{"offset table past end", 31, 0xff},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := append([]byte{}, valid...)
+			data[len(data)-binaryTrailerSize+tt.offset] = tt.value
+			if got, err := Decode(data); err == nil {
+				t.Errorf("Decode() = %#v, want error", got)
+			}
+		})
+	}
+}
+
+func TestDecodeBinaryNesting(t *testing.T) {
+	// every array holds the next one, and the last one is empty
+	nest := func(depth int) []byte {
+		var objects [][]byte
+		for i := 0; i < depth; i++ {
+			objects = append(objects, []byte{0xa1, byte(i + 1)})
+		}
+		return makeBinary(append(objects, []byte{0xa0}), 0)
+	}
+	if _, err := Decode(nest(maxBinaryDepth)); err != nil {
+		t.Errorf("Decode() nested %d deep error = %v", maxBinaryDepth, err)
+	}
+	if _, err := Decode(nest(maxBinaryDepth + 1)); err == nil {
+		t.Errorf("Decode() nested %d deep succeeded, want error", maxBinaryDepth+1)
+	}
+}
+
+// Builds a binary plist of the given encoded objects, with two byte offsets and one byte object references.
+func makeBinary(objects [][]byte, top uint64) []byte {
+	data := []byte(binaryHeader)
+	var offsets []byte
+	for _, object := range objects {
+		offsets = binary.BigEndian.AppendUint16(offsets, uint16(len(data)))
+		data = append(data, object...)
+	}
+	offsetTableOffset := len(data)
+	data = append(data, offsets...)
+	trailer := make([]byte, binaryTrailerSize)
+	trailer[6] = 2
+	trailer[7] = 1
+	binary.BigEndian.PutUint64(trailer[8:], uint64(len(objects)))
+	binary.BigEndian.PutUint64(trailer[16:], top)
+	binary.BigEndian.PutUint64(trailer[24:], uint64(offsetTableOffset))
+	return append(data, trailer...)
+}
@@ -0,0 +1,85 @@
+// Package plist decodes XML and binary property lists into basic Go values.
+// Dictionaries become map[string]any and arrays []any. The other types become string, int64, float64, bool,
+// time.Time and []byte. Integers that don't fit an int64 become uint64, as do the UIDs of binary keyed archives.
+package plist
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"time"
+)
+
+// Decode decodes an XML or binary property list.
+func Decode(data []byte) (any, error) {
+	if bytes.HasPrefix(data, []byte(binaryHeader)) {
+		return decodeBinary(data)
+	}
+	return decodeXML(data)
+}
+
+// DecodeDict decodes a property list whose root is a dictionary.
+func DecodeDict(data []byte) (map[string]any, error) {
+	value, err := Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	dict, ok := value.(map[string]any)
+	if !ok {
+		return nil, errors.Errorf("root is %T, not a dictionary", value)
+	}
+	return dict, nil
+}
+
+// Dict reads typed values from a decoded dictionary. Missing keys and values of another type read as the zero value.
+type Dict map[string]any
+
+func (d Dict) String(key string) string {
+	value, _ := d[key].(string)
+	return value
+}
+
+func (d Dict) Bool(key string) bool {
+	value, _ := d[key].(bool)
+	return value
+}
+
+func (d Dict) Int(key string) int64 {
+	switch value := d[key].(type) {
+	case int64:
+		return value
+	case uint64:
+		return int64(value)
+	}
+	return 0
+}
+
+func (d Dict) Time(key string) time.Time {
+	value, _ := d[key].(time.Time)
+	return value
+}
+
+func (d Dict) Data(key string) []byte {
+	value, _ := d[key].([]byte)
+	return value
+}
+
+func (d Dict) Dict(key string) Dict {
+	value, _ := d[key].(map[string]any)
+	return value
+}
+
+func (d Dict) Array(key string) []any {
+	value, _ := d[key].([]any)
+	return value
+}
+
+// Strings returns the strings of an array, skipping values of other types.
+func (d Dict) Strings(key string) []string {
+	var results []string
+	for _, item := range d.Array(key) {
+		if str, ok := item.(string); ok {
+			results = append(results, str)
+		}
+	}
+	return results
+}
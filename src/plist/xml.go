@@ -0,0 +1,170 @@
+package plist
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"github.com/pkg/errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Real property lists are never nested this deep, and deeper nesting could exhaust the stack.
+const maxXMLDepth = 128
+
+func decodeXML(data []byte) (any, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil, errors.New("no plist element")
+		} else if err != nil {
+			return nil, errors.WithMessage(err, "read xml")
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			if start.Name.Local != "plist" {
+				return nil, errors.Errorf("unexpected element %s, expected plist", start.Name.Local)
+			}
+			break
+		}
+	}
+	start, err := nextStart(decoder)
+	if err != nil {
+		return nil, err
+	}
+	if start == nil {
+		return nil, errors.New("empty plist")
+	}
+	return decodeXMLValue(decoder, *start, 0)
+}
+
+// Returns the next start element, or nil if the current element ends first.
+func nextStart(decoder *xml.Decoder) (*xml.StartElement, error) {
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, errors.WithMessage(err, "read xml")
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			return &token, nil
+		case xml.EndElement:
+			return nil, nil
+		}
+	}
+}
+
+// Reads the text up to the end of the current element.
+func readText(decoder *xml.Decoder) (string, error) {
+	var text strings.Builder
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return "", errors.WithMessage(err, "read xml")
+		}
+		switch token := token.(type) {
+		case xml.CharData:
+			text.Write(token)
+		case xml.StartElement:
+			return "", errors.Errorf("unexpected element %s in text", token.Name.Local)
+		case xml.EndElement:
+			return text.String(), nil
+		}
+	}
+}
+
+func decodeXMLValue(decoder *xml.Decoder, start xml.StartElement, depth int) (any, error) {
+	if depth > maxXMLDepth {
+		return nil, errors.New("xml plist nested too deep")
+	}
+	switch start.Name.Local {
+	case "dict":
+		dict := map[string]any{}
+		for {
+			keyStart, err := nextStart(decoder)
+			if err != nil {
+				return nil, err
+			}
+			if keyStart == nil {
+				return dict, nil
+			}
+			if keyStart.Name.Local != "key" {
+				return nil, errors.Errorf("unexpected element %s, expected key", keyStart.Name.Local)
+			}
+			key, err := readText(decoder)
+			if err != nil {
+				return nil, err
+			}
+			valueStart, err := nextStart(decoder)
+			if err != nil {
+				return nil, err
+			}
+			if valueStart == nil {
+				return nil, errors.Errorf("missing value of key %s", key)
+			}
+			if dict[key], err = decodeXMLValue(decoder, *valueStart, depth+1); err != nil {
+				return nil, errors.WithMessagef(err, "key %s", key)
+			}
+		}
+	case "array":
+		array := []any{}
+		for {
+			itemStart, err := nextStart(decoder)
+			if err != nil {
+				return nil, err
+			}
+			if itemStart == nil {
+				return array, nil
+			}
+			item, err := decodeXMLValue(decoder, *itemStart, depth+1)
+			if err != nil {
+				return nil, errors.WithMessagef(err, "item %d", len(array))
+			}
+			array = append(array, item)
+		}
+	case "true", "false":
+		if err := decoder.Skip(); err != nil {
+			return nil, errors.WithMessage(err, "read xml")
+		}
+		return start.Name.Local == "true", nil
+	}
+	text, err := readText(decoder)
+	if err != nil {
+		return nil, err
+	}
+	switch start.Name.Local {
+	case "string":
+		return text, nil
+	case "integer":
+		text = strings.TrimSpace(text)
+		if value, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return value, nil
+		}
+		value, err := strconv.ParseUint(text, 10, 64)
+		if err != nil {
+			return nil, errors.WithMessage(err, "parse integer")
+		}
+		return value, nil
+	case "real":
+		value, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+		if err != nil {
+			return nil, errors.WithMessage(err, "parse real")
+		}
+		return value, nil
+	case "date":
+		value, err := time.Parse(time.RFC3339, strings.TrimSpace(text))
+		if err != nil {
+			return nil, errors.WithMessage(err, "parse date")
+		}
+		return value, nil
+	case "data":
+		value, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(text), ""))
+		if err != nil {
+			return nil, errors.WithMessage(err, "decode data")
+		}
+		return value, nil
+	}
+	return nil, errors.Errorf("unknown element %s", start.Name.Local)
+}
@@ -0,0 +1,103 @@
+package plist
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const xmlHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+`
+
+func TestDecodeXML(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want any
+	}{
+		{"string", `<string>a &amp; b</string>`, "a & b"},
+		{"empty string", `<string/>`, ""},
+		{"integer", `<integer> -42 </integer>`, int64(-42)},
+		{"large integer", `<integer>18446744073709551615</integer>`, uint64(18446744073709551615)},
+		{"real", `<real>1.5</real>`, 1.5},
+		{"true", `<true/>`, true},
+		{"false", `<false/>`, false},
+		{"date", `<date>2025-01-01T00:00:00Z</date>`, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"data", "<data>\n\taGVs\n\tbG8=\n</data>", []byte("hello")},
+		{"empty array", `<array/>`, []any{}},
+		{"empty dict", `<dict/>`, map[string]any{}},
+		{"dict", `<dict>
+	<key>CFBundleIdentifier</key><string>com.example.app</string>
+	<key>UIDeviceFamily</key><array><integer>1</integer><integer>2</integer></array>
+	<key>Entitlements</key><dict><key>get-task-allow</key><true/></dict>
+</dict>`, map[string]any{
+			"CFBundleIdentifier": "com.example.app",
+			"UIDeviceFamily":     []any{int64(1), int64(2)},
+			"Entitlements":       map[string]any{"get-task-allow": true},
+		}},
+		{"nested within limit", nestedArrays(maxXMLDepth), nestedValue(maxXMLDepth)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Decode([]byte(xmlHeader + `<plist version="1.0">` + tt.body + `</plist>`))
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeXMLInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"empty", ``},
+		{"garbage", `not a plist`},
+		{"no plist element", xmlHeader},
+		{"other root element", `<html><body/></html>`},
+		{"empty plist", `<plist version="1.0"></plist>`},
+		{"unclosed", `<plist version="1.0"><dict><key>a</key><string>b</string>`},
+		{"missing value", `<plist version="1.0"><dict><key>a</key></dict></plist>`},
+		{"value without key", `<plist version="1.0"><dict><string>a</string></dict></plist>`},
+		{"element in key", `<plist version="1.0"><dict><key><string>a</string></key><true/></dict></plist>`},
+		{"invalid integer", `<plist version="1.0"><integer>1.5</integer></plist>`},
+		{"invalid real", `<plist version="1.0"><real>one</real></plist>`},
+		{"invalid date", `<plist version="1.0"><date>yesterday</date></plist>`},
+		{"invalid data", `<plist version="1.0"><data>!!!</data></plist>`},
+		{"unknown element", `<plist version="1.0"><set/></plist>`},
+		{"nested too deep", `<plist version="1.0">` + nestedArrays(maxXMLDepth+1) + `</plist>`},
+		{"nested very deep", `<plist version="1.0">` + strings.Repeat("<array>", 100000) + `</plist>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := Decode([]byte(tt.data)); err == nil {
+				t.Errorf("Decode() = %#v, want error", got)
+			}
+		})
+	}
+}
+
+func TestDecodeDictNotDict(t *testing.T) {
+	if _, err := DecodeDict([]byte(`<plist version="1.0"><array/></plist>`)); err == nil {
+		t.Error("DecodeDict() of an array succeeded, want error")
+	}
+}
+
+// Returns an empty array nested in depth arrays.
+func nestedArrays(depth int) string {
+	return strings.Repeat("<array>", depth+1) + strings.Repeat("</array>", depth+1)
+}
+
+func nestedValue(depth int) any {
+	value := []any{}
+	for i := 0; i < depth; i++ {
+		value = []any{value}
+	}
+	return value
+}
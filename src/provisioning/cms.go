@@ -0,0 +1,191 @@
+package provisioning
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"github.com/pkg/errors"
+)
+
+// A BER encoded value. Provisioning profiles are signed with CMS using indefinite lengths,
+// which encoding/asn1 doesn't support, so they are read with this instead.
+type berValue struct {
+	class       int
+	tag         int
+	constructed bool
+	// The whole encoding, including the header.
+	raw []byte
+	// The contents of primitive values.
+	content  []byte
+	children []berValue
+}
+
+// Limits nesting, so that malicious input can't exhaust the stack.
+const maxBerDepth = 32
+
+func parseBer(data []byte, depth int) (berValue, []byte, error) {
+	if depth > maxBerDepth {
+		return berValue{}, nil, errors.New("too deeply nested")
+	}
+	if len(data) < 2 {
+		return berValue{}, nil, errors.New("truncated header")
+	}
+	value := berValue{
+		class:       int(data[0] >> 6),
+		constructed: data[0]&0x20 != 0,
+		tag:         int(data[0] & 0x1f),
+	}
+	offset := 1
+	if value.tag == 0x1f {
+		value.tag = 0
+		for {
+			if offset >= len(data) || offset > 4 {
+				return berValue{}, nil, errors.New("invalid tag")
+			}
+			b := data[offset]
+			offset++
+			value.tag = value.tag<<7 | int(b&0x7f)
+			if b&0x80 == 0 {
+				break
+			}
+		}
+	}
+	if offset >= len(data) {
+		return berValue{}, nil, errors.New("truncated header")
+	}
+	lengthByte := data[offset]
+	offset++
+	if lengthByte == 0x80 {
+		// indefinite length, the children end with two zero bytes
+		if !value.constructed {
+			return berValue{}, nil, errors.New("indefinite length of primitive value")
+		}
+		rest := data[offset:]
+		for {
+			if len(rest) >= 2 && rest[0] == 0 && rest[1] == 0 {
+				rest = rest[2:]
+				break
+			}
+			child, childRest, err := parseBer(rest, depth+1)
+			if err != nil {
+				return berValue{}, nil, err
+			}
+			value.children = append(value.children, child)
+			rest = childRest
+		}
+		value.raw = data[:len(data)-len(rest)]
+		return value, rest, nil
+	}
+	length := int(lengthByte)
+	if lengthByte&0x80 != 0 {
+		count := int(lengthByte & 0x7f)
+		if count > 4 || offset+count > len(data) {
+			return berValue{}, nil, errors.New("invalid length")
+		}
+		length = 0
+		for _, b := range data[offset : offset+count] {
+			length = length<<8 | int(b)
+		}
+		offset += count
+	}
+	if length < 0 || offset+length > len(data) {
+		return berValue{}, nil, errors.New("truncated value")
+	}
+	value.raw = data[:offset+length]
+	contents := data[offset : offset+length]
+	if !value.constructed {
+		value.content = contents
+		return value, data[offset+length:], nil
+	}
+	for len(contents) > 0 {
+		child, rest, err := parseBer(contents, depth+1)
+		if err != nil {
+			return berValue{}, nil, err
+		}
+		value.children = append(value.children, child)
+		contents = rest
+	}
+	return value, data[offset+length:], nil
+}
+
+// Returns the contents of an octet string, which may be split into several constructed parts.
+func (v berValue) octets() []byte {
+	if !v.constructed {
+		return v.content
+	}
+	var result []byte
+	for _, child := range v.children {
+		result = append(result, child.octets()...)
+	}
+	return result
+}
+
+func (v berValue) is(class int, tag int) bool {
+	return v.class == class && v.tag == tag
+}
+
+const (
+	classUniversal       = 0
+	classContextSpecific = 2
+	tagOctetString       = 4
+	tagOid               = 6
+	tagSequence          = 16
+)
+
+var oidSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+
+// Reads the signed content and certificates of a CMS SignedData message, without verifying the signature.
+// https://datatracker.ietf.org/doc/html/rfc5652#section-5
+func parseSignedData(data []byte) ([]byte, []*x509.Certificate, error) {
+	contentInfo, _, err := parseBer(data, 0)
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "parse ber")
+	}
+	if !contentInfo.is(classUniversal, tagSequence) || len(contentInfo.children) < 2 {
+		return nil, nil, errors.New("not a cms content info")
+	}
+	contentType := contentInfo.children[0]
+	var oid asn1.ObjectIdentifier
+	if !contentType.is(classUniversal, tagOid) {
+		return nil, nil, errors.New("missing content type")
+	}
+	if _, err := asn1.Unmarshal(contentType.raw, &oid); err != nil {
+		return nil, nil, errors.WithMessage(err, "parse content type")
+	}
+	if !oid.Equal(oidSignedData) {
+		return nil, nil, errors.Errorf("content type %s is not signed data", oid)
+	}
+	explicit := contentInfo.children[1]
+	if !explicit.is(classContextSpecific, 0) || len(explicit.children) < 1 {
+		return nil, nil, errors.New("missing signed data")
+	}
+	signedData := explicit.children[0]
+	// version, digest algorithms, encapsulated content info, then the optional certificates
+	if !signedData.is(classUniversal, tagSequence) || len(signedData.children) < 3 {
+		return nil, nil, errors.New("invalid signed data")
+	}
+	encapContentInfo := signedData.children[2]
+	if !encapContentInfo.is(classUniversal, tagSequence) || len(encapContentInfo.children) < 2 {
+		return nil, nil, errors.New("missing signed content")
+	}
+	eContent := encapContentInfo.children[1]
+	if !eContent.is(classContextSpecific, 0) || len(eContent.children) < 1 ||
+		!eContent.children[0].is(classUniversal, tagOctetString) {
+		return nil, nil, errors.New("invalid signed content")
+	}
+	content := eContent.children[0].octets()
+
+	var certificates []*x509.Certificate
+	for _, field := range signedData.children[3:] {
+		if !field.is(classContextSpecific, 0) {
+			continue
+		}
+		for _, certValue := range field.children {
+			cert, err := x509.ParseCertificate(certValue.raw)
+			if err != nil {
+				return nil, nil, errors.WithMessage(err, "parse signer certificate")
+			}
+			certificates = append(certificates, cert)
+		}
+	}
+	return content, certificates, nil
+}
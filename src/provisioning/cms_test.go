@@ -0,0 +1,42 @@
+package provisioning
+
+import (
+	"testing"
+)
+
+func TestParseSignedDataInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"garbage", []byte("this is not a provisioning profile")},
+		{"xml plist", []byte(`<?xml version="1.0"?><plist version="1.0"><dict><key>UUID</key><string>1</string></dict></plist>`)},
+		// a sequence holding the data oid, 1.2.840.113549.1.7.1
+		{"not signed data", []byte{0x30, 0x0d, 0x06, 0x09, 0x2a, 0x86, 0x48, 0x86, 0xf7, 0x0d, 0x01, 0x07, 0x01, 0xa0, 0x00}},
+		{"length past end", []byte{0x30, 0x84, 0x7f, 0xff, 0xff, 0xff, 0x00}},
+		{"indefinite primitive", []byte{0x04, 0x80, 0x00, 0x00}},
+		{"unterminated indefinite", []byte{0x30, 0x80, 0x02, 0x01, 0x01}},
+		{"long tag", []byte{0x1f, 0x81, 0x81, 0x81, 0x81, 0x81, 0x01, 0x00}},
+		{"deeply nested", nested(1000)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if content, _, err := parseSignedData(tt.data); err == nil {
+				t.Errorf("parseSignedData() = %q, want error", content)
+			}
+		})
+	}
+}
+
+// Returns sequences of indefinite length nested depth times.
+func nested(depth int) []byte {
+	var data []byte
+	for i := 0; i < depth; i++ {
+		data = append(data, 0x30, 0x80)
+	}
+	for i := 0; i < depth; i++ {
+		data = append(data, 0x00, 0x00)
+	}
+	return data
+}
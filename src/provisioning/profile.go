@@ -0,0 +1,123 @@
+// Package provisioning reads Apple provisioning profiles (.mobileprovision files).
+package provisioning
+
+import (
+	"LocalSignTools/src/plist"
+	"crypto/x509"
+	"github.com/pkg/errors"
+	"strings"
+	"time"
+)
+
+type Type string
+
+const (
+	// Installs on the provisioned devices, and allows debugging.
+	TypeDevelopment Type = "development"
+	// Installs on the provisioned devices.
+	TypeAdHoc Type = "ad-hoc"
+	// Installs on any device, for in-house distribution.
+	TypeEnterprise Type = "enterprise"
+	// Only for uploading to the App Store, doesn't install on devices directly.
+	TypeAppStore Type = "app-store"
+)
+
+type Profile struct {
+	Name      string `json:"name"`
+	UUID      string `json:"uuid"`
+	AppIdName string `json:"app_id_name"`
+	// The application-identifier entitlement, such as ABCDE12345.com.example.*
+	AppId          string         `json:"app_id"`
+	AppIdPrefixes  []string       `json:"app_id_prefixes"`
+	TeamId         string         `json:"team_id"`
+	TeamName       string         `json:"team_name"`
+	Type           Type           `json:"type"`
+	Platforms      []string       `json:"platforms"`
+	CreationDate   time.Time      `json:"creation_date"`
+	ExpirationDate time.Time      `json:"expiration_date"`
+	Entitlements   map[string]any `json:"entitlements"`
+	// The UDIDs of the devices the profile installs on. Empty for enterprise and App Store profiles.
+	Devices []string `json:"devices"`
+	// The certificates that may sign apps with the profile.
+	DeveloperCertificates []*x509.Certificate `json:"-"`
+	// The certificates that signed the profile itself, normally Apple's.
+	SignerCertificates []*x509.Certificate `json:"-"`
+}
+
+// Parse reads a provisioning profile. The signature of the profile isn't verified.
+func Parse(data []byte) (*Profile, error) {
+	content, signers, err := parseSignedData(data)
+	if err != nil {
+		return nil, errors.WithMessage(err, "parse cms")
+	}
+	dict, err := plist.DecodeDict(content)
+	if err != nil {
+		return nil, errors.WithMessage(err, "decode plist")
+	}
+	return fromDict(dict, signers)
+}
+
+func fromDict(dict plist.Dict, signers []*x509.Certificate) (*Profile, error) {
+	entitlements := dict.Dict("Entitlements")
+	p := &Profile{
+		Name:               dict.String("Name"),
+		UUID:               dict.String("UUID"),
+		AppIdName:          dict.String("AppIDName"),
+		AppId:              entitlements.String("application-identifier"),
+		AppIdPrefixes:      dict.Strings("ApplicationIdentifierPrefix"),
+		TeamName:           dict.String("TeamName"),
+		Platforms:          dict.Strings("Platform"),
+		CreationDate:       dict.Time("CreationDate"),
+		ExpirationDate:     dict.Time("ExpirationDate"),
+		Entitlements:       entitlements,
+		Devices:            dict.Strings("ProvisionedDevices"),
+		SignerCertificates: signers,
+	}
+	if p.UUID == "" {
+		return nil, errors.New("missing UUID, not a provisioning profile")
+	}
+	if teamIds := dict.Strings("TeamIdentifier"); len(teamIds) > 0 {
+		p.TeamId = teamIds[0]
+	}
+	if p.AppId == "" {
+		// macOS profiles use a prefixed key
+		p.AppId = entitlements.String("com.apple.application-identifier")
+	}
+	switch {
+	case dict.Bool("ProvisionsAllDevices"):
+		p.Type = TypeEnterprise
+	case len(p.Devices) > 0 && entitlements.Bool("get-task-allow"):
+		p.Type = TypeDevelopment
+	case len(p.Devices) > 0:
+		p.Type = TypeAdHoc
+	default:
+		p.Type = TypeAppStore
+	}
+	for i, item := range dict.Array("DeveloperCertificates") {
+		certBytes, ok := item.([]byte)
+		if !ok {
+			return nil, errors.Errorf("developer certificate %d is not data", i)
+		}
+		cert, err := x509.ParseCertificate(certBytes)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "parse developer certificate %d", i)
+		}
+		p.DeveloperCertificates = append(p.DeveloperCertificates, cert)
+	}
+	return p, nil
+}
+
+// BundleIdPattern returns the bundle ids the profile can sign, without the app id prefix, such as com.example.*
+func (p *Profile) BundleIdPattern() string {
+	for _, prefix := range append(p.AppIdPrefixes, p.TeamId) {
+		if prefix != "" && strings.HasPrefix(p.AppId, prefix+".") {
+			return strings.TrimPrefix(p.AppId, prefix+".")
+		}
+	}
+	return p.AppId
+}
+
+// IsExpired returns whether the profile has expired at the given time.
+func (p *Profile) IsExpired(now time.Time) bool {
+	return !p.ExpirationDate.IsZero() && now.After(p.ExpirationDate)
+}
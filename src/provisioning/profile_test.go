@@ -0,0 +1,70 @@
+package provisioning
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		file string
+	}{
+		// indefinite lengths, like the profiles Apple generates
+		{"development.mobileprovision"},
+		{"development_der.mobileprovision"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, err := Parse(data)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if p.Name != "Test Profile" || p.UUID != "1234-5678" || p.AppIdName != "Test App" {
+				t.Errorf("Parse() name = %q, uuid = %q, app id name = %q", p.Name, p.UUID, p.AppIdName)
+			}
+			if p.TeamId != "TEAM123456" || p.TeamName != "Test Team" {
+				t.Errorf("Parse() team id = %q, team name = %q", p.TeamId, p.TeamName)
+			}
+			if p.Type != TypeDevelopment {
+				t.Errorf("Parse() type = %q, want %q", p.Type, TypeDevelopment)
+			}
+			if got := p.BundleIdPattern(); got != "com.example.*" {
+				t.Errorf("BundleIdPattern() = %q, want %q", got, "com.example.*")
+			}
+			if len(p.Devices) != 2 {
+				t.Errorf("Parse() devices = %v, want 2", p.Devices)
+			}
+			if len(p.DeveloperCertificates) != 1 || len(p.SignerCertificates) != 1 {
+				t.Errorf("Parse() developer certificates = %d, signer certificates = %d, want 1 each",
+					len(p.DeveloperCertificates), len(p.SignerCertificates))
+			}
+			expiration := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+			if !p.ExpirationDate.Equal(expiration) {
+				t.Errorf("Parse() expiration date = %v, want %v", p.ExpirationDate, expiration)
+			}
+			if p.IsExpired(expiration.Add(-time.Second)) || !p.IsExpired(expiration.Add(time.Second)) {
+				t.Errorf("IsExpired() is wrong around %v", expiration)
+			}
+		})
+	}
+}
+
+func TestParseTruncated(t *testing.T) {
+	for _, file := range []string{"development.mobileprovision", "development_der.mobileprovision"} {
+		data, err := os.ReadFile(filepath.Join("testdata", file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for size := 0; size < len(data); size++ {
+			if _, err := Parse(data[:size]); err == nil {
+				t.Fatalf("Parse() of the first %d bytes of %s succeeded, want error", size, file)
+			}
+		}
+	}
+}
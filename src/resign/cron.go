@@ -0,0 +1,161 @@
+package resign
+
+import (
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a cron expression with the five standard fields: minute, hour, day of month, month and day of week.
+// Fields may be *, numbers, ranges and lists, each with an optional step, such as "0 3 * * 1-5" or "*/30 * * * *".
+// Months and days of the week may also be written as their first three letters, and a few macros like @daily work too.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	// Cron matches either day field if both are restricted, and only the restricted one otherwise.
+	domAny, dowAny bool
+}
+
+var scheduleMacros = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+}
+
+var monthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+var dayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+type scheduleField struct {
+	name     string
+	min, max int
+	// Names for the values starting at min.
+	names []string
+}
+
+var scheduleFields = []scheduleField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: monthNames},
+	// 7 is Sunday too
+	{name: "day of week", min: 0, max: 7, names: dayNames},
+}
+
+// ParseSchedule parses a cron expression, see Schedule.
+func ParseSchedule(expr string) (*Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if macro, ok := scheduleMacros[strings.ToLower(expr)]; ok {
+		expr = macro
+	}
+	parts := strings.Fields(expr)
+	if len(parts) != len(scheduleFields) {
+		return nil, errors.Errorf("expected %d fields, got %d", len(scheduleFields), len(parts))
+	}
+	var bits [5]uint64
+	for i, field := range scheduleFields {
+		var err error
+		if bits[i], err = field.parse(parts[i]); err != nil {
+			return nil, errors.WithMessagef(err, "parse %s", field.name)
+		}
+	}
+	s := &Schedule{minute: bits[0], hour: bits[1], dom: bits[2], month: bits[3], dow: bits[4]}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domAny = strings.HasPrefix(parts[2], "*")
+	s.dowAny = strings.HasPrefix(parts[4], "*")
+	return s, nil
+}
+
+func (f *scheduleField) parse(str string) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(str, ",") {
+		rangeStr, stepStr, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepStr); err != nil || step < 1 {
+				return 0, errors.Errorf("invalid step %q", stepStr)
+			}
+		}
+		start, end := f.min, f.max
+		if rangeStr != "*" {
+			startStr, endStr, isRange := strings.Cut(rangeStr, "-")
+			var err error
+			if start, err = f.parseValue(startStr); err != nil {
+				return 0, err
+			}
+			end = start
+			if isRange {
+				if end, err = f.parseValue(endStr); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				end = f.max
+			}
+			if end < start {
+				return 0, errors.Errorf("invalid range %q", rangeStr)
+			}
+		}
+		for value := start; value <= end; value += step {
+			bits |= 1 << value
+		}
+	}
+	return bits, nil
+}
+
+func (f *scheduleField) parseValue(str string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(str, name) {
+			return f.min + i, nil
+		}
+	}
+	value, err := strconv.Atoi(str)
+	if err != nil || value < f.min || value > f.max {
+		return 0, errors.Errorf("invalid value %q, must be %d-%d", str, f.min, f.max)
+	}
+	return value, nil
+}
+
+func (s *Schedule) matchesDay(t time.Time) bool {
+	if s.month&(1<<int(t.Month())) == 0 {
+		return false
+	}
+	domMatch := s.dom&(1<<t.Day()) != 0
+	dowMatch := s.dow&(1<<int(t.Weekday())) != 0
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dowMatch
+	case s.dowAny:
+		return domMatch
+	default:
+		return domMatch || dowMatch
+	}
+}
+
+// Next returns the first time after t that matches the schedule, or the zero time if none does within 5 years,
+// like for February 30th.
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		year, month, day := t.Date()
+		switch {
+		case !s.matchesDay(t):
+			t = time.Date(year, month, day+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<t.Hour()) == 0:
+			t = time.Date(year, month, day, t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
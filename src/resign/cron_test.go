@@ -0,0 +1,123 @@
+package resign
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		expr string
+		// an equivalent expression, or empty if it has none
+		same    string
+		wantErr bool
+	}{
+		{expr: "* * * * *"},
+		{expr: "0 3 * * 1-5"},
+		{expr: "  0 3 * * *  ", same: "0 3 * * *"},
+		{expr: "*/20 * * * *", same: "0,20,40 * * * *"},
+		{expr: "5/20 * * * *", same: "5,25,45 * * * *"},
+		{expr: "0 8-18/4 * * *", same: "0 8,12,16 * * *"},
+		{expr: "0 0 * jan-mar mon-fri", same: "0 0 * 1-3 1-5"},
+		{expr: "0 0 * DEC Sun", same: "0 0 * 12 0"},
+		{expr: "@daily", same: "0 0 * * *"},
+		{expr: "@DAILY", same: "0 0 * * *"},
+		{expr: "@midnight", same: "0 0 * * *"},
+		{expr: "@hourly", same: "0 * * * *"},
+		{expr: "@weekly", same: "0 0 * * 0"},
+		{expr: "@monthly", same: "0 0 1 * *"},
+		{expr: "@yearly", same: "0 0 1 1 *"},
+		{expr: "@annually", same: "0 0 1 1 *"},
+		{expr: "", wantErr: true},
+		{expr: "@reboot", wantErr: true},
+		{expr: "0 3 * *", wantErr: true},
+		{expr: "0 3 * * * *", wantErr: true},
+		{expr: "60 * * * *", wantErr: true},
+		{expr: "0 24 * * *", wantErr: true},
+		{expr: "0 0 0 * *", wantErr: true},
+		{expr: "0 0 32 * *", wantErr: true},
+		{expr: "0 0 * 0 *", wantErr: true},
+		{expr: "0 0 * 13 *", wantErr: true},
+		{expr: "0 0 * * 8", wantErr: true},
+		{expr: "-1 * * * *", wantErr: true},
+		{expr: "5-1 * * * *", wantErr: true},
+		{expr: "1- * * * *", wantErr: true},
+		{expr: "*/0 * * * *", wantErr: true},
+		{expr: "*/x * * * *", wantErr: true},
+		{expr: "1,,2 * * * *", wantErr: true},
+		{expr: "0 0 * foo *", wantErr: true},
+		{expr: "0 0 * * sunday", wantErr: true},
+		// names are only known in their own field
+		{expr: "0 0 * mon *", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := ParseSchedule(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSchedule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.same == "" {
+				return
+			}
+			want, err := ParseSchedule(tt.same)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("ParseSchedule() = %+v, want the same as %q, %+v", got, tt.same, want)
+			}
+		})
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	date := func(year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, time.UTC)
+	}
+	// 2024-01-01 is a Monday
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"every minute", "* * * * *", time.Date(2024, 1, 1, 10, 7, 30, 0, time.UTC), date(2024, 1, 1, 10, 8)},
+		{"step", "*/15 * * * *", time.Date(2024, 1, 1, 10, 7, 30, 0, time.UTC), date(2024, 1, 1, 10, 15)},
+		{"step from a start", "5/20 * * * *", date(2024, 1, 1, 10, 26), date(2024, 1, 1, 10, 45)},
+		{"range with step", "0 8-18/4 * * *", date(2024, 1, 1, 12, 30), date(2024, 1, 1, 16, 0)},
+		{"list", "0,30 9,17 * * *", date(2024, 1, 1, 9, 45), date(2024, 1, 1, 17, 0)},
+		{"later today", "0 3 * * *", time.Date(2024, 1, 1, 2, 59, 59, 0, time.UTC), date(2024, 1, 1, 3, 0)},
+		{"strictly after", "0 3 * * *", date(2024, 1, 1, 3, 0), date(2024, 1, 2, 3, 0)},
+		{"next year", "0 0 1 * *", date(2024, 12, 31, 23, 59), date(2025, 1, 1, 0, 0)},
+		{"weekdays from friday", "30 2 * * 1-5", date(2024, 1, 5, 3, 0), date(2024, 1, 8, 2, 30)},
+		{"7 is sunday", "0 0 * * 7", date(2024, 1, 1, 0, 0), date(2024, 1, 7, 0, 0)},
+		{"0 is sunday", "0 0 * * 0", date(2024, 1, 1, 0, 0), date(2024, 1, 7, 0, 0)},
+		{"range to 7", "0 0 * * 6-7", date(2024, 1, 6, 12, 0), date(2024, 1, 7, 0, 0)},
+		{"day name", "0 0 * * sun", date(2024, 1, 1, 0, 0), date(2024, 1, 7, 0, 0)},
+		{"month and day names", "0 12 * jun mon", date(2024, 1, 1, 0, 0), date(2024, 6, 3, 12, 0)},
+		{"only day of month", "0 0 13 * *", date(2024, 1, 1, 0, 0), date(2024, 1, 13, 0, 0)},
+		{"only day of week", "0 0 * * fri", date(2024, 1, 1, 0, 0), date(2024, 1, 5, 0, 0)},
+		{"day of week before day of month", "0 0 13 * fri", date(2024, 1, 1, 0, 0), date(2024, 1, 5, 0, 0)},
+		{"day of month before day of week", "0 0 13 * fri", date(2024, 1, 12, 0, 0), date(2024, 1, 13, 0, 0)},
+		{"stepped day of month is any", "0 0 */1 * fri", date(2024, 1, 1, 0, 0), date(2024, 1, 5, 0, 0)},
+		{"hourly", "@hourly", date(2024, 1, 1, 10, 0), date(2024, 1, 1, 11, 0)},
+		{"weekly", "@weekly", date(2024, 1, 1, 0, 0), date(2024, 1, 7, 0, 0)},
+		{"monthly", "@monthly", date(2024, 1, 15, 8, 0), date(2024, 2, 1, 0, 0)},
+		{"yearly", "@yearly", date(2024, 3, 1, 0, 0), date(2025, 1, 1, 0, 0)},
+		{"leap day", "0 0 29 2 *", date(2024, 3, 1, 0, 0), date(2028, 2, 29, 0, 0)},
+		{"february 30th", "0 0 30 2 *", date(2024, 1, 1, 0, 0), time.Time{}},
+		{"april 31st", "0 0 31 4 *", date(2024, 1, 1, 0, 0), time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := ParseSchedule(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseSchedule() error = %v", err)
+			}
+			if got := s.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Next(%v) = %v, want %v", tt.from, got, tt.want)
+			}
+		})
+	}
+}
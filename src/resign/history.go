@@ -0,0 +1,84 @@
+package resign
+
+import (
+	"LocalSignTools/src/config"
+	"bytes"
+	"encoding/json"
+	"github.com/natefinch/atomic"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type Reason string
+
+const (
+	ReasonExpiry   Reason = "expiry"
+	ReasonSchedule Reason = "schedule"
+)
+
+// The oldest records are dropped once there are more than this many.
+const maxHistory = 500
+
+// Record is an automatic re-sign.
+type Record struct {
+	Time      time.Time `json:"time"`
+	AppId     string    `json:"app_id"`
+	AppName   string    `json:"app_name"`
+	ProfileId string    `json:"profile_id"`
+	Reason    Reason    `json:"reason"`
+	// When the previous signature expires, for re-signs before the expiry.
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+	// The cron expression, for scheduled re-signs.
+	Schedule string `json:"schedule,omitempty"`
+	// Set if the re-sign couldn't be queued.
+	Error string `json:"error,omitempty"`
+}
+
+// Serializes read-modify-write cycles of the history file.
+var historyMu sync.Mutex
+
+func getHistoryPath() string {
+	return filepath.Join(config.Current.File().SaveDir, "resign_history.json")
+}
+
+// GetHistory returns the automatic re-signs, oldest first.
+func GetHistory() ([]Record, error) {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	return readHistory()
+}
+
+func readHistory() ([]Record, error) {
+	data, err := os.ReadFile(getHistoryPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.WithMessage(err, "read resign history")
+	}
+	var history []Record
+	if err := json.Unmarshal(data, &history); err != nil {
+		return nil, errors.WithMessage(err, "unmarshal resign history")
+	}
+	return history, nil
+}
+
+func addHistory(record Record) error {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	history, err := readHistory()
+	if err != nil {
+		return err
+	}
+	history = append(history, record)
+	if len(history) > maxHistory {
+		history = history[len(history)-maxHistory:]
+	}
+	data, err := json.Marshal(history)
+	if err != nil {
+		return errors.WithMessage(err, "marshal resign history")
+	}
+	return atomic.WriteFile(getHistoryPath(), bytes.NewReader(data))
+}
@@ -0,0 +1,340 @@
+// Package resign re-signs apps automatically, shortly before their signature expires or on a cron schedule,
+// which saves re-signing every app by hand when using profiles that only last a week.
+package resign
+
+import (
+	"LocalSignTools/src/config"
+	"LocalSignTools/src/provisioning"
+	"LocalSignTools/src/storage"
+	"archive/zip"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often the apps are checked, which is the resolution of cron schedules.
+const checkInterval = time.Minute
+
+// How long to wait before retrying a re-sign before expiry that couldn't be queued.
+const retryInterval = 15 * time.Minute
+
+// Provisioning profiles are a few KB, anything much larger isn't one.
+const maxEmbeddedProvSize = 4 * 1024 * 1024
+
+// SignFunc queues a re-sign of an app.
+type SignFunc func(app storage.App) error
+
+// Plan describes how and when an app is re-signed automatically.
+type Plan struct {
+	Settings storage.ResignSettings `json:"settings"`
+	// Whether the settings are those of the app's profile, as the app has none of its own.
+	FromProfile bool `json:"from_profile"`
+	// When the signature of the signed app expires, whichever is first of its provisioning profile and certificate.
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+	// When the next automatic re-sign is due, or zero if never.
+	NextResign time.Time `json:"next_resign,omitzero"`
+	NextReason Reason    `json:"next_reason,omitempty"`
+}
+
+// GetSettings returns the re-sign settings of an app, or those of its profile if the app has none.
+func GetSettings(app storage.App) (settings storage.ResignSettings, fromProfile bool, err error) {
+	appSettings, err := storage.GetResignSettings(app)
+	if err != nil {
+		return settings, false, err
+	} else if appSettings != nil {
+		return *appSettings, false, nil
+	}
+	profileId, err := app.GetString(storage.AppProfileId)
+	if err != nil {
+		return settings, false, errors.WithMessage(err, "get profile id")
+	}
+	profile, ok := storage.Profiles.GetById(profileId)
+	if !ok || storage.IsProfileReadOnly(profile) {
+		return settings, true, nil
+	}
+	profileSettings, err := storage.GetResignSettings(profile)
+	if err != nil || profileSettings == nil {
+		return settings, true, err
+	}
+	return *profileSettings, true, nil
+}
+
+// GetPlan returns when an app is re-signed next, if at all.
+func GetPlan(app storage.App, now time.Time) (*Plan, error) {
+	settings, fromProfile, err := GetSettings(app)
+	if err != nil {
+		return nil, err
+	}
+	plan := &Plan{Settings: settings, FromProfile: fromProfile}
+	if signed, err := app.IsSigned(); err != nil {
+		return nil, errors.WithMessage(err, "get is signed")
+	} else if signed {
+		if plan.ExpiresAt, err = GetSignedExpiry(app); err != nil {
+			return nil, err
+		}
+	}
+	if settings.BeforeExpiry && !plan.ExpiresAt.IsZero() {
+		plan.NextResign = getResignTime(plan.ExpiresAt)
+		plan.NextReason = ReasonExpiry
+	}
+	if settings.Schedule != "" {
+		schedule, err := ParseSchedule(settings.Schedule)
+		if err != nil {
+			return nil, errors.WithMessage(err, "parse schedule")
+		}
+		if next := schedule.Next(now); !next.IsZero() && (plan.NextResign.IsZero() || next.Before(plan.NextResign)) {
+			plan.NextResign = next
+			plan.NextReason = ReasonSchedule
+		}
+	}
+	return plan, nil
+}
+
+func getResignTime(expiresAt time.Time) time.Time {
+	return expiresAt.Add(-time.Duration(config.Current.File().Resign.DaysBeforeExpiry) * 24 * time.Hour)
+}
+
+// The expiry of the provisioning profile embedded in a signed file, which only changes with the file.
+type embeddedExpiry struct {
+	modTime   time.Time
+	expiresAt time.Time
+}
+
+var (
+	embeddedExpiryMu sync.Mutex
+	embeddedExpiries = map[string]embeddedExpiry{}
+)
+
+// GetSignedExpiry returns when the signature of a signed app expires, or the zero time if that's unknown.
+// It is the earliest of the expiry of the provisioning profile embedded in the app, which is the only way
+// to know it for account profiles, and that of the signing certificates of the app's profile.
+func GetSignedExpiry(app storage.App) (time.Time, error) {
+	expiresAt, err := getEmbeddedExpiry(app)
+	if err != nil {
+		return time.Time{}, err
+	}
+	profileId, _ := app.GetString(storage.AppProfileId)
+	profile, ok := storage.Profiles.GetById(profileId)
+	if !ok {
+		return expiresAt, nil
+	}
+	if prov := profile.GetProvisioningProfile(); expiresAt.IsZero() && prov != nil {
+		expiresAt = prov.ExpirationDate
+	}
+	for _, cert := range profile.GetCertificates() {
+		if expiresAt.IsZero() || cert.NotAfter.Before(expiresAt) {
+			expiresAt = cert.NotAfter
+		}
+	}
+	return expiresAt, nil
+}
+
+func getEmbeddedExpiry(app storage.App) (time.Time, error) {
+	file, err := app.GetFile(storage.AppSignedFile)
+	if err != nil {
+		return time.Time{}, errors.WithMessagef(err, "get %s", storage.AppSignedFile)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return time.Time{}, errors.WithMessagef(err, "stat %s", storage.AppSignedFile)
+	}
+	embeddedExpiryMu.Lock()
+	cached, ok := embeddedExpiries[app.GetId()]
+	embeddedExpiryMu.Unlock()
+	if ok && cached.modTime.Equal(info.ModTime()) {
+		return cached.expiresAt, nil
+	}
+	var expiresAt time.Time
+	if prov, err := readEmbeddedProvisioningProfile(file, info.Size()); err != nil {
+		log.Warn().Err(err).Str("app_id", app.GetId()).Msg("read embedded provisioning profile")
+	} else if prov != nil {
+		expiresAt = prov.ExpirationDate
+	}
+	embeddedExpiryMu.Lock()
+	embeddedExpiries[app.GetId()] = embeddedExpiry{modTime: info.ModTime(), expiresAt: expiresAt}
+	embeddedExpiryMu.Unlock()
+	return expiresAt, nil
+}
+
+// Returns the provisioning profile of the main app of an ipa, or nil if it has none.
+func readEmbeddedProvisioningProfile(file io.ReaderAt, size int64) (*provisioning.Profile, error) {
+	reader, err := zip.NewReader(file, size)
+	if err != nil {
+		return nil, errors.WithMessage(err, "open ipa")
+	}
+	for _, f := range reader.File {
+		// Payload/Name.app/embedded.mobileprovision, not those of extensions or nested apps
+		parts := strings.Split(f.Name, "/")
+		if len(parts) != 3 || parts[0] != "Payload" || path.Ext(parts[1]) != ".app" || parts[2] != "embedded.mobileprovision" {
+			continue
+		}
+		if f.UncompressedSize64 > maxEmbeddedProvSize {
+			return nil, errors.Errorf("%s is too large", f.Name)
+		}
+		r, err := f.Open()
+		if err != nil {
+			return nil, errors.WithMessagef(err, "open %s", f.Name)
+		}
+		data, err := io.ReadAll(io.LimitReader(r, maxEmbeddedProvSize))
+		r.Close()
+		if err != nil {
+			return nil, errors.WithMessagef(err, "read %s", f.Name)
+		}
+		return provisioning.Parse(data)
+	}
+	return nil, nil
+}
+
+type scheduledResign struct {
+	expr     string
+	schedule *Schedule
+	at       time.Time
+}
+
+type scheduler struct {
+	sign SignFunc
+	// The next scheduled re-sign of every app with a schedule.
+	scheduled map[string]scheduledResign
+	// The expiry that every app was last re-signed for, so that re-signs which didn't renew it aren't repeated.
+	resignedFor map[string]time.Time
+	// When the apps whose re-sign before expiry couldn't be queued are tried again.
+	retryAt map[string]time.Time
+}
+
+// Start checks the apps every minute until stop is closed, calling sign for the ones due to be re-signed.
+// Scheduled re-signs missed while the server wasn't running are skipped.
+func Start(stop <-chan bool, sign SignFunc) {
+	s := &scheduler{
+		sign:        sign,
+		scheduled:   map[string]scheduledResign{},
+		resignedFor: map[string]time.Time{},
+		retryAt:     map[string]time.Time{},
+	}
+	if history, err := GetHistory(); err != nil {
+		log.Err(err).Msg("get resign history")
+	} else {
+		for _, record := range history {
+			// failed re-signs are tried again
+			if record.Reason == ReasonExpiry && record.Error == "" {
+				s.resignedFor[record.AppId] = record.ExpiresAt
+			}
+		}
+	}
+	go func() {
+		for {
+			s.check(time.Now())
+			select {
+			case <-time.After(checkInterval):
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func (s *scheduler) check(now time.Time) {
+	apps, err := storage.Apps.GetAll()
+	if err != nil {
+		log.Err(err).Msg("get apps")
+		return
+	}
+	seen := map[string]bool{}
+	for _, app := range apps {
+		seen[app.GetId()] = true
+		record, due, err := s.getDue(app, now)
+		if err != nil {
+			log.Err(err).Str("app_id", app.GetId()).Msg("check auto resign")
+			continue
+		}
+		if !due {
+			continue
+		}
+		record.Time = now
+		record.AppId = app.GetId()
+		record.AppName, _ = app.GetString(storage.AppName)
+		record.ProfileId, _ = app.GetString(storage.AppProfileId)
+		logger := log.Info()
+		if err := s.sign(app); err != nil {
+			record.Error = err.Error()
+			logger = log.Err(err)
+			if record.Reason == ReasonExpiry {
+				s.retryAt[app.GetId()] = now.Add(retryInterval)
+			}
+		} else if record.Reason == ReasonExpiry {
+			s.resignedFor[app.GetId()] = record.ExpiresAt
+			delete(s.retryAt, app.GetId())
+		}
+		logger.Str("app_id", app.GetId()).Str("reason", string(record.Reason)).Msg("auto resign")
+		if err := addHistory(record); err != nil {
+			log.Err(err).Msg("add resign history")
+		}
+	}
+	for appId := range s.scheduled {
+		if !seen[appId] {
+			delete(s.scheduled, appId)
+		}
+	}
+	for appId := range s.resignedFor {
+		if !seen[appId] {
+			delete(s.resignedFor, appId)
+		}
+	}
+	for appId := range s.retryAt {
+		if !seen[appId] {
+			delete(s.retryAt, appId)
+		}
+	}
+}
+
+// Returns whether an app is due to be re-signed, and why.
+func (s *scheduler) getDue(app storage.App, now time.Time) (Record, bool, error) {
+	settings, _, err := GetSettings(app)
+	if err != nil {
+		return Record{}, false, err
+	}
+	// a queued or running job will sign the app anyway, and a queued one would lose its place and priority
+	pending, running := storage.Jobs.GetStatusByAppId(app.GetId())
+	busy := pending || running
+	if settings.Schedule == "" {
+		delete(s.scheduled, app.GetId())
+	} else {
+		scheduled, ok := s.scheduled[app.GetId()]
+		if !ok || scheduled.expr != settings.Schedule {
+			schedule, err := ParseSchedule(settings.Schedule)
+			if err != nil {
+				return Record{}, false, errors.WithMessage(err, "parse schedule")
+			}
+			scheduled = scheduledResign{expr: settings.Schedule, schedule: schedule, at: schedule.Next(now)}
+			s.scheduled[app.GetId()] = scheduled
+		}
+		if !scheduled.at.IsZero() && !now.Before(scheduled.at) {
+			scheduled.at = scheduled.schedule.Next(now)
+			s.scheduled[app.GetId()] = scheduled
+			if !busy {
+				return Record{Reason: ReasonSchedule, Schedule: settings.Schedule}, true, nil
+			}
+		}
+	}
+	if !settings.BeforeExpiry || busy {
+		return Record{}, false, nil
+	}
+	if signed, err := app.IsSigned(); err != nil || !signed {
+		return Record{}, false, err
+	}
+	expiresAt, err := GetSignedExpiry(app)
+	if err != nil || expiresAt.IsZero() || now.Before(getResignTime(expiresAt)) {
+		return Record{}, false, err
+	}
+	if resignedFor, ok := s.resignedFor[app.GetId()]; ok && resignedFor.Equal(expiresAt) {
+		return Record{}, false, nil
+	}
+	if retryAt, ok := s.retryAt[app.GetId()]; ok && now.Before(retryAt) {
+		return Record{}, false, nil
+	}
+	return Record{Reason: ReasonExpiry, ExpiresAt: expiresAt}, true, nil
+}
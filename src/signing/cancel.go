@@ -0,0 +1,44 @@
+package signing
+
+import (
+	"LocalSignTools/src/config"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// CancelRemoteJob asks the running server to cancel the queued or running sign job of an app.
+func CancelRemoteJob(appId string) error {
+	cancelUrl, err := url.JoinPath(strings.TrimRight(config.Current.File().ServerUrl, "/"), "apps", appId, "cancel")
+	if err != nil {
+		return errors.WithMessage(err, "make cancel url")
+	}
+	req, err := http.NewRequest(http.MethodGet, cancelUrl, nil)
+	if err != nil {
+		return errors.WithMessage(err, "make cancel request")
+	}
+	if basicAuth := config.Current.File().BasicAuth; basicAuth.Enable {
+		req.SetBasicAuth(basicAuth.Username, basicAuth.Password)
+	}
+	client := &http.Client{
+		// the server redirects to the index page on success
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.WithMessage(err, "send cancel request")
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return errors.Errorf("no queued or running job for app %s", appId)
+	case resp.StatusCode >= 400:
+		return errors.Errorf("cancel request failed: %s", resp.Status)
+	}
+	log.Info().Str("app_id", appId).Msg("cancelled job")
+	return nil
+}
@@ -3,6 +3,7 @@ package signing
 import (
 	"LocalSignTools/src/builders"
 	"LocalSignTools/src/config"
+	"LocalSignTools/src/events"
 	"LocalSignTools/src/storage"
 	"bufio"
 	"fmt"
@@ -10,6 +11,7 @@ import (
 	"github.com/rs/zerolog/log"
 	"io"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
 	"time"
@@ -128,7 +130,9 @@ func performSynchronousSigning(app storage.App, builder builders.Builder) error
 	appId := app.GetId()
 	
 	// Create a job for tracking
-	storage.Jobs.MakeSignJob(appId, profileId)
+	if err := storage.Jobs.MakeSignJob(appId, profileId, storage.JobPriorityInteractive); err != nil {
+		return errors.WithMessage(err, "make sign job")
+	}
 	log.Info().Str("app_id", appId).Msg("created signing job")
 
 	// Set builder secrets
@@ -153,13 +157,26 @@ func processIntegratedJobDirectly(app storage.App, integrated *builders.Integrat
 	jobAdapter := &storage.JobStorageAdapter{}
 	appAdapter := &storage.AppStorageAdapter{}
 	
-	processFn := func() error {
+	processFn := func() (bool, error) {
 		return builders.ProcessIntegratedJob(integrated, jobAdapter, appAdapter, storage.ErrNotFound)
 	}
 	
 	// Set the process function (this will start the worker if not already started)
 	integrated.SetProcessJobFn(processFn)
 
+	// Follow the job's state changes instead of polling the job storage
+	eventChan, unsubscribe := events.Subscribe(func(event events.Event) bool {
+		return event.AppId == appId && event.Type.IsJobState()
+	})
+	defer unsubscribe()
+
+	// The sign script runs in its own process group, so Ctrl+C has to be passed on by cancelling the job.
+	// A second Ctrl+C exits immediately.
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	var returnJobId string
+
 	// Trigger the builder to start processing
 	if err := integrated.Trigger(); err != nil {
 		return errors.WithMessage(err, "trigger builder")
@@ -167,61 +184,58 @@ func processIntegratedJobDirectly(app storage.App, integrated *builders.Integrat
 
 	log.Info().Str("app_id", appId).Msg("triggered builder, waiting for completion")
 
-	// Wait for the job to be processed
-	maxWaitTime := integrated.GetJobTimeout()
-	pollInterval := 500 * time.Millisecond
-	checkInterval := 2 * time.Second // Check for 2FA prompt every 2 seconds
-	startTime := time.Now()
-	var returnJobId string
-	last2FACheck := time.Now()
-	twoFAMessageShown := false // Track if 2FA message has already been shown
-
-	for time.Since(startTime) < maxWaitTime {
-		// Check if return job exists (created when TakeLastJob is called)
-		if job, ok := storage.Jobs.GetByAppId(appId); ok {
-			if returnJobId == "" {
-				returnJobId = job.Id
-				log.Info().Str("return_job_id", returnJobId).Msg("return job created, waiting for processing")
-			}
-			
-			// Check if 2FA is needed (periodically check)
-			if time.Since(last2FACheck) >= checkInterval {
-				twoFactorCode := job.TwoFactorCode.Load()
-				if twoFactorCode == "" {
-					// Show 2FA message only once after a short delay
-					if !twoFAMessageShown && time.Since(startTime) > 5*time.Second {
-						log.Info().Msg("If 2FA code is required, you will be prompted by fastlane.")
-						twoFAMessageShown = true
-					}
-				} else {
-					if !twoFAMessageShown {
-						log.Info().Str("return_job_id", returnJobId).Msg("2FA code provided, waiting for processing")
-						twoFAMessageShown = true
-					}
+	timeout := time.NewTimer(integrated.GetJobTimeout())
+	defer timeout.Stop()
+	// Show the 2FA message only once, after a short delay
+	twoFAHint := time.NewTimer(5 * time.Second)
+	defer twoFAHint.Stop()
+
+	for {
+		select {
+		case event := <-eventChan:
+			switch event.Type {
+			case events.JobStarted:
+				returnJobId = event.JobId
+				timeout.Reset(integrated.GetJobTimeout())
+				log.Info().Str("return_job_id", event.JobId).Msg("return job created, waiting for processing")
+			case events.JobRetrying:
+				returnJobId = ""
+				// the retry is bounded by the backoff, so there's nothing to time out until it starts
+				timeout.Stop()
+				log.Warn().Str("reason", event.Error).Msg("signing attempt failed, retrying")
+			case events.JobSucceeded:
+				signed, err := app.IsSigned()
+				if err != nil {
+					return errors.WithMessage(err, "check signed status")
+				}
+				if !signed {
+					return errors.New("job completed but app is not signed")
 				}
-				last2FACheck = time.Now()
-			}
-		} else {
-			// Return job was deleted, check if app is signed
-			signed, err := app.IsSigned()
-			if err != nil {
-				return errors.WithMessage(err, "check signed status")
-			}
-			if signed {
 				log.Info().Str("app_id", appId).Msg("job completed successfully")
 				return nil
+			case events.JobFailed:
+				return errors.Errorf("job failed: %s", event.Error)
+			case events.JobCancelled:
+				return errors.Errorf("job cancelled: %s", event.Error)
 			}
-			// Job might have failed, check pending status
-			pending, exists := storage.Jobs.GetStatusByAppId(appId)
-			if !pending && !exists {
-				return errors.New("job completed but app is not signed")
+		case <-interrupt:
+			signal.Stop(interrupt)
+			log.Info().Str("app_id", appId).Msg("cancelling job")
+			if returnJobId == "" || !integrated.Cancel(returnJobId) {
+				if err := storage.Jobs.RemoveJob(appId, "cancelled"); err != nil {
+					return errors.WithMessage(err, "cancel job")
+				}
 			}
+		case <-twoFAHint.C:
+			if job, ok := storage.Jobs.GetByAppId(appId); ok && job.TwoFactorCode.Load() != "" {
+				log.Info().Str("return_job_id", job.Id).Msg("2FA code provided, waiting for processing")
+			} else {
+				log.Info().Msg("If 2FA code is required, you will be prompted by fastlane.")
+			}
+		case <-timeout.C:
+			return errors.New("timeout waiting for job to be processed")
 		}
-
-		time.Sleep(pollInterval)
 	}
-
-	return errors.New("timeout waiting for job to be processed")
 }
 
 // Prompt2FA prompts for 2FA code from stdin and sets it in the job
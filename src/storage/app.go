@@ -4,6 +4,7 @@ import (
 	"LocalSignTools/src/util"
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
 	"io"
 	"os"
 	"sync"
@@ -22,7 +23,11 @@ const (
 	AppProfileId    = FSName("profile_id")
 	AppBuilderId    = FSName("builder_id")
 	AppBundleName   = FSName("bundle_name")
+	AppJobHistory   = FSName("jobs.json")
+	AppInfoFile     = FSName("info.json")
+	AppIconFile     = FSName("icon.png")
 	TweaksDir       = FSName("tweaks")
+	AppLogsDir      = FSName("logs")
 )
 
 type App interface {
@@ -38,11 +43,19 @@ func loadApp(id string) App {
 	return newApp(id)
 }
 
-func createApp(unsignedFile io.Reader, name string, profile Profile, signArgs string, userBundleId string, builderId string, tweakMap map[string]io.Reader) (App, error) {
+func createApp(unsignedFile io.Reader, name string, profile Profile, signArgs string, userBundleId string, builderId string, tweakMap map[string]io.Reader) (_ App, err error) {
 	app := newApp(uuid.NewString())
 	if err := os.MkdirAll(app.resolvePath(AppRoot), os.ModePerm); err != nil {
 		return nil, errors.New("make app dir")
 	}
+	// a half-created app would show up in the list
+	defer func() {
+		if err != nil {
+			if deleteErr := app.delete(); deleteErr != nil {
+				log.Err(deleteErr).Str("app_id", app.GetId()).Msg("delete partially created app")
+			}
+		}
+	}()
 	pairs := map[FSName]string{
 		AppName:         name,
 		AppSignArgs:     signArgs,
@@ -55,9 +68,22 @@ func createApp(unsignedFile io.Reader, name string, profile Profile, signArgs st
 			return nil, errors.WithMessagef(err, "set %s", fileType)
 		}
 	}
+	// unwrapped, so that the file can still be copied without going through a buffer
+	validated, isValidated := unsignedFile.(ValidatedIpa)
+	if isValidated {
+		unsignedFile = validated.Reader
+	}
 	if err := app.SetFile(AppUnsignedFile, unsignedFile); err != nil {
 		return nil, errors.WithMessagef(err, "set %s", AppUnsignedFile)
 	}
+	if !isValidated {
+		if err := validateApp(app); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := inspectApp(app); err != nil {
+		return nil, errors.WithMessage(err, "inspect app")
+	}
 	if len(tweakMap) > 0 {
 		if err := app.MkDir(TweaksDir); err != nil {
 			return nil, err
@@ -72,6 +98,15 @@ func createApp(unsignedFile io.Reader, name string, profile Profile, signArgs st
 	return app, nil
 }
 
+func validateApp(app App) error {
+	file, err := app.GetFile(AppUnsignedFile)
+	if err != nil {
+		return errors.WithMessagef(err, "get %s", AppUnsignedFile)
+	}
+	defer file.Close()
+	return validateIpa(file)
+}
+
 func newApp(id string) *app {
 	return &app{id: id, FileSystemBase: FileSystemBase{resolvePath: func(name FSName) string {
 		return util.SafeJoinFilePaths(appsPath, id, string(name))
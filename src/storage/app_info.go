@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"LocalSignTools/src/config"
+	"LocalSignTools/src/ipa"
+	"bytes"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"io"
+	"os"
+	"strings"
+)
+
+// AppInfo is what the unsigned ipa of an app says about itself, read once when it is uploaded.
+type AppInfo struct {
+	ipa.Info
+	// The executables of the app and its extensions, or nil if they couldn't be analyzed.
+	Binaries *ipa.Binaries `json:"binaries,omitempty"`
+	// Why the ipa couldn't be inspected, if it couldn't.
+	Error string `json:"error,omitempty"`
+}
+
+// InvalidAppError is returned when an uploaded file isn't an ipa that can be signed, as opposed to failing to read it.
+type InvalidAppError struct {
+	error
+}
+
+// ValidatedIpa is an ipa that was already validated, such as an upload that passed ValidateIpaUpload,
+// so that creating an app from it doesn't read it all again.
+type ValidatedIpa struct {
+	io.Reader
+}
+
+func validateIpa(file ReadonlyFile) error {
+	stat, err := file.Stat()
+	if err != nil {
+		return errors.WithMessage(err, "stat ipa")
+	}
+	i, err := ipa.Open(file, stat.Size())
+	if err != nil {
+		return InvalidAppError{errors.WithMessage(err, "invalid ipa")}
+	}
+	if err := i.Validate(); err != nil {
+		return InvalidAppError{errors.WithMessage(err, "invalid ipa")}
+	}
+	if !config.Current.File().Upload.BlockEncrypted {
+		return nil
+	}
+	// Only binaries that are known to be encrypted are blocked, the others may just be in a format this doesn't know.
+	binaries, err := i.Binaries()
+	if err != nil {
+		log.Warn().Err(err).Msg("analyze app binaries to check encryption")
+		return nil
+	}
+	if failed := binaries.GetErrors(); len(failed) > 0 {
+		log.Warn().Strs("binaries", failed).Msg("analyze app binaries to check encryption")
+	}
+	if encrypted := binaries.GetEncrypted(); len(encrypted) > 0 {
+		return InvalidAppError{errors.Errorf(
+			"the app is encrypted by the App Store and would crash on launch, decrypt it first: %s",
+			strings.Join(encrypted, ", "))}
+	}
+	return nil
+}
+
+// GetAppInfo returns what the unsigned ipa of an app says about itself.
+// Apps uploaded before this was saved are inspected now, and the result saved.
+func GetAppInfo(app App) (*AppInfo, error) {
+	file, err := app.GetFile(AppInfoFile)
+	if os.IsNotExist(err) {
+		return inspectApp(app)
+	} else if err != nil {
+		return nil, errors.WithMessagef(err, "get %s", AppInfoFile)
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "read %s", AppInfoFile)
+	}
+	var info AppInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, errors.WithMessagef(err, "unmarshal %s", AppInfoFile)
+	}
+	return &info, nil
+}
+
+// Inspects the unsigned ipa of an app and saves the result. An ipa that can't be inspected isn't an error,
+// the reason is saved instead so that it isn't inspected again.
+func inspectApp(app App) (*AppInfo, error) {
+	file, err := app.GetFile(AppUnsignedFile)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "get %s", AppUnsignedFile)
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, errors.WithMessagef(err, "stat %s", AppUnsignedFile)
+	}
+	result := &AppInfo{}
+	if i, err := ipa.Open(file, stat.Size()); err != nil {
+		result.Error = err.Error()
+	} else if info, err := i.Info(); err != nil {
+		result.Error = err.Error()
+	} else {
+		result.Info = *info
+		if result.Binaries, err = i.Binaries(); err != nil {
+			log.Warn().Err(err).Str("app_id", app.GetId()).Msg("analyze app binaries")
+		} else {
+			if failed := result.Binaries.GetErrors(); len(failed) > 0 {
+				log.Warn().Str("app_id", app.GetId()).Strs("binaries", failed).Msg("analyze app binaries")
+			}
+			if encrypted := result.Binaries.GetEncrypted(); len(encrypted) > 0 {
+				log.Warn().Str("app_id", app.GetId()).Strs("binaries", encrypted).Msg("app is encrypted and will crash on launch")
+			}
+		}
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "marshal %s", AppInfoFile)
+	}
+	if err := app.SetFile(AppInfoFile, bytes.NewReader(data)); err != nil {
+		return nil, errors.WithMessagef(err, "set %s", AppInfoFile)
+	}
+	return result, nil
+}
+
+// GetSignedAppInfo returns what the signed ipa of an app says about itself, which may differ from the unsigned ipa
+// as signing can change the bundle id and name. It is read every time, since the signed ipa changes with each sign.
+func GetSignedAppInfo(app App) (*ipa.Info, error) {
+	file, err := app.GetFile(AppSignedFile)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "get %s", AppSignedFile)
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, errors.WithMessagef(err, "stat %s", AppSignedFile)
+	}
+	return ipa.Inspect(file, stat.Size())
+}
+
+// GetAppIcon returns the icon of an app as a png, or nil if it has none. The icon is extracted from the unsigned ipa
+// the first time, and saved. An empty file is saved if there is no icon, so that the ipa isn't searched again.
+func GetAppIcon(app App) (ReadonlyFile, error) {
+	file, err := app.GetFile(AppIconFile)
+	if os.IsNotExist(err) {
+		if err := extractAppIcon(app); err != nil {
+			return nil, err
+		}
+		file, err = app.GetFile(AppIconFile)
+	}
+	if err != nil {
+		return nil, errors.WithMessagef(err, "get %s", AppIconFile)
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, errors.WithMessagef(err, "stat %s", AppIconFile)
+	}
+	if stat.Size() == 0 {
+		file.Close()
+		return nil, nil
+	}
+	return file, nil
+}
+
+// Extracts the icon from the unsigned ipa of an app and saves it. An icon that can't be extracted isn't an error,
+// the app is treated as having none.
+func extractAppIcon(app App) error {
+	file, err := app.GetFile(AppUnsignedFile)
+	if err != nil {
+		return errors.WithMessagef(err, "get %s", AppUnsignedFile)
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return errors.WithMessagef(err, "stat %s", AppUnsignedFile)
+	}
+	var icon []byte
+	if i, err := ipa.Open(file, stat.Size()); err == nil {
+		if icon, err = i.Icon(); err != nil {
+			log.Warn().Err(err).Str("app_id", app.GetId()).Msg("extract app icon")
+		}
+	}
+	if err := app.SetFile(AppIconFile, bytes.NewReader(icon)); err != nil {
+		return errors.WithMessagef(err, "set %s", AppIconFile)
+	}
+	return nil
+}
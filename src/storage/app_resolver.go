@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"LocalSignTools/src/events"
 	"LocalSignTools/src/util"
 	"github.com/pkg/errors"
 	"io"
@@ -69,6 +70,7 @@ func (r *appResolver) New(unsignedFile io.Reader, name string, profile Profile,
 	r.mutex.Lock()
 	r.idToAppMap[app.GetId()] = app
 	r.mutex.Unlock()
+	events.Publish(events.Event{Type: events.AppUploaded, AppId: app.GetId()})
 	return app, nil
 }
 
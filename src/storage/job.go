@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"LocalSignTools/src/events"
 	"archive/tar"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
@@ -8,14 +9,57 @@ import (
 	"io"
 	"os"
 	"path"
+	"sync"
 	"time"
 )
 
 // A signing job waiting to be picked up by a builder.
 type signJob struct {
+	id        string
 	ts        time.Time
 	appId     string
 	profileId string
+	priority  JobPriority
+	position  int
+	restarts  int
+	// Counts automatic retries, starting at 1 for the first attempt.
+	attempt int
+	// Retries are not taken before this time.
+	notBefore time.Time
+}
+
+func newSignJobFromRecord(record *jobRecord) *signJob {
+	job := &signJob{
+		id:        record.Id,
+		ts:        record.QueuedAt,
+		appId:     record.AppId,
+		profileId: record.ProfileId,
+		priority:  record.Priority,
+		position:  record.Position,
+		restarts:  record.Restarts,
+		attempt:   record.Attempt,
+		notBefore: record.NotBefore,
+	}
+	// records from before retries existed
+	if job.attempt < 1 {
+		job.attempt = 1
+	}
+	return job
+}
+
+func (j *signJob) toRecord() *jobRecord {
+	return &jobRecord{
+		Id:        j.id,
+		AppId:     j.appId,
+		ProfileId: j.profileId,
+		Priority:  j.priority,
+		Position:  j.position,
+		State:     jobStatePending,
+		QueuedAt:  j.ts,
+		Restarts:  j.restarts,
+		Attempt:   j.attempt,
+		NotBefore: j.notBefore,
+	}
 }
 
 // When a signJob has been picked up by a builder, it's replaced
@@ -24,7 +68,35 @@ type ReturnJob struct {
 	Id            string
 	Ts            time.Time
 	AppId         string
+	ProfileId     string
+	Priority      JobPriority
+	Attempt       int
 	TwoFactorCode atomic.String
+
+	twoFactorMu          sync.Mutex
+	twoFactorRequestedAt time.Time
+	twoFactorAnnounced   bool
+}
+
+// How long the builder must have been asking for a 2FA code before it's announced as required.
+// The sign script asks for it while logging in, even when the session is still valid and no code is needed.
+const twoFactorGracePeriod = 5 * time.Second
+
+// RequestTwoFactorCode records that the builder asked for the 2FA code before it was submitted.
+// Once the builder has been waiting long enough, the code is announced as required, once per job.
+func (j *ReturnJob) RequestTwoFactorCode() {
+	j.twoFactorMu.Lock()
+	defer j.twoFactorMu.Unlock()
+	now := time.Now()
+	if j.twoFactorRequestedAt.IsZero() {
+		j.twoFactorRequestedAt = now
+		return
+	}
+	if j.twoFactorAnnounced || now.Sub(j.twoFactorRequestedAt) < twoFactorGracePeriod {
+		return
+	}
+	j.twoFactorAnnounced = true
+	events.Publish(events.Event{Type: events.TwoFactorRequired, AppId: j.AppId, JobId: j.Id})
 }
 
 func (j *signJob) writeArchive(returnJobId string, writer io.Writer) error {
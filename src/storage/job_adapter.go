@@ -3,13 +3,14 @@ package storage
 import (
 	"LocalSignTools/src/builders"
 	"io"
+	"time"
 )
 
 // JobStorageAdapter adapts the Jobs resolver to the builders.JobStorage interface
 type JobStorageAdapter struct{}
 
-func (a *JobStorageAdapter) TakeLastJob(writer io.Writer) error {
-	return Jobs.TakeLastJob(writer)
+func (a *JobStorageAdapter) TakeNextJob(writer io.Writer) error {
+	return Jobs.TakeNextJob(writer)
 }
 
 func (a *JobStorageAdapter) GetById(id string) (builders.ReturnJob, bool) {
@@ -20,8 +21,24 @@ func (a *JobStorageAdapter) GetById(id string) (builders.ReturnJob, bool) {
 	return &ReturnJobAdapter{job: job}, true
 }
 
-func (a *JobStorageAdapter) DeleteById(id string) bool {
-	return Jobs.DeleteById(id)
+func (a *JobStorageAdapter) CompleteById(id string) bool {
+	return Jobs.CompleteById(id)
+}
+
+func (a *JobStorageAdapter) FailById(id string, exitStatus int, reason string) bool {
+	return Jobs.FailById(id, exitStatus, reason)
+}
+
+func (a *JobStorageAdapter) CancelById(id string) bool {
+	return Jobs.CancelById(id)
+}
+
+func (a *JobStorageAdapter) RetryById(id string, exitStatus int, reason string, delay time.Duration) bool {
+	return Jobs.RetryById(id, exitStatus, reason, delay)
+}
+
+func (a *JobStorageAdapter) RequeueById(id string, reason string) bool {
+	return Jobs.RequeueById(id, reason)
 }
 
 // ReturnJobAdapter adapts *ReturnJob to builders.ReturnJob interface
@@ -33,6 +50,10 @@ func (a *ReturnJobAdapter) GetAppId() string {
 	return a.job.AppId
 }
 
+func (a *ReturnJobAdapter) GetAttempt() int {
+	return a.job.Attempt
+}
+
 // AppStorageAdapter adapts the Apps resolver to the builders.AppStorage interface
 type AppStorageAdapter struct{}
 
@@ -79,6 +100,10 @@ func (a *AppAdapter) SetFile(name string, file io.ReadSeeker) error {
 	return a.app.SetFile(fsName, file)
 }
 
+func (a *AppAdapter) SetJobLog(jobId string, log io.Reader) error {
+	return SetJobLog(a.app, jobId, log)
+}
+
 func (a *AppAdapter) SetString(name string, value string) error {
 	// Map string names to FSName constants
 	var fsName FSName
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+type JobStatus string
+
+const (
+	JobStatusQueued    JobStatus = "queued"
+	JobStatusRunning   JobStatus = "running"
+	JobStatusSucceeded JobStatus = "succeeded"
+	JobStatusFailed    JobStatus = "failed"
+	JobStatusCancelled JobStatus = "cancelled"
+)
+
+// The oldest entries are dropped once an app has more than this many sign attempts.
+const maxJobHistory = 50
+
+// A single sign attempt of an app. Automatic retries are recorded as separate entries with an increasing Attempt,
+// and the failed entry before a retry has the time from which the retry may start in RetryAt.
+type JobHistoryEntry struct {
+	Id         string      `json:"id"`
+	Status     JobStatus   `json:"status"`
+	QueuedAt   time.Time   `json:"queued_at"`
+	StartedAt  time.Time   `json:"started_at"`
+	FinishedAt time.Time   `json:"finished_at"`
+	BuilderId  string      `json:"builder_id"`
+	ProfileId  string      `json:"profile_id"`
+	Priority   JobPriority `json:"priority"`
+	SignArgs   string      `json:"sign_args"`
+	ExitStatus int         `json:"exit_status"`
+	Error      string      `json:"error,omitempty"`
+	Attempt    int         `json:"attempt"`
+	RetryAt    time.Time   `json:"retry_at"`
+}
+
+// Serializes read-modify-write cycles of the history files.
+var jobHistoryMu sync.Mutex
+
+// GetJobHistory returns all recorded sign attempts of an app, oldest first.
+func GetJobHistory(app App) ([]JobHistoryEntry, error) {
+	jobHistoryMu.Lock()
+	defer jobHistoryMu.Unlock()
+	return readJobHistory(app)
+}
+
+// GetLastJob returns the most recent sign attempt of an app.
+func GetLastJob(app App) (JobHistoryEntry, bool, error) {
+	history, err := GetJobHistory(app)
+	if err != nil || len(history) < 1 {
+		return JobHistoryEntry{}, false, err
+	}
+	return history[len(history)-1], true, nil
+}
+
+func readJobHistory(app App) ([]JobHistoryEntry, error) {
+	file, err := app.GetFile(AppJobHistory)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.WithMessagef(err, "get %s", AppJobHistory)
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "read %s", AppJobHistory)
+	}
+	var history []JobHistoryEntry
+	if err := json.Unmarshal(data, &history); err != nil {
+		return nil, errors.WithMessagef(err, "unmarshal %s", AppJobHistory)
+	}
+	return history, nil
+}
+
+func writeJobHistory(app App, history []JobHistoryEntry) error {
+	if len(history) > maxJobHistory {
+		history = history[len(history)-maxJobHistory:]
+	}
+	data, err := json.Marshal(history)
+	if err != nil {
+		return errors.WithMessagef(err, "marshal %s", AppJobHistory)
+	}
+	return app.SetFile(AppJobHistory, bytes.NewReader(data))
+}
+
+func addJobHistory(app App, entry JobHistoryEntry) error {
+	jobHistoryMu.Lock()
+	defer jobHistoryMu.Unlock()
+	history, err := readJobHistory(app)
+	if err != nil {
+		return err
+	}
+	return writeJobHistory(app, append(history, entry))
+}
+
+// Applies fn to the entry with the given job ID. Missing entries are ignored,
+// as apps created before job history existed have none.
+func updateJobHistory(app App, id string, fn func(entry *JobHistoryEntry)) error {
+	jobHistoryMu.Lock()
+	defer jobHistoryMu.Unlock()
+	history, err := readJobHistory(app)
+	if err != nil {
+		return err
+	}
+	for i := range history {
+		if history[i].Id == id {
+			fn(&history[i])
+			return writeJobHistory(app, history)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"github.com/pkg/errors"
+	"io"
+	"path"
+	"sort"
+)
+
+// Only the logs of this many most recent jobs are kept per app.
+const maxJobLogs = 20
+
+func jobLogPath(jobId string) FSName {
+	return FSName(path.Join(string(AppLogsDir), jobId+".log"))
+}
+
+// SetJobLog saves the complete output of a sign job and prunes the app's oldest logs.
+func SetJobLog(app App, jobId string, log io.Reader) error {
+	if err := app.MkDir(AppLogsDir); err != nil {
+		return errors.WithMessagef(err, "make %s", AppLogsDir)
+	}
+	if err := app.SetFile(jobLogPath(jobId), log); err != nil {
+		return errors.WithMessagef(err, "set %s", jobLogPath(jobId))
+	}
+	return pruneJobLogs(app)
+}
+
+// GetJobLog returns the saved output of a sign job.
+func GetJobLog(app App, jobId string) (ReadonlyFile, error) {
+	return app.GetFile(jobLogPath(jobId))
+}
+
+// HasJobLog reports whether the output of a sign job was saved.
+func HasJobLog(app App, jobId string) bool {
+	_, err := app.Stat(jobLogPath(jobId))
+	return err == nil
+}
+
+func pruneJobLogs(app App) error {
+	logs, err := app.ReadDir(AppLogsDir)
+	if err != nil {
+		return errors.WithMessagef(err, "read %s", AppLogsDir)
+	}
+	if len(logs) <= maxJobLogs {
+		return nil
+	}
+	type logFile struct {
+		name  FSName
+		mtime int64
+	}
+	var files []logFile
+	for _, entry := range logs {
+		info, err := entry.Info()
+		if err != nil {
+			return errors.WithMessagef(err, "stat %s", entry.Name())
+		}
+		files = append(files, logFile{
+			name:  FSName(path.Join(string(AppLogsDir), entry.Name())),
+			mtime: info.ModTime().UnixNano(),
+		})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].mtime < files[j].mtime
+	})
+	for _, file := range files[:len(files)-maxJobLogs] {
+		if err := app.RemoveFile(file.name); err != nil {
+			return errors.WithMessagef(err, "remove %s", file.name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Jobs with a higher priority are taken before all jobs with a lower one.
+// Jobs of the same priority are taken in the order they were queued.
+type JobPriority int
+
+const (
+	// Unattended work, such as re-signing many apps at once.
+	JobPriorityBulk JobPriority = iota - 1
+	// The zero value, so that jobs persisted before priorities existed keep working.
+	JobPriorityNormal
+	// Work that somebody is actively waiting for, such as a fresh upload.
+	JobPriorityInteractive
+)
+
+var jobPriorityNames = map[JobPriority]string{
+	JobPriorityBulk:        "bulk",
+	JobPriorityNormal:      "normal",
+	JobPriorityInteractive: "interactive",
+}
+
+func (p JobPriority) String() string {
+	if name, ok := jobPriorityNames[p]; ok {
+		return name
+	}
+	return strconv.Itoa(int(p))
+}
+
+func (p JobPriority) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (p *JobPriority) UnmarshalText(text []byte) error {
+	priority, err := ParseJobPriority(string(text))
+	if err != nil {
+		return err
+	}
+	*p = priority
+	return nil
+}
+
+func ParseJobPriority(str string) (JobPriority, error) {
+	for priority, name := range jobPriorityNames {
+		if name == str {
+			return priority, nil
+		}
+	}
+	return 0, errors.Errorf("invalid job priority %s", str)
+}
+
+// JobPriorities returns all priorities, lowest first.
+func JobPriorities() []JobPriority {
+	return []JobPriority{JobPriorityBulk, JobPriorityNormal, JobPriorityInteractive}
+}
+
+// A sign job waiting in the queue, as shown to administrators.
+type QueuedJob struct {
+	Position  int         `json:"position"`
+	Id        string      `json:"id"`
+	AppId     string      `json:"app_id"`
+	ProfileId string      `json:"profile_id"`
+	Priority  JobPriority `json:"priority"`
+	QueuedAt  time.Time   `json:"queued_at"`
+	Restarts  int         `json:"restarts"`
+	Attempt   int         `json:"attempt"`
+	NotBefore time.Time   `json:"not_before"`
+}
+
+// A sign job that a builder is working on.
+type RunningJob struct {
+	Id        string    `json:"id"`
+	AppId     string    `json:"app_id"`
+	ProfileId string    `json:"profile_id"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// GetRunningJobs returns all jobs taken by builders, oldest first.
+func (r *JobResolver) GetRunningJobs() []RunningJob {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var jobs []RunningJob
+	for _, job := range r.idToReturnJobMap {
+		jobs = append(jobs, RunningJob{Id: job.Id, AppId: job.AppId, ProfileId: job.ProfileId, StartedAt: job.Ts})
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].StartedAt.Before(jobs[j].StartedAt)
+	})
+	return jobs
+}
+
+// GetQueue returns all queued sign jobs, in the order they will be taken.
+func (r *JobResolver) GetQueue() []QueuedJob {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	jobs := make([]QueuedJob, len(r.queue))
+	for i, job := range r.queue {
+		jobs[i] = QueuedJob{
+			Position:  i,
+			Id:        job.id,
+			AppId:     job.appId,
+			ProfileId: job.profileId,
+			Priority:  job.priority,
+			QueuedAt:  job.ts,
+			Restarts:  job.restarts,
+			Attempt:   job.attempt,
+			NotBefore: job.notBefore,
+		}
+	}
+	return jobs
+}
+
+// MoveJob moves the queued sign job of an app to the given position, regardless of its priority.
+func (r *JobResolver) MoveJob(appId string, position int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job := r.dequeue(appId)
+	if job == nil {
+		return errors.WithMessage(ErrNotFound, "sign job")
+	}
+	if position < 0 {
+		position = 0
+	} else if position > len(r.queue) {
+		position = len(r.queue)
+	}
+	r.insertAt(position, job)
+	r.persistPositions()
+	return nil
+}
+
+// SetJobPriority changes the priority of the queued sign job of an app,
+// moving it behind the other jobs of its new priority.
+func (r *JobResolver) SetJobPriority(appId string, priority JobPriority) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job := r.dequeue(appId)
+	if job == nil {
+		return errors.WithMessage(ErrNotFound, "sign job")
+	}
+	job.priority = priority
+	r.enqueue(job)
+	r.persistPositions()
+	return nil
+}
+
+// RemoveJob drops the queued sign job of an app, recording it as cancelled with the given reason.
+func (r *JobResolver) RemoveJob(appId string, reason string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job := r.dequeue(appId)
+	if job == nil {
+		return errors.WithMessage(ErrNotFound, "sign job")
+	}
+	r.deleteRecord(appId)
+	r.finishJob(appId, job.id, job.profileId, time.Time{}, JobStatusCancelled, -1, reason)
+	r.persistPositions()
+	return nil
+}
+
+func (r *JobResolver) indexOf(appId string) int {
+	for i, job := range r.queue {
+		if job.appId == appId {
+			return i
+		}
+	}
+	return -1
+}
+
+// Inserts the job behind all jobs of the same or a higher priority.
+func (r *JobResolver) enqueue(job *signJob) {
+	position := len(r.queue)
+	for position > 0 && r.queue[position-1].priority < job.priority {
+		position--
+	}
+	r.insertAt(position, job)
+}
+
+func (r *JobResolver) insertAt(position int, job *signJob) {
+	r.queue = append(r.queue, nil)
+	copy(r.queue[position+1:], r.queue[position:])
+	r.queue[position] = job
+}
+
+// Removes and returns the queued sign job of an app, or nil if there is none.
+func (r *JobResolver) dequeue(appId string) *signJob {
+	i := r.indexOf(appId)
+	if i < 0 {
+		return nil
+	}
+	job := r.queue[i]
+	r.queue = append(r.queue[:i], r.queue[i+1:]...)
+	return job
+}
+
+// Saves the records of all jobs whose position in the queue changed, so that the order survives a restart.
+func (r *JobResolver) persistPositions() {
+	for i, job := range r.queue {
+		if job.position == i {
+			continue
+		}
+		job.position = i
+		if err := r.store.save(job.toRecord()); err != nil {
+			log.Err(err).Str("app_id", job.appId).Msg("persist job position")
+		}
+	}
+}
@@ -1,9 +1,11 @@
 package storage
 
 import (
-	"github.com/elliotchance/orderedmap"
+	"LocalSignTools/src/events"
+	"LocalSignTools/src/metrics"
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
 	"io"
 	"sync"
 	"time"
@@ -11,71 +13,195 @@ import (
 
 func newJobResolver() *JobResolver {
 	return &JobResolver{
-		appIdToSignJobMap:   orderedmap.NewOrderedMap(),
 		idToReturnJobMap:    map[string]*ReturnJob{},
 		appIdToReturnJobMap: map[string]*ReturnJob{},
 	}
 }
 
 type JobResolver struct {
-	mu                  sync.Mutex
-	appIdToSignJobMap   *orderedmap.OrderedMap
+	mu sync.Mutex
+	// Sign jobs in the order they will be taken, at most one per app.
+	queue               []*signJob
 	idToReturnJobMap    map[string]*ReturnJob
 	appIdToReturnJobMap map[string]*ReturnJob
+	store               jobStore
 }
 
+// ErrJobRunning is returned when making a sign job for an app whose previous job is still running.
+var ErrJobRunning = errors.New("the app's sign job is still running, cancel it first")
+
 // User bundle ID is unused if the profile is not an account.
-func (r *JobResolver) MakeSignJob(appId string, profileId string) {
+// A job that is already queued for the app is replaced, and the new job is queued behind all jobs of the same or a higher priority.
+// A running job isn't, since both would upload their results to the same app, so ErrJobRunning is returned instead.
+func (r *JobResolver) MakeSignJob(appId string, profileId string, priority JobPriority) error {
+	app, ok := Apps.Get(appId)
+	if !ok {
+		return errors.New("invalid app id")
+	}
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	r.appIdToSignJobMap.Set(appId, &signJob{
+	if _, running := r.appIdToReturnJobMap[appId]; running {
+		return ErrJobRunning
+	}
+	job := &signJob{
+		id:        uuid.NewString(),
 		ts:        time.Now(),
 		appId:     appId,
 		profileId: profileId,
-	})
+		priority:  priority,
+		attempt:   1,
+	}
+	if err := r.store.save(job.toRecord()); err != nil {
+		return errors.WithMessage(err, "persist sign job")
+	}
+	if oldJob := r.dequeue(appId); oldJob != nil {
+		r.finishJob(appId, oldJob.id, oldJob.profileId, time.Time{}, JobStatusCancelled, -1, "replaced by a newer job")
+	}
+	r.enqueue(job)
+	r.persistPositions()
+	r.queueJob(app, job)
+	return nil
 }
 
 var ErrNotFound = errors.New("not found")
 
-func (r *JobResolver) TakeLastJob(writer io.Writer) error {
+// TakeNextJob hands the first queued sign job whose profile isn't busy to a builder, by writing its archive.
+func (r *JobResolver) TakeNextJob(writer io.Writer) error {
 	r.mu.Lock()
-	if r.appIdToSignJobMap.Len() < 1 {
+	job := r.nextSignJob()
+	if job == nil {
 		r.mu.Unlock()
 		return errors.WithMessage(ErrNotFound, "sign job")
 	}
 
-	elem := r.appIdToSignJobMap.Back()
-	r.appIdToSignJobMap.Delete(elem.Key)
-	job := elem.Value.(*signJob)
-	returnJobId := uuid.NewString()
-	returnJob := ReturnJob{Id: returnJobId, Ts: time.Now(), AppId: job.appId}
-	r.idToReturnJobMap[returnJobId] = &returnJob
+	r.dequeue(job.appId)
+	r.persistPositions()
+	returnJob := ReturnJob{
+		Id:        job.id,
+		Ts:        time.Now(),
+		AppId:     job.appId,
+		ProfileId: job.profileId,
+		Priority:  job.priority,
+		Attempt:   job.attempt,
+	}
+	r.idToReturnJobMap[returnJob.Id] = &returnJob
 	r.appIdToReturnJobMap[job.appId] = &returnJob
+	record := job.toRecord()
+	record.State = jobStateRunning
+	record.StartedAt = returnJob.Ts
+	if err := r.store.save(record); err != nil {
+		log.Err(err).Str("app_id", job.appId).Msg("persist running job")
+	}
+	r.startJob(job, returnJob.Ts)
 	r.mu.Unlock()
 
-	if err := job.writeArchive(returnJobId, writer); err != nil {
+	if err := job.writeArchive(returnJob.Id, writer); err != nil {
 		r.mu.Lock()
-		delete(r.idToReturnJobMap, returnJobId)
-		delete(r.appIdToReturnJobMap, job.appId)
+		r.failById(returnJob.Id, -1, "write job archive: "+err.Error())
 		r.mu.Unlock()
 		return errors.WithMessage(err, "write archive")
 	}
 	return nil
 }
 
+// Returns the first sign job whose profile isn't used by a running job,
+// so that no two jobs ever share an Apple account or signing keychain at the same time.
+// Retries that are still backing off are skipped.
+func (r *JobResolver) nextSignJob() *signJob {
+	now := time.Now()
+	busyProfiles := map[string]bool{}
+	for _, returnJob := range r.idToReturnJobMap {
+		busyProfiles[returnJob.ProfileId] = true
+	}
+	for _, job := range r.queue {
+		if !busyProfiles[job.profileId] && !job.notBefore.After(now) {
+			return job
+		}
+	}
+	return nil
+}
+
+// Restore loads the persisted jobs back into memory. Pending sign jobs are put back in the queue.
+// Jobs that were running when the server stopped are orphaned, since nothing will ever return their results,
+// so they are re-queued for another attempt or, if they have been interrupted too many times, marked as failed.
+// This should only be called once, by the process that owns the job queue.
+func (r *JobResolver) Restore() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	records, err := r.store.loadAll()
+	if err != nil {
+		return errors.WithMessage(err, "load job records")
+	}
+	for _, record := range records {
+		app, ok := Apps.Get(record.AppId)
+		if !ok {
+			log.Warn().Str("app_id", record.AppId).Msg("dropping job of missing app")
+			r.deleteRecord(record.AppId)
+			continue
+		}
+		switch record.State {
+		case jobStatePending:
+			// records are sorted by their position, which keeps any manual reordering
+			r.queue = append(r.queue, r.restoreSignJob(record))
+		case jobStateRunning:
+			r.finishJob(record.AppId, record.Id, record.ProfileId, record.StartedAt, JobStatusFailed, -1, "interrupted by server restart")
+			if record.Restarts >= maxJobRestarts {
+				record.State = jobStateFailed
+				record.Error = "interrupted by server restart"
+				log.Warn().Str("app_id", record.AppId).Int("restarts", record.Restarts).Msg("marking interrupted job as failed")
+			} else {
+				record.Id = uuid.NewString()
+				record.State = jobStatePending
+				record.StartedAt = time.Time{}
+				record.Restarts++
+				job := r.restoreSignJob(record)
+				r.enqueue(job)
+				r.queueJob(app, job)
+				log.Info().Str("app_id", record.AppId).Int("restarts", record.Restarts).Msg("re-queueing interrupted job")
+			}
+			if err := r.store.save(record); err != nil {
+				return err
+			}
+		case jobStateFailed:
+			// kept on disk until the app is signed again
+		default:
+			log.Warn().Str("app_id", record.AppId).Str("state", string(record.State)).Msg("dropping job with unknown state")
+			r.deleteRecord(record.AppId)
+		}
+	}
+	r.persistPositions()
+	if len(r.queue) > 0 {
+		log.Info().Int("count", len(r.queue)).Msg("restored pending jobs")
+	}
+	return nil
+}
+
+func (r *JobResolver) restoreSignJob(record *jobRecord) *signJob {
+	job := newSignJobFromRecord(record)
+	// restart the timeout clock, or Cleanup would drop every job that outlived a long downtime
+	job.ts = time.Now()
+	return job
+}
+
 func (r *JobResolver) Cleanup(timeout time.Duration) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	now := time.Now()
-	var deleteList []any
-	for el := r.appIdToSignJobMap.Front(); el != nil; el = el.Next() {
-		job := el.Value.(*signJob)
-		if now.After(job.ts.Add(timeout)) {
-			deleteList = append(deleteList, el.Key)
+	var deleteList []*signJob
+	for _, job := range r.queue {
+		// retries can't time out before they may be taken
+		readyAt := job.ts
+		if job.notBefore.After(readyAt) {
+			readyAt = job.notBefore
+		}
+		if now.After(readyAt.Add(timeout)) {
+			deleteList = append(deleteList, job)
 		}
 	}
-	for _, key := range deleteList {
-		r.appIdToSignJobMap.Delete(key)
+	for _, job := range deleteList {
+		r.dequeue(job.appId)
+		r.deleteRecord(job.appId)
+		r.finishJob(job.appId, job.id, job.profileId, time.Time{}, JobStatusFailed, -1, "timed out waiting for a builder")
 	}
 	var deleteList2 []string
 	for id, job := range r.idToReturnJobMap {
@@ -84,14 +210,15 @@ func (r *JobResolver) Cleanup(timeout time.Duration) {
 		}
 	}
 	for _, id := range deleteList2 {
-		r.deleteById(id)
+		r.failById(id, -1, "timed out")
 	}
+	r.persistPositions()
 }
 
 func (r *JobResolver) GetStatusByAppId(id string) (bool, bool) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	_, jobPending := r.appIdToSignJobMap.Get(id)
+	jobPending := r.indexOf(id) >= 0
 	_, jobExists := r.appIdToReturnJobMap[id]
 	return jobPending, jobExists
 }
@@ -110,10 +237,107 @@ func (r *JobResolver) GetByAppId(id string) (*ReturnJob, bool) {
 	return job, ok
 }
 
-func (r *JobResolver) DeleteById(id string) bool {
+// CompleteById removes a return job whose results have been submitted, recording it as succeeded.
+func (r *JobResolver) CompleteById(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job, ok := r.idToReturnJobMap[id]
+	if !ok {
+		return false
+	}
+	r.deleteById(id)
+	r.finishJob(job.AppId, id, job.ProfileId, job.Ts, JobStatusSucceeded, 0, "")
+	return true
+}
+
+// FailById removes a return job that did not produce results, recording it as failed with the given reason.
+func (r *JobResolver) FailById(id string, exitStatus int, reason string) bool {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	return r.deleteById(id)
+	return r.failById(id, exitStatus, reason)
+}
+
+// RetryById removes a return job that failed and queues its sign job again, to be taken once the delay has passed.
+// The failed attempt is recorded in the job history. If another job has been queued for the app in the meantime,
+// nothing is changed and false is returned.
+func (r *JobResolver) RetryById(id string, exitStatus int, reason string, delay time.Duration) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.requeueById(id, exitStatus, reason, delay, true)
+}
+
+// RequeueById removes a return job that was interrupted, such as by a server shutdown, and queues its sign job again
+// without counting the interrupted run as an attempt. Like RetryById, it returns false if another job has been queued.
+func (r *JobResolver) RequeueById(id string, reason string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.requeueById(id, -1, reason, 0, false)
+}
+
+func (r *JobResolver) requeueById(id string, exitStatus int, reason string, delay time.Duration, nextAttempt bool) bool {
+	returnJob, ok := r.idToReturnJobMap[id]
+	if !ok || r.indexOf(returnJob.AppId) >= 0 {
+		return false
+	}
+	app, ok := Apps.Get(returnJob.AppId)
+	if !ok {
+		return false
+	}
+	now := time.Now()
+	job := &signJob{
+		id:        uuid.NewString(),
+		ts:        now,
+		appId:     returnJob.AppId,
+		profileId: returnJob.ProfileId,
+		priority:  returnJob.Priority,
+		attempt:   returnJob.Attempt,
+		notBefore: now.Add(delay),
+	}
+	if nextAttempt {
+		job.attempt++
+	}
+	if err := r.store.save(job.toRecord()); err != nil {
+		log.Err(err).Str("app_id", job.appId).Msg("persist retry job")
+		return false
+	}
+	delete(r.appIdToReturnJobMap, returnJob.AppId)
+	delete(r.idToReturnJobMap, id)
+	r.updateHistory(job.appId, id, func(entry *JobHistoryEntry) {
+		entry.Status = JobStatusFailed
+		entry.FinishedAt = now
+		entry.ExitStatus = exitStatus
+		entry.Error = reason
+		entry.RetryAt = job.notBefore
+	})
+	observeFinishedJob(job.appId, returnJob.ProfileId, returnJob.Ts, now, JobStatusFailed)
+	events.Publish(events.Event{Type: events.JobRetrying, AppId: job.appId, JobId: id, Error: reason})
+	r.enqueue(job)
+	r.persistPositions()
+	r.queueJob(app, job)
+	return true
+}
+
+// CancelById removes a return job that was stopped before it could finish, recording it as cancelled.
+func (r *JobResolver) CancelById(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job, ok := r.idToReturnJobMap[id]
+	if !ok {
+		return false
+	}
+	r.deleteById(id)
+	r.finishJob(job.AppId, id, job.ProfileId, job.Ts, JobStatusCancelled, -1, "cancelled")
+	return true
+}
+
+func (r *JobResolver) failById(id string, exitStatus int, reason string) bool {
+	job, ok := r.idToReturnJobMap[id]
+	if !ok {
+		return false
+	}
+	r.deleteById(id)
+	r.finishJob(job.AppId, id, job.ProfileId, job.Ts, JobStatusFailed, exitStatus, reason)
+	return true
 }
 
 func (r *JobResolver) deleteById(id string) bool {
@@ -123,5 +347,95 @@ func (r *JobResolver) deleteById(id string) bool {
 	}
 	delete(r.appIdToReturnJobMap, job.AppId)
 	delete(r.idToReturnJobMap, id)
+	// a new sign job may have been queued for the same app in the meantime
+	if r.indexOf(job.AppId) < 0 {
+		r.deleteRecord(job.AppId)
+	}
 	return true
 }
+
+func (r *JobResolver) deleteRecord(appId string) {
+	if err := r.store.delete(appId); err != nil {
+		log.Err(err).Str("app_id", appId).Msg("delete job record")
+	}
+}
+
+// Records a newly queued job in the history of its app and announces it.
+func (r *JobResolver) queueJob(app App, job *signJob) {
+	builderId, _ := app.GetString(AppBuilderId)
+	signArgs, _ := app.GetString(AppSignArgs)
+	entry := JobHistoryEntry{
+		Id:         job.id,
+		Status:     JobStatusQueued,
+		QueuedAt:   job.ts,
+		BuilderId:  builderId,
+		ProfileId:  job.profileId,
+		Priority:   job.priority,
+		SignArgs:   signArgs,
+		ExitStatus: -1,
+		Attempt:    job.attempt,
+	}
+	if err := addJobHistory(app, entry); err != nil {
+		log.Err(err).Str("app_id", app.GetId()).Msg("add job history")
+	}
+	events.Publish(events.Event{Type: events.JobQueued, AppId: app.GetId(), JobId: job.id})
+}
+
+func (r *JobResolver) startJob(job *signJob, ts time.Time) {
+	r.updateHistory(job.appId, job.id, func(entry *JobHistoryEntry) {
+		entry.Status = JobStatusRunning
+		entry.StartedAt = ts
+	})
+	metrics.JobQueueWait.Observe(ts.Sub(job.ts).Seconds(), job.profileId, getBuilderId(job.appId))
+	events.Publish(events.Event{Type: events.JobStarted, AppId: job.appId, JobId: job.id})
+}
+
+// Records a job as finished. startedAt is zero for jobs that never left the queue.
+func (r *JobResolver) finishJob(appId string, id string, profileId string, startedAt time.Time, status JobStatus, exitStatus int, reason string) {
+	now := time.Now()
+	r.updateHistory(appId, id, func(entry *JobHistoryEntry) {
+		entry.Status = status
+		entry.FinishedAt = now
+		entry.ExitStatus = exitStatus
+		entry.Error = reason
+	})
+	observeFinishedJob(appId, profileId, startedAt, now, status)
+	eventType := events.JobFailed
+	switch status {
+	case JobStatusSucceeded:
+		eventType = events.JobSucceeded
+	case JobStatusCancelled:
+		eventType = events.JobCancelled
+	}
+	events.Publish(events.Event{Type: eventType, AppId: appId, JobId: id, Error: reason})
+}
+
+// Metrics are recorded apart from the job history, which is skipped for deleted apps and may fail to update.
+func observeFinishedJob(appId string, profileId string, startedAt time.Time, finishedAt time.Time, status JobStatus) {
+	builderId := getBuilderId(appId)
+	metrics.JobsFinished.Inc(profileId, builderId, string(status))
+	// Jobs removed from the queue never started.
+	if !startedAt.IsZero() {
+		metrics.JobDuration.Observe(finishedAt.Sub(startedAt).Seconds(), profileId, builderId, string(status))
+	}
+}
+
+// Returns the builder the app is signed with, or an empty string if the app no longer exists.
+func getBuilderId(appId string) string {
+	app, ok := Apps.Get(appId)
+	if !ok {
+		return ""
+	}
+	builderId, _ := app.GetString(AppBuilderId)
+	return builderId
+}
+
+func (r *JobResolver) updateHistory(appId string, id string, fn func(entry *JobHistoryEntry)) {
+	app, ok := Apps.Get(appId)
+	if !ok {
+		return
+	}
+	if err := updateJobHistory(app, id, fn); err != nil {
+		log.Err(err).Str("app_id", appId).Str("job_id", id).Msg("update job history")
+	}
+}
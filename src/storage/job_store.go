@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"LocalSignTools/src/util"
+	"bytes"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/natefinch/atomic"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type jobState string
+
+const (
+	jobStatePending jobState = "pending"
+	jobStateRunning jobState = "running"
+	jobStateFailed  jobState = "failed"
+)
+
+// How many times a job interrupted by a restart is put back in the queue before it is given up on.
+const maxJobRestarts = 1
+
+// A journal entry for the outstanding job of a single app.
+type jobRecord struct {
+	Id        string      `json:"id"`
+	AppId     string      `json:"app_id"`
+	ProfileId string      `json:"profile_id"`
+	Priority  JobPriority `json:"priority"`
+	Position  int         `json:"position"`
+	State     jobState    `json:"state"`
+	QueuedAt  time.Time   `json:"queued_at"`
+	StartedAt time.Time   `json:"started_at"`
+	Restarts  int         `json:"restarts"`
+	Attempt   int         `json:"attempt"`
+	NotBefore time.Time   `json:"not_before"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// jobStore persists job records under jobsPath, one JSON file per app,
+// so that queued and running jobs survive a restart of the server.
+type jobStore struct{}
+
+func (s *jobStore) resolvePath(appId string) string {
+	return util.SafeJoinFilePaths(jobsPath, appId+".json")
+}
+
+func (s *jobStore) save(record *jobRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return errors.WithMessage(err, "marshal job record")
+	}
+	if err := atomic.WriteFile(s.resolvePath(record.AppId), bytes.NewReader(data)); err != nil {
+		return errors.WithMessagef(err, "write job record %s", record.AppId)
+	}
+	return nil
+}
+
+func (s *jobStore) delete(appId string) error {
+	if err := os.Remove(s.resolvePath(appId)); err != nil && !os.IsNotExist(err) {
+		return errors.WithMessagef(err, "delete job record %s", appId)
+	}
+	return nil
+}
+
+// Returns all job records, ordered by their position in the queue, then oldest first.
+func (s *jobStore) loadAll() ([]*jobRecord, error) {
+	files, err := os.ReadDir(jobsPath)
+	if err != nil {
+		return nil, errors.WithMessage(err, "read jobs dir")
+	}
+	files = util.RemoveHiddenDirs(files)
+	var records []*jobRecord
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(jobsPath, file.Name()))
+		if err != nil {
+			return nil, errors.WithMessagef(err, "read job record %s", file.Name())
+		}
+		record := jobRecord{}
+		if err := json.Unmarshal(data, &record); err != nil {
+			return nil, errors.WithMessagef(err, "unmarshal job record %s", file.Name())
+		}
+		if record.AppId == "" {
+			record.AppId = strings.TrimSuffix(file.Name(), ".json")
+		}
+		if record.Id == "" {
+			record.Id = uuid.NewString()
+		}
+		records = append(records, &record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Position != records[j].Position {
+			return records[i].Position < records[j].Position
+		}
+		return records[i].QueuedAt.Before(records[j].QueuedAt)
+	})
+	return records, nil
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"LocalSignTools/src/assets"
+	"LocalSignTools/src/provisioning"
 	"LocalSignTools/src/util"
 	"crypto"
 	"crypto/ecdsa"
@@ -9,11 +10,13 @@ import (
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
+	"fmt"
 	"github.com/pkg/errors"
 	"io"
 	"os"
 	"path"
 	"software.sslmate.com/src/go-pkcs12"
+	"strings"
 )
 
 var ProfilePaths = []FSName{ProfileCert, ProfileCertPass, ProfileProv, ProfileName, ProfileAccountName, ProfileAccountPass}
@@ -32,6 +35,12 @@ type Profile interface {
 	GetId() string
 	GetFiles() ([]fileGetter, error)
 	IsAccount() (bool, error)
+	// GetCertificates returns the signing certificates of the profile, without their authorities.
+	GetCertificates() []*x509.Certificate
+	GetTeamId() string
+	// GetProvisioningProfile returns the parsed provisioning profile, or nil for account profiles,
+	// which get theirs when signing.
+	GetProvisioningProfile() *provisioning.Profile
 	FileSystem
 }
 
@@ -41,6 +50,8 @@ func newProfile(id string) *profile {
 	}}}
 }
 
+// Loads a profile and keeps everything a sign job needs in memory, so that a profile whose files are being
+// replaced, or were changed to something invalid, keeps signing with the files it was loaded with.
 func loadProfile(id string) (*profile, error) {
 	p := newProfile(id)
 	isAccount, err := p.IsAccount()
@@ -71,33 +82,101 @@ func loadProfile(id string) (*profile, error) {
 	if err != nil {
 		return nil, errors.WithMessage(err, "read cert file")
 	}
-	fixedCert, teamId, err := processP12(origCertBytes, pass)
+	fixedCert, teamId, certificates, err := processP12(origCertBytes, pass)
 	if err != nil {
 		return nil, errors.WithMessage(err, "validate certificate")
 	}
 	p.fixedCert = fixedCert
+	p.certPass = pass
+	p.isAccount = isAccount
 	p.teamId = teamId
+	p.certificates = certificates
+	if isAccount {
+		if p.accountName, err = p.GetString(ProfileAccountName); err != nil {
+			return nil, errors.WithMessagef(err, "get %s", ProfileAccountName)
+		}
+		if p.accountPass, err = p.GetString(ProfileAccountPass); err != nil {
+			return nil, errors.WithMessagef(err, "get %s", ProfileAccountPass)
+		}
+	} else {
+		provBytes, prov, err := readProvisioningProfile(p)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkProvisioningProfile(prov, teamId, certificates); err != nil {
+			return nil, err
+		}
+		p.prov = provBytes
+		p.provisioning = prov
+	}
+	p.loaded = true
 	return p, nil
 }
 
+func readProvisioningProfile(p *profile) ([]byte, *provisioning.Profile, error) {
+	provFile, err := p.GetFile(ProfileProv)
+	if err != nil {
+		return nil, nil, errors.WithMessagef(err, "get %s", ProfileProv)
+	}
+	defer provFile.Close()
+	provBytes, err := io.ReadAll(provFile)
+	if err != nil {
+		return nil, nil, errors.WithMessagef(err, "read %s", ProfileProv)
+	}
+	prov, err := provisioning.Parse(provBytes)
+	if err != nil {
+		return nil, nil, errors.WithMessagef(err, "parse %s", ProfileProv)
+	}
+	return provBytes, prov, nil
+}
+
+// Checks that the provisioning profile allows signing with the certificates, so that mismatches are found
+// when the profile is loaded instead of when codesign fails.
+func checkProvisioningProfile(prov *provisioning.Profile, teamId string, certificates []*x509.Certificate) error {
+	if prov.TeamId != teamId {
+		return errors.Errorf("the certificate is of team %s, but the provisioning profile %s is of team %s",
+			teamId, prov.Name, prov.TeamId)
+	}
+	for _, cert := range certificates {
+		found := false
+		for _, devCert := range prov.DeveloperCertificates {
+			if cert.Equal(devCert) {
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		var allowed []string
+		for _, devCert := range prov.DeveloperCertificates {
+			allowed = append(allowed, fmt.Sprintf("%s (serial %s)", devCert.Subject.CommonName, devCert.SerialNumber.Text(16)))
+		}
+		return errors.Errorf("certificate %s (serial %s) is not included in the provisioning profile %s, which allows: %s",
+			cert.Subject.CommonName, cert.SerialNumber.Text(16), prov.Name, strings.Join(allowed, ", "))
+	}
+	return nil
+}
+
 type PublicKeyComparator interface {
 	Equal(x crypto.PublicKey) bool
 }
 
-// Validates the input P12 file, adds any missing standard CAs, and returns the new P12 along with the team ID.
-func processP12(originalP12 []byte, pass string) ([]byte, string, error) {
+// Validates the input P12 file, adds any missing standard CAs, and returns the new P12 along with the team ID
+// and the signing certificates.
+func processP12(originalP12 []byte, pass string) ([]byte, string, []*x509.Certificate, error) {
 	blocks, err := pkcs12.ToPEM(originalP12, pass)
 	if err != nil {
-		return nil, "", errors.WithMessage(err, "p12 to pem")
+		return nil, "", nil, errors.WithMessage(err, "p12 to pem")
 	}
 	appleCerts, err := assets.AppleCerts.ReadDir("certs")
 	if err != nil {
-		return nil, "", errors.WithMessage(err, "read certs dir")
+		return nil, "", nil, errors.WithMessage(err, "read certs dir")
 	}
 	for _, cert := range appleCerts {
 		certBytes, err := assets.AppleCerts.ReadFile(path.Join("certs", cert.Name()))
 		if err != nil {
-			return nil, "", errors.WithMessagef(err, "read cert %s", cert.Name())
+			return nil, "", nil, errors.WithMessagef(err, "read cert %s", cert.Name())
 		}
 		block, _ := pem.Decode(certBytes)
 		blocks = append(blocks, block)
@@ -111,7 +190,7 @@ func processP12(originalP12 []byte, pass string) ([]byte, string, error) {
 		case "CERTIFICATE":
 			cert, err := x509.ParseCertificate(block.Bytes)
 			if err != nil {
-				return nil, "", errors.WithMessage(err, "parse certificate")
+				return nil, "", nil, errors.WithMessage(err, "parse certificate")
 			}
 			serialNumber := cert.SerialNumber.String()
 			if _, ok := serialNumbers[serialNumber]; ok {
@@ -136,27 +215,27 @@ func processP12(originalP12 []byte, pass string) ([]byte, string, error) {
 				case *ed25519.PrivateKey:
 					keyMap[key] = v.Public().(*ed25519.PublicKey)
 				default:
-					return nil, "", errors.New("unknown private key type")
+					return nil, "", nil, errors.New("unknown private key type")
 				}
 			} else if key, err = x509.ParseECPrivateKey(block.Bytes); err == nil {
 				keyMap[key] = key.(*ecdsa.PrivateKey).Public().(*ecdsa.PublicKey)
 			} else {
-				return nil, "", errors.New("unknown private key type")
+				return nil, "", nil, errors.New("unknown private key type")
 			}
 		}
 	}
 	if len(keyMap) < 1 {
-		return nil, "", errors.Errorf("no private keys found")
+		return nil, "", nil, errors.Errorf("no private keys found")
 	}
 	if len(certificates) < 1 {
-		return nil, "", errors.Errorf("no signing certificates found")
+		return nil, "", nil, errors.Errorf("no signing certificates found")
 	}
 	if len(authorities) < 1 {
-		return nil, "", errors.New("no certificate authorities found")
+		return nil, "", nil, errors.New("no certificate authorities found")
 	}
 	for _, cert := range certificates {
 		if len(cert.Subject.OrganizationalUnit) != 1 {
-			return nil, "", errors.Errorf("certificate %s has invalid organization unit, bad item count", cert.SerialNumber.String())
+			return nil, "", nil, errors.Errorf("certificate %s has invalid organization unit, bad item count", cert.SerialNumber.String())
 		}
 		valid := false
 		for _, publicKey := range keyMap {
@@ -166,13 +245,13 @@ func processP12(originalP12 []byte, pass string) ([]byte, string, error) {
 			}
 		}
 		if !valid {
-			return nil, "", errors.Errorf("certificate %s has no matching private key", cert.SerialNumber.String())
+			return nil, "", nil, errors.Errorf("certificate %s has no matching private key", cert.SerialNumber.String())
 		}
 	}
 	orgUnit := certificates[0].Subject.OrganizationalUnit[0]
 	for _, cert := range certificates {
 		if cert.Subject.OrganizationalUnit[0] != orgUnit {
-			return nil, "", errors.Errorf("certificate %s has invalid organization unit, not the same as the others", cert.SerialNumber.String())
+			return nil, "", nil, errors.Errorf("certificate %s has invalid organization unit, not the same as the others", cert.SerialNumber.String())
 		}
 	}
 	var keys []any
@@ -181,15 +260,25 @@ func processP12(originalP12 []byte, pass string) ([]byte, string, error) {
 	}
 	fixedP12, err := pkcs12.LegacyDES.Encode(keys, certificates, authorities, pass)
 	if err != nil {
-		return nil, "", errors.WithMessage(err, "encode final p12")
+		return nil, "", nil, errors.WithMessage(err, "encode final p12")
 	}
-	return fixedP12, orgUnit, nil
+	return fixedP12, orgUnit, certificates, nil
 }
 
 type profile struct {
-	id        string
-	teamId    string
-	fixedCert []byte
+	id     string
+	teamId string
+	// The files sent to the builder, read by loadProfile. Profiles that weren't loaded, such as invalid ones
+	// being edited, have none.
+	loaded       bool
+	isAccount    bool
+	fixedCert    []byte
+	certPass     string
+	prov         []byte
+	accountName  string
+	accountPass  string
+	certificates []*x509.Certificate
+	provisioning *provisioning.Profile
 	FileSystemBase
 }
 
@@ -198,6 +287,9 @@ func (p *profile) GetId() string {
 }
 
 func (p *profile) IsAccount() (bool, error) {
+	if p.loaded {
+		return p.isAccount, nil
+	}
 	if _, err := os.Stat(p.resolvePath(ProfileAccountName)); os.IsNotExist(err) {
 		return false, nil
 	} else if err != nil {
@@ -207,32 +299,45 @@ func (p *profile) IsAccount() (bool, error) {
 }
 
 func (p *profile) GetFiles() ([]fileGetter, error) {
-	isAccount, err := p.IsAccount()
-	if err != nil {
-		return nil, errors.New("is account")
+	if !p.loaded {
+		return nil, errors.New("profile not loaded")
+	}
+	fromString := func(str string) func() (string, error) {
+		return func() (string, error) {
+			return str, nil
+		}
+	}
+	fromBytes := func(b []byte) func() ([]byte, error) {
+		return func() ([]byte, error) {
+			return b, nil
+		}
 	}
 	var files = []fileGetter{
-		{name: "cert.p12", f3: p.getFixedCert},
-		{name: "cert_pass.txt", f2: func() (string, error) { return p.GetString(ProfileCertPass) }},
-		{name: "team_id.txt", f2: p.getTeamId},
+		{name: "cert.p12", f3: fromBytes(p.fixedCert)},
+		{name: "cert_pass.txt", f2: fromString(p.certPass)},
+		{name: "team_id.txt", f2: fromString(p.teamId)},
 	}
-	if isAccount {
+	if p.isAccount {
 		files = append(files, []fileGetter{
-			{name: "account_name.txt", f2: func() (string, error) { return p.GetString(ProfileAccountName) }},
-			{name: "account_pass.txt", f2: func() (string, error) { return p.GetString(ProfileAccountPass) }},
+			{name: "account_name.txt", f2: fromString(p.accountName)},
+			{name: "account_pass.txt", f2: fromString(p.accountPass)},
 		}...)
 	} else {
 		files = append(files, []fileGetter{
-			{name: "prov.mobileprovision", f1: func() (ReadonlyFile, error) { return p.GetFile(ProfileProv) }},
+			{name: "prov.mobileprovision", f3: fromBytes(p.prov)},
 		}...)
 	}
 	return files, nil
 }
 
-func (p *profile) getFixedCert() ([]byte, error) {
-	return p.fixedCert, nil
+func (p *profile) GetCertificates() []*x509.Certificate {
+	return p.certificates
+}
+
+func (p *profile) GetTeamId() string {
+	return p.teamId
 }
 
-func (p *profile) getTeamId() (string, error) {
-	return p.teamId, nil
+func (p *profile) GetProvisioningProfile() *provisioning.Profile {
+	return p.provisioning
 }
@@ -0,0 +1,317 @@
+package storage
+
+import (
+	"LocalSignTools/src/provisioning"
+	"bytes"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"io"
+	"os"
+)
+
+// ErrProfileReadOnly is returned when changing the profile imported from environment variables.
+var ErrProfileReadOnly = errors.New("profile is read-only")
+
+// ProfileInputError is returned when the input for a profile is invalid, as opposed to failing to save it.
+type ProfileInputError struct {
+	error
+}
+
+// ProfileInUseError is returned when deleting a profile that apps are still signed with.
+type ProfileInUseError struct {
+	AppIds []string
+}
+
+func (e *ProfileInUseError) Error() string {
+	return fmt.Sprintf("profile is used by %d apps", len(e.AppIds))
+}
+
+// ProfileInput holds the files of a signing profile to create or update.
+// A profile has either a provisioning profile, or the credentials of a developer account.
+// When updating, empty fields keep their current value.
+type ProfileInput struct {
+	Name        string
+	Cert        []byte
+	CertPass    string
+	Prov        []byte
+	AccountName string
+	AccountPass string
+}
+
+// Returns whether the input makes an account profile. wasAccount is the type of the profile being updated,
+// which is kept if the input doesn't change it, or nil when creating a profile.
+func (input *ProfileInput) isAccount(wasAccount *bool) (bool, error) {
+	hasProv := len(input.Prov) > 0
+	hasAccount := input.AccountName != "" || input.AccountPass != ""
+	hasFullAccount := input.AccountName != "" && input.AccountPass != ""
+	switch {
+	case hasProv && hasAccount:
+		return false, ProfileInputError{errors.New("use either a provisioning profile or account credentials, not both")}
+	case hasProv:
+		return false, nil
+	case hasAccount && (hasFullAccount || (wasAccount != nil && *wasAccount)):
+		return true, nil
+	case hasAccount:
+		return false, ProfileInputError{errors.New("missing account name or password")}
+	case wasAccount != nil:
+		return *wasAccount, nil
+	default:
+		return false, ProfileInputError{errors.New("missing provisioning profile or account credentials")}
+	}
+}
+
+// New validates and saves a new signing profile.
+func (r *profileResolver) New(input *ProfileInput) (Profile, error) {
+	r.refreshMu.Lock()
+	defer r.refreshMu.Unlock()
+	if input.Name == "" {
+		return nil, ProfileInputError{errors.New("missing name")}
+	}
+	if len(input.Cert) < 1 {
+		return nil, ProfileInputError{errors.New("missing certificate")}
+	}
+	isAccount, err := input.isAccount(nil)
+	if err != nil {
+		return nil, err
+	}
+	var prov []byte
+	if !isAccount {
+		prov = input.Prov
+	}
+	if err := validateProfileFiles(input.Cert, input.CertPass, prov); err != nil {
+		return nil, err
+	}
+	p := newProfile(uuid.NewString())
+	if err := os.MkdirAll(p.resolvePath(ProfileRoot), 0700); err != nil {
+		return nil, errors.WithMessage(err, "make profile dir")
+	}
+	// the password is always saved, since an empty one is valid
+	if err := p.SetString(ProfileCertPass, input.CertPass); err != nil {
+		os.RemoveAll(p.resolvePath(ProfileRoot))
+		return nil, errors.WithMessagef(err, "set %s", ProfileCertPass)
+	}
+	if err := writeProfileFiles(p, input, isAccount); err != nil {
+		os.RemoveAll(p.resolvePath(ProfileRoot))
+		return nil, err
+	}
+	log.Info().Str("id", p.id).Msg("created profile")
+	return r.reload(p.id)
+}
+
+// Update validates the changes to a profile before saving them. Renaming only needs the name.
+func (r *profileResolver) Update(id string, input *ProfileInput) (Profile, error) {
+	r.refreshMu.Lock()
+	defer r.refreshMu.Unlock()
+	p, err := r.getEditable(id)
+	if err != nil {
+		return nil, err
+	}
+	wasAccount, err := p.IsAccount()
+	if err != nil {
+		return nil, errors.WithMessage(err, "is account")
+	}
+	isAccount, err := input.isAccount(&wasAccount)
+	if err != nil {
+		return nil, err
+	}
+	if len(input.Cert) > 0 || input.CertPass != "" || len(input.Prov) > 0 || isAccount != wasAccount {
+		cert := input.Cert
+		if len(cert) < 1 {
+			if cert, err = readProfileFile(p, ProfileCert); err != nil {
+				return nil, err
+			}
+		}
+		pass := input.CertPass
+		if pass == "" {
+			if pass, err = p.GetString(ProfileCertPass); err != nil {
+				return nil, errors.WithMessagef(err, "get %s", ProfileCertPass)
+			}
+		}
+		var prov []byte
+		if !isAccount {
+			if prov = input.Prov; len(prov) < 1 {
+				if prov, err = readProfileFile(p, ProfileProv); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if err := validateProfileFiles(cert, pass, prov); err != nil {
+			return nil, err
+		}
+	}
+	if input.CertPass != "" {
+		if err := p.SetString(ProfileCertPass, input.CertPass); err != nil {
+			return nil, errors.WithMessagef(err, "set %s", ProfileCertPass)
+		}
+	}
+	if err := writeProfileFiles(p, input, isAccount); err != nil {
+		return nil, err
+	}
+	log.Info().Str("id", id).Msg("updated profile")
+	profile, err := r.reload(id)
+	if err != nil {
+		// an invalid profile may still be missing files after the update
+		r.mu.Lock()
+		r.idToErrorMap[id] = err
+		r.mu.Unlock()
+		return nil, ProfileInputError{err}
+	}
+	return profile, nil
+}
+
+// Checks the certificate, and that the provisioning profile allows signing with it unless prov is nil.
+func validateProfileFiles(cert []byte, pass string, prov []byte) error {
+	_, teamId, certificates, err := processP12(cert, pass)
+	if err != nil {
+		return ProfileInputError{errors.WithMessage(err, "validate certificate")}
+	}
+	if prov == nil {
+		return nil
+	}
+	parsed, err := provisioning.Parse(prov)
+	if err != nil {
+		return ProfileInputError{errors.WithMessage(err, "parse provisioning profile")}
+	}
+	if err := checkProvisioningProfile(parsed, teamId, certificates); err != nil {
+		return ProfileInputError{err}
+	}
+	return nil
+}
+
+// Delete removes a profile, unless apps are still signed with it.
+func (r *profileResolver) Delete(id string) error {
+	r.refreshMu.Lock()
+	defer r.refreshMu.Unlock()
+	p, err := r.getEditable(id)
+	if err != nil {
+		return err
+	}
+	apps, err := Apps.GetAll()
+	if err != nil {
+		return errors.WithMessage(err, "get apps")
+	}
+	var appIds []string
+	for _, app := range apps {
+		if profileId, err := app.GetString(AppProfileId); err == nil && profileId == id {
+			appIds = append(appIds, app.GetId())
+		}
+	}
+	if len(appIds) > 0 {
+		return &ProfileInUseError{AppIds: appIds}
+	}
+	if err := os.RemoveAll(p.resolvePath(ProfileRoot)); err != nil {
+		return errors.WithMessagef(err, "delete profile id=%s", id)
+	}
+	r.mu.Lock()
+	delete(r.idToProfileMap, id)
+	delete(r.idToStampMap, id)
+	delete(r.idToErrorMap, id)
+	r.mu.Unlock()
+	log.Info().Str("id", id).Msg("deleted profile")
+	return nil
+}
+
+// IsProfileReadOnly returns whether the profile is imported from environment variables, and so can't be changed.
+func IsProfileReadOnly(p Profile) bool {
+	_, ok := p.(*envProfile)
+	return ok
+}
+
+// IsInvalidProfileReadOnly returns whether the invalid profile is the one from environment variables.
+func IsInvalidProfileReadOnly(p InvalidProfile) bool {
+	return p.Id == envProfileErrorId
+}
+
+// Returns the profile if it is saved in files, which is the case for all except the one from environment variables.
+// Invalid profiles can be edited too, so that they can be fixed.
+func (r *profileResolver) getEditable(id string) (*profile, error) {
+	found, ok := r.GetById(id)
+	if !ok {
+		r.mu.RLock()
+		_, invalid := r.idToErrorMap[id]
+		r.mu.RUnlock()
+		if !invalid {
+			return nil, errors.WithMessage(ErrNotFound, "profile")
+		} else if id == envProfileErrorId {
+			return nil, ErrProfileReadOnly
+		}
+		return newProfile(id), nil
+	}
+	p, ok := found.(*profile)
+	if !ok {
+		return nil, ErrProfileReadOnly
+	}
+	return p, nil
+}
+
+// Loads a profile whose files were just written, replacing any previous version.
+func (r *profileResolver) reload(id string) (Profile, error) {
+	p, err := loadProfile(id)
+	if err != nil {
+		return nil, errors.WithMessage(err, "load profile")
+	}
+	stamp, err := getProfileStamp(id)
+	if err != nil {
+		return nil, errors.WithMessage(err, "get profile stamp")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.idToProfileMap[id] = p
+	r.idToStampMap[id] = stamp
+	delete(r.idToErrorMap, id)
+	return p, nil
+}
+
+// Writes the files of the input that aren't empty, and removes the files of the other profile type.
+func writeProfileFiles(p *profile, input *ProfileInput, isAccount bool) error {
+	values := map[FSName]string{
+		ProfileName:        input.Name,
+		ProfileAccountName: input.AccountName,
+		ProfileAccountPass: input.AccountPass,
+	}
+	for name, value := range values {
+		if value == "" {
+			continue
+		}
+		if err := p.SetString(name, value); err != nil {
+			return errors.WithMessagef(err, "set %s", name)
+		}
+	}
+	files := map[FSName][]byte{
+		ProfileCert: input.Cert,
+		ProfileProv: input.Prov,
+	}
+	for name, value := range files {
+		if len(value) < 1 {
+			continue
+		}
+		if err := p.SetFile(name, bytes.NewReader(value)); err != nil {
+			return errors.WithMessagef(err, "set %s", name)
+		}
+	}
+	removed := []FSName{ProfileAccountName, ProfileAccountPass}
+	if isAccount {
+		removed = []FSName{ProfileProv}
+	}
+	for _, name := range removed {
+		if err := p.RemoveFile(name); err != nil && !os.IsNotExist(err) {
+			return errors.WithMessagef(err, "remove %s", name)
+		}
+	}
+	return nil
+}
+
+func readProfileFile(p *profile, name FSName) ([]byte, error) {
+	file, err := p.GetFile(name)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "get %s", name)
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "read %s", name)
+	}
+	return data, nil
+}
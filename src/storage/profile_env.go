@@ -2,8 +2,10 @@ package storage
 
 import (
 	"LocalSignTools/src/config"
+	"LocalSignTools/src/provisioning"
 	"bytes"
 	"compress/zlib"
+	"crypto/x509"
 	"encoding/base64"
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
@@ -28,12 +30,23 @@ func newEnvProfile(cfg *config.EnvProfile) (*envProfile, error) {
 	if err != nil {
 		return nil, err
 	}
-	fixedCert, teamId, err := processP12(p.originalCert, p.certPass)
+	fixedCert, teamId, certificates, err := processP12(p.originalCert, p.certPass)
 	if err != nil {
 		return nil, errors.WithMessage(err, "validate certificate")
 	}
 	p.fixedCert = fixedCert
 	p.teamId = teamId
+	p.certificates = certificates
+	if len(p.prov) > 0 {
+		prov, err := provisioning.Parse(p.prov)
+		if err != nil {
+			return nil, errors.WithMessage(err, "parse provisioning profile")
+		}
+		if err := checkProvisioningProfile(prov, teamId, certificates); err != nil {
+			return nil, err
+		}
+		p.provisioning = prov
+	}
 	return p, nil
 }
 
@@ -102,6 +115,8 @@ type envProfile struct {
 	accountName  string
 	accountPass  string
 	teamId       string
+	certificates []*x509.Certificate
+	provisioning *provisioning.Profile
 }
 
 func (p *envProfile) MkDir(name FSName) error {
@@ -166,6 +181,18 @@ func (p *envProfile) GetId() string {
 	return p.id
 }
 
+func (p *envProfile) GetCertificates() []*x509.Certificate {
+	return p.certificates
+}
+
+func (p *envProfile) GetTeamId() string {
+	return p.teamId
+}
+
+func (p *envProfile) GetProvisioningProfile() *provisioning.Profile {
+	return p.provisioning
+}
+
 func (p *envProfile) GetFiles() ([]fileGetter, error) {
 	isAccount, err := p.IsAccount()
 	if err != nil {
@@ -3,46 +3,148 @@ package storage
 import (
 	"LocalSignTools/src/config"
 	"LocalSignTools/src/util"
+	"fmt"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	"os"
 	"sort"
+	"strings"
+	"sync"
 )
 
 func newProfileResolver() *profileResolver {
 	return &profileResolver{
 		idToProfileMap: map[string]Profile{},
+		idToStampMap:   map[string]string{},
+		idToErrorMap:   map[string]error{},
 	}
 }
 
 type profileResolver struct {
+	// Only one refresh runs at a time, while mu guards the maps.
+	refreshMu      sync.Mutex
+	mu             sync.RWMutex
 	idToProfileMap map[string]Profile
+	// The files of each profile when it was loaded, so that unchanged profiles aren't loaded again.
+	idToStampMap map[string]string
+	// Profiles that failed to load, with the reason. They can't be used until they are fixed.
+	idToErrorMap map[string]error
 }
 
+// The id of the profile from environment variables when it fails to load, as the id is random otherwise.
+const envProfileErrorId = "env"
+
+// InvalidProfile is a profile that failed to load.
+type InvalidProfile struct {
+	Id        string
+	Name      string
+	IsAccount bool
+	// The profile is still loaded with the files it had before it was changed, which only re-sign the apps
+	// already signed with it.
+	HasPrevious bool
+	Err         error
+}
+
+// Loads new and changed profiles, and drops the ones that were removed.
+// Profiles that fail to load are marked as invalid, and keep their previous version if they had one,
+// so that one broken profile doesn't stop the others from working.
 func (r *profileResolver) refresh() error {
+	r.refreshMu.Lock()
+	defer r.refreshMu.Unlock()
 	idDirs, err := os.ReadDir(profilesPath)
 	if err != nil {
 		return errors.WithMessage(err, "read profiles dir")
 	}
 	idDirs = util.RemoveHiddenDirs(idDirs)
-	envProfile, err := newEnvProfile(config.Current.EnvProfile)
-	if err == nil {
-		r.idToProfileMap[envProfile.GetId()] = envProfile
-	} else if !os.IsNotExist(err) {
-		return errors.WithMessage(err, "import profile from envvars")
+	r.mu.RLock()
+	oldProfiles := r.idToProfileMap
+	oldStamps := r.idToStampMap
+	oldErrors := r.idToErrorMap
+	r.mu.RUnlock()
+	profiles := map[string]Profile{}
+	stamps := map[string]string{}
+	loadErrors := map[string]error{}
+
+	// the id of the env profile is random, so it is only loaded once
+	for id, profile := range oldProfiles {
+		if _, ok := profile.(*envProfile); ok {
+			profiles[id] = profile
+		}
+	}
+	if len(profiles) < 1 {
+		envProfile, err := newEnvProfile(config.Current.EnvProfile)
+		if err == nil {
+			profiles[envProfile.GetId()] = envProfile
+		} else if !os.IsNotExist(err) {
+			log.Err(err).Msg("import profile from envvars")
+			loadErrors[envProfileErrorId] = errors.WithMessage(err, "import profile from envvars")
+		}
 	}
+
 	for _, idDir := range idDirs {
 		id := idDir.Name()
+		oldProfile, exists := oldProfiles[id]
+		stamp, err := getProfileStamp(id)
+		if oldStamp, known := oldStamps[id]; err == nil && known && stamp == oldStamp {
+			if exists {
+				profiles[id] = oldProfile
+			}
+			if oldErr, ok := oldErrors[id]; ok {
+				loadErrors[id] = oldErr
+			}
+			stamps[id] = stamp
+			continue
+		}
 		profile, err := loadProfile(id)
 		if err != nil {
-			log.Fatal().Err(err).Str("id", id).Msg("load profile from files")
+			log.Err(err).Str("id", id).Msg("load profile from files")
+			loadErrors[id] = err
+			stamps[id] = stamp
+			if exists {
+				profiles[id] = oldProfile
+			}
+			continue
+		}
+		if exists {
+			log.Info().Str("id", id).Msg("reloaded changed profile")
+		} else if len(oldProfiles) > 0 {
+			log.Info().Str("id", id).Msg("loaded new profile")
+		}
+		profiles[id] = profile
+		stamps[id] = stamp
+	}
+	for id := range oldProfiles {
+		if _, ok := profiles[id]; !ok {
+			log.Info().Str("id", id).Msg("removed deleted profile")
 		}
-		r.idToProfileMap[id] = profile
 	}
+
+	r.mu.Lock()
+	r.idToProfileMap = profiles
+	r.idToStampMap = stamps
+	r.idToErrorMap = loadErrors
+	r.mu.Unlock()
 	return nil
 }
 
+// Describes the files of a profile by their size and modification time, to tell when it changed.
+func getProfileStamp(id string) (string, error) {
+	var stamp strings.Builder
+	for _, name := range ProfilePaths {
+		info, err := os.Stat(util.SafeJoinFilePaths(profilesPath, id, string(name)))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&stamp, "%s:%d:%d;", name, info.Size(), info.ModTime().UnixNano())
+	}
+	return stamp.String(), nil
+}
+
 func (r *profileResolver) GetAll() ([]Profile, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	var profiles []Profile
 	for _, profile := range r.idToProfileMap {
 		profiles = append(profiles, profile)
@@ -55,10 +157,57 @@ func (r *profileResolver) GetAll() ([]Profile, error) {
 	return profiles, nil
 }
 
+// GetInvalid returns the profiles that failed to load, sorted by name.
+func (r *profileResolver) GetInvalid() []InvalidProfile {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var invalid []InvalidProfile
+	for id, err := range r.idToErrorMap {
+		profile := InvalidProfile{Id: id, Name: getInvalidProfileName(id), Err: err}
+		if previous, ok := r.idToProfileMap[id]; ok {
+			profile.HasPrevious = true
+			profile.IsAccount, _ = previous.IsAccount()
+		} else if id != envProfileErrorId {
+			profile.IsAccount, _ = newProfile(id).IsAccount()
+		}
+		invalid = append(invalid, profile)
+	}
+	sort.Slice(invalid, func(i, j int) bool {
+		return invalid[i].Name < invalid[j].Name
+	})
+	return invalid
+}
+
+// Invalid profiles may be missing their name, so the id is used instead.
+func getInvalidProfileName(id string) string {
+	if id == envProfileErrorId {
+		if config.Current.EnvProfile != nil && config.Current.EnvProfile.Name != "" {
+			return config.Current.EnvProfile.Name
+		}
+		return id
+	}
+	if name, err := newProfile(id).GetString(ProfileName); err == nil && name != "" {
+		return name
+	}
+	return id
+}
+
 func (r *profileResolver) GetById(id string) (Profile, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	profile, ok := r.idToProfileMap[id]
 	if !ok {
 		return nil, false
 	}
 	return profile, true
 }
+
+// IsUsable returns whether new apps can be signed with the profile. A profile whose changes failed to load is still
+// found by GetById, but isn't usable until it is fixed.
+func (r *profileResolver) IsUsable(id string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, loaded := r.idToProfileMap[id]
+	_, invalid := r.idToErrorMap[id]
+	return loaded && !invalid
+}
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"LocalSignTools/src/util"
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// How long to wait for more changes before reloading, as copying a profile causes an event for every file.
+const profileWatchDelay = 2 * time.Second
+
+// WatchProfiles reloads the profiles whenever the files in the profiles directory change, until stop is closed.
+func WatchProfiles(stop <-chan bool) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return errors.WithMessage(err, "make watcher")
+	}
+	if err := watchProfileDirs(watcher); err != nil {
+		watcher.Close()
+		return err
+	}
+	go func() {
+		defer watcher.Close()
+		var reload <-chan time.Time
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				log.Debug().Str("event", event.String()).Msg("profile files changed")
+				reload = time.After(profileWatchDelay)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Warn().Err(err).Msg("watch profiles")
+			case <-reload:
+				reload = nil
+				// new profiles need a watch of their own
+				if err := watchProfileDirs(watcher); err != nil {
+					log.Warn().Err(err).Msg("watch profiles")
+				}
+				if err := ReloadProfiles(); err != nil {
+					log.Err(err).Msg("reload profiles")
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Watches the profiles directory and the directory of every profile, since watches aren't recursive.
+func watchProfileDirs(watcher *fsnotify.Watcher) error {
+	if err := watcher.Add(profilesPath); err != nil {
+		return errors.WithMessage(err, "watch profiles dir")
+	}
+	idDirs, err := os.ReadDir(profilesPath)
+	if err != nil {
+		return errors.WithMessage(err, "read profiles dir")
+	}
+	for _, idDir := range util.RemoveHiddenDirs(idDirs) {
+		if !idDir.IsDir() {
+			continue
+		}
+		if err := watcher.Add(filepath.Join(profilesPath, idDir.Name())); err != nil {
+			return errors.WithMessagef(err, "watch profile %s", idDir.Name())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+)
+
+// Where the automatic re-sign settings of an app or a profile are saved.
+const ResignSettingsFile = FSName("resign.json")
+
+// ResignSettings controls when apps are re-signed automatically. The settings of a profile apply to all apps
+// signed with it, unless an app has its own.
+type ResignSettings struct {
+	// Re-sign shortly before the signature expires, see config resign.days_before_expiry.
+	BeforeExpiry bool `json:"before_expiry"`
+	// A cron expression of when to re-sign regardless of the expiry, or empty for never.
+	Schedule string `json:"schedule,omitempty"`
+}
+
+// IsEnabled reports whether the settings re-sign at all.
+func (s *ResignSettings) IsEnabled() bool {
+	return s.BeforeExpiry || s.Schedule != ""
+}
+
+// GetResignSettings returns the re-sign settings of an app or a profile, or nil if it has none.
+func GetResignSettings(fs FileSystem) (*ResignSettings, error) {
+	file, err := fs.GetFile(ResignSettingsFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.WithMessagef(err, "get %s", ResignSettingsFile)
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "read %s", ResignSettingsFile)
+	}
+	var settings ResignSettings
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return nil, errors.WithMessagef(err, "unmarshal %s", ResignSettingsFile)
+	}
+	return &settings, nil
+}
+
+// SetResignSettings saves the re-sign settings of an app or a profile, or removes them if settings is nil.
+func SetResignSettings(fs FileSystem, settings *ResignSettings) error {
+	if settings == nil {
+		if err := fs.RemoveFile(ResignSettingsFile); err != nil && !os.IsNotExist(err) {
+			return errors.WithMessagef(err, "remove %s", ResignSettingsFile)
+		}
+		return nil
+	}
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return errors.WithMessagef(err, "marshal %s", ResignSettingsFile)
+	}
+	return fs.SetFile(ResignSettingsFile, bytes.NewReader(data))
+}
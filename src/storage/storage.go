@@ -12,6 +12,7 @@ var (
 	appsPath     string
 	profilesPath string
 	uploadsPath  string
+	jobsPath     string
 )
 
 type ReadonlyFile interface {
@@ -26,10 +27,11 @@ var Jobs = newJobResolver()
 var Uploads = newUploadResolver()
 
 func Load() {
-	appsPath = filepath.Join(config.Current.SaveDir, "apps")
-	profilesPath = filepath.Join(config.Current.SaveDir, "profiles")
-	uploadsPath = filepath.Join(config.Current.SaveDir, "uploads")
-	requiredPaths := []string{appsPath, profilesPath, uploadsPath}
+	appsPath = filepath.Join(config.Current.File().SaveDir, "apps")
+	profilesPath = filepath.Join(config.Current.File().SaveDir, "profiles")
+	uploadsPath = filepath.Join(config.Current.File().SaveDir, "uploads")
+	jobsPath = filepath.Join(config.Current.File().SaveDir, "jobs")
+	requiredPaths := []string{appsPath, profilesPath, uploadsPath, jobsPath}
 	for _, path := range requiredPaths {
 		if err := os.MkdirAll(path, os.ModePerm); err != nil {
 			log.Fatal().Err(err).Msg("mkdir required path")
@@ -46,6 +48,11 @@ func Load() {
 	}
 }
 
+// ReloadProfiles loads new and changed profiles from disk, and drops the ones that were removed.
+func ReloadProfiles() error {
+	return Profiles.refresh()
+}
+
 type fileGetter struct {
 	name string
 	f1   func() (ReadonlyFile, error)
@@ -17,8 +17,13 @@ type Upload interface {
 	GetData() (ReadonlyFile, error)
 	GetInfo() (handler.FileInfo, error)
 	GetModTime() (time.Time, error)
+	// IsValidated returns whether the upload passed ValidateIpaUpload since the server started.
+	IsValidated() bool
 }
 
+// The ids of the uploads that passed ValidateIpaUpload. They are validated again after a restart.
+var validatedUploads sync.Map
+
 type upload struct {
 	id string
 	mu sync.Mutex
@@ -37,6 +42,11 @@ func (u *upload) GetModTime() (time.Time, error) {
 	return stat.ModTime(), nil
 }
 
+func (u *upload) IsValidated() bool {
+	_, ok := validatedUploads.Load(u.id)
+	return ok
+}
+
 func (u *upload) GetData() (ReadonlyFile, error) {
 	return u.GetFile(FSName(u.id))
 }
@@ -67,6 +77,7 @@ func newUpload(id string) *upload {
 func (u *upload) delete() error {
 	u.mu.Lock()
 	defer u.mu.Unlock()
+	validatedUploads.Delete(u.id)
 	if err := os.RemoveAll(u.resolvePath(FSName(u.id))); err != nil {
 		return errors.WithMessage(err, "delete uploaded file")
 	}
@@ -79,3 +90,18 @@ func (u *upload) delete() error {
 func GetUploadsPath() string {
 	return uploadsPath
 }
+
+// ValidateIpaUpload checks that a finished upload is an ipa that can be signed, returning InvalidAppError if it isn't.
+// It works before the upload is added to Uploads. Uploads that pass are marked as validated, see Upload.IsValidated.
+func ValidateIpaUpload(id string) error {
+	file, err := newUpload(id).GetData()
+	if err != nil {
+		return errors.WithMessage(err, "get upload data")
+	}
+	defer file.Close()
+	if err := validateIpa(file); err != nil {
+		return err
+	}
+	validatedUploads.Store(id, true)
+	return nil
+}
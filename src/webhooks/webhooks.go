@@ -0,0 +1,256 @@
+package webhooks
+
+import (
+	"LocalSignTools/src/config"
+	"LocalSignTools/src/events"
+	"LocalSignTools/src/expiry"
+	"LocalSignTools/src/storage"
+	"LocalSignTools/src/util"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	maxDeliveryAttempts = 5
+	// Doubled after every failed attempt.
+	firstRetryDelay = 2 * time.Second
+	deliveryTimeout = 10 * time.Second
+	// How long stopping waits for deliveries that are still in progress.
+	stopTimeout = 15 * time.Second
+)
+
+var client = &http.Client{Timeout: deliveryTimeout}
+
+type Payload struct {
+	// Unique for every event, but the same for all delivery attempts of it.
+	Id        string      `json:"id"`
+	Event     events.Type `json:"event"`
+	Timestamp time.Time   `json:"timestamp"`
+	App       *App        `json:"app,omitempty"`
+	Job       *Job        `json:"job,omitempty"`
+	Profile   *Profile    `json:"profile,omitempty"`
+	Message   string      `json:"message,omitempty"`
+}
+
+type App struct {
+	Id          string `json:"id"`
+	Name        string `json:"name"`
+	BundleId    string `json:"bundle_id,omitempty"`
+	ProfileId   string `json:"profile_id"`
+	ProfileName string `json:"profile_name"`
+	// Only set once the app is signed.
+	InstallUrl  string `json:"install_url,omitempty"`
+	DownloadUrl string `json:"download_url,omitempty"`
+}
+
+type Profile struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+	// What expires first, the certificate or the provisioning profile.
+	ExpirySource string    `json:"expiry_source,omitempty"`
+	ExpiresAt    time.Time `json:"expires_at,omitempty"`
+}
+
+type Job struct {
+	Id    string `json:"id"`
+	Error string `json:"error,omitempty"`
+}
+
+// Start sends all app, job and profile events to the webhooks of the current config, until the returned function is called.
+// The config is read for every event, so changes to the webhooks apply immediately.
+// Stopping waits a while for deliveries in progress, so that the last events before a shutdown aren't lost.
+func Start() func() {
+	eventChan, unsubscribe := events.Subscribe(func(event events.Event) bool {
+		return event.Type.IsJobState()
+	})
+	stop := make(chan bool)
+	stopped := make(chan bool)
+	var deliveries sync.WaitGroup
+	handle := func(event events.Event) {
+		webhooks := getWebhooks(event.Type)
+		if len(webhooks) < 1 {
+			return
+		}
+		payload := makePayload(event)
+		body, err := json.Marshal(payload)
+		if err != nil {
+			log.Err(err).Str("event", string(event.Type)).Msg("marshal webhook payload")
+			return
+		}
+		for _, webhook := range webhooks {
+			deliveries.Add(1)
+			go func() {
+				defer deliveries.Done()
+				deliver(webhook, payload, body)
+			}()
+		}
+	}
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case event := <-eventChan:
+				handle(event)
+			case <-stop:
+				// pick up the events published right before stopping
+				for {
+					select {
+					case event := <-eventChan:
+						handle(event)
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+	return func() {
+		unsubscribe()
+		close(stop)
+		<-stopped
+		done := make(chan bool)
+		go func() {
+			deliveries.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(stopTimeout):
+			log.Warn().Msg("stopped waiting for webhook deliveries")
+		}
+	}
+}
+
+func getWebhooks(eventType events.Type) []config.Webhook {
+	var results []config.Webhook
+	for _, webhook := range config.Current.File().Webhooks {
+		if len(webhook.Events) < 1 {
+			results = append(results, webhook)
+			continue
+		}
+		for _, name := range webhook.Events {
+			if events.Type(name) == eventType {
+				results = append(results, webhook)
+				break
+			}
+		}
+	}
+	return results
+}
+
+func makePayload(event events.Event) *Payload {
+	payload := &Payload{
+		Id:        uuid.NewString(),
+		Event:     event.Type,
+		Timestamp: event.Ts,
+		Message:   event.Message,
+	}
+	if event.ProfileId != "" {
+		payload.Profile = &Profile{Id: event.ProfileId}
+		if profile, ok := storage.Profiles.GetById(event.ProfileId); ok {
+			payload.Profile.Name, _ = profile.GetString(storage.ProfileName)
+			if status, ok := expiry.Get(profile, event.Ts); ok {
+				payload.Profile.ExpirySource = status.Source
+				payload.Profile.ExpiresAt = status.ExpiresAt
+			}
+		}
+	}
+	if event.JobId != "" {
+		payload.Job = &Job{Id: event.JobId, Error: event.Error}
+	}
+	app, ok := storage.Apps.Get(event.AppId)
+	if !ok {
+		if event.AppId != "" {
+			payload.App = &App{Id: event.AppId}
+		}
+		return payload
+	}
+	payload.App = &App{Id: app.GetId()}
+	payload.App.Name, _ = app.GetString(storage.AppName)
+	payload.App.BundleId, _ = app.GetString(storage.AppBundleId)
+	payload.App.ProfileId, _ = app.GetString(storage.AppProfileId)
+	if profile, ok := storage.Profiles.GetById(payload.App.ProfileId); ok {
+		payload.App.ProfileName, _ = profile.GetString(storage.ProfileName)
+	}
+	if signed, err := app.IsSigned(); err == nil && signed {
+		payload.App.InstallUrl = makeUrl("/apps", app.GetId(), "install")
+		payload.App.DownloadUrl = makeUrl("/apps", app.GetId(), "signed")
+	}
+	return payload
+}
+
+func makeUrl(elem ...string) string {
+	result, err := util.JoinUrls(config.Current.File().ServerUrl, elem...)
+	if err != nil {
+		return ""
+	}
+	return result
+}
+
+// A delivery failure that won't go away by trying again, like a 404 response.
+type permanentError struct {
+	error
+}
+
+func deliver(webhook config.Webhook, payload *Payload, body []byte) {
+	delay := firstRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := send(webhook, payload, body)
+		if err == nil {
+			return
+		}
+		logger := log.Warn().Err(err).Str("url", webhook.Url).Str("event", string(payload.Event)).Int("attempt", attempt)
+		if errors.As(err, &permanentError{}) || attempt >= maxDeliveryAttempts {
+			logger.Msg("giving up on webhook delivery")
+			return
+		}
+		logger.Msg("webhook delivery failed, retrying")
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func send(webhook config.Webhook, payload *Payload, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, webhook.Url, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{errors.WithMessage(err, "make request")}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "SignTools-Webhook")
+	req.Header.Set("X-Webhook-Event", string(payload.Event))
+	req.Header.Set("X-Webhook-Delivery", payload.Id)
+	if webhook.Secret != "" {
+		req.Header.Set("X-Webhook-Signature-256", "sha256="+sign(webhook.Secret, body))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.WithMessage(err, "send request")
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 500, resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode == http.StatusRequestTimeout:
+		return errors.Errorf("unexpected status %s", resp.Status)
+	default:
+		return permanentError{errors.Errorf("unexpected status %s", resp.Status)}
+	}
+}
+
+// Returns the hex encoded HMAC-SHA256 of the body, keyed with the secret.
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}